@@ -19,7 +19,7 @@ import (
 	"github.com/ipfs/interface-go-ipfs-core/path"
 )
 
-//go:embed init-doc dir-index-html/dir-index.html dir-index-html/knownIcons.txt
+//go:embed init-doc dir-index-html/dir-index.html dir-index-html/knownIcons.txt dag-index-html/dag-index.html
 var Asset embed.FS
 
 // AssetHash a non-cryptographic hash of all embedded assets
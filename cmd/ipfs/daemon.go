@@ -521,6 +521,42 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// construct pinning service api - if Addresses.PinningService is set
+	psErrc, err := serveHTTPListener(cctx, "Pinning Service API server", cfg.Addresses.PinningService,
+		corehttp.MetricsCollectionOption("pinning_service"),
+		corehttp.PinningServiceOption(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// construct webdav server - if Addresses.WebDAV is set
+	davErrc, err := serveHTTPListener(cctx, "WebDAV server", cfg.Addresses.WebDAV,
+		corehttp.MetricsCollectionOption("webdav"),
+		corehttp.WebDAVOption(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// construct s3 api - if Addresses.S3 is set
+	s3Errc, err := serveHTTPListener(cctx, "S3 API server", cfg.Addresses.S3,
+		corehttp.MetricsCollectionOption("s3"),
+		corehttp.S3Option(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// construct delegated routing server - if Addresses.DelegatedRouting is set
+	drErrc, err := serveHTTPListener(cctx, "Delegated routing server", cfg.Addresses.DelegatedRouting,
+		corehttp.MetricsCollectionOption("delegatedrouting"),
+		corehttp.DelegatedRoutingOption(),
+	)
+	if err != nil {
+		return err
+	}
+
 	// Add ipfs version info to prometheus metrics
 	var ipfsInfoMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ipfs_info",
@@ -537,9 +573,23 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// initialize metrics collector
 	prometheus.MustRegister(&corehttp.IpfsNodeCollector{Node: node})
 
+	// process queued requests to remote pinning services
+	if node.RemotePins != nil {
+		go node.RemotePins.Run(req.Context)
+	}
+
 	// start MFS pinning thread
 	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
 
+	// start mirroring local pins to remote services
+	startPinMirror(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
+
+	// remove expired pins
+	reapInterval := cfg.Pinning.ExpiredPinsReapInterval.WithDefault(config.DefaultExpiredPinsReapInterval)
+	if node.PinMeta != nil && reapInterval > 0 {
+		go node.PinMeta.RunReaper(req.Context, node.GCLocker, reapInterval)
+	}
+
 	// The daemon is *finally* ready.
 	fmt.Printf("Daemon is ready\n")
 	notifyReady()
@@ -588,7 +638,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, psErrc, davErrc, s3Errc, drErrc, gcErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -661,7 +711,9 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 	}
 
 	var opts = []corehttp.ServeOption{
+		corehttp.AccessLogOption("api", cfg.API.AccessLog, cctx.ConfigRoot),
 		corehttp.MetricsCollectionOption("api"),
+		corehttp.APIAuthorizationOption(),
 		corehttp.MetricsOpenCensusCollectionOption(),
 		corehttp.MetricsOpenCensusDefaultPrometheusRegistry(),
 		corehttp.CheckVersionOption(),
@@ -809,6 +861,7 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	cmdctx.Gateway = true
 
 	var opts = []corehttp.ServeOption{
+		corehttp.AccessLogOption("gateway", cfg.Gateway.AccessLog, cctx.ConfigRoot),
 		corehttp.MetricsCollectionOption("gateway"),
 		corehttp.HostnameOption(),
 		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
@@ -821,6 +874,10 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		opts = append(opts, corehttp.P2PProxyOption())
 	}
 
+	if cfg.Gateway.PubSub.Enabled() {
+		opts = append(opts, corehttp.PubSubOption())
+	}
+
 	if len(cfg.Gateway.RootRedirect) > 0 {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
@@ -862,6 +919,50 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	return errc, nil
 }
 
+// serveHTTPListener creates the listeners of the named server on addrs,
+// prints status messages and starts serving requests with opts
+func serveHTTPListener(cctx *oldcmds.Context, name string, addrs []string, opts ...corehttp.ServeOption) (<-chan error, error) {
+	var listeners []manet.Listener
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPListener: invalid %s address: %q (err: %s)", name, addr, err)
+		}
+
+		lis, err := manet.Listen(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPListener: manet.Listen(%s) failed: %s", maddr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	for _, listener := range listeners {
+		fmt.Printf("%s listening on %s\n", name, listener.Multiaddr())
+	}
+
+	node, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPListener: ConstructNode() failed: %s", err)
+	}
+
+	errc := make(chan error)
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis manet.Listener) {
+			defer wg.Done()
+			errc <- corehttp.Serve(node, manet.NetListener(lis), opts...)
+		}(lis)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errc)
+	}()
+
+	return errc, nil
+}
+
 // collects options and opens the fuse mountpoint
 func mountFuse(req *cmds.Request, cctx *oldcmds.Context) error {
 	cfg, err := cctx.GetConfig()
@@ -277,6 +277,13 @@ func makeExecutor(req *cmds.Request, env interface{}) (cmds.Executor, error) {
 		cmdhttp.ClientWithAPIPrefix(corehttp.APIPath),
 	}
 
+	// Authenticate with the token passed on the commandline, without sending
+	// it along with the other options.
+	if token, ok := req.Options[corecmds.ApiAuthOption].(string); ok {
+		delete(req.Options, corecmds.ApiAuthOption)
+		opts = append(opts, cmdhttp.ClientWithHeader("Authorization", "Bearer "+token))
+	}
+
 	// Fallback on a local executor if we (a) have a repo and (b) aren't
 	// forcing a daemon.
 	if !daemonRequested && fsrepo.IsInitialized(cctx.ConfigRoot) {
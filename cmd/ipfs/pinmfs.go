@@ -11,20 +11,21 @@ import (
 	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log"
-	pinclient "github.com/ipfs/go-pinning-service-http-client"
 
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/remotepin"
 )
 
 // mfslog is the logger for remote mfs pinning
 var mfslog = logging.Logger("remotepinning/mfs")
 
 type lastPin struct {
-	Time          time.Time
-	ServiceName   string
-	ServiceConfig config.RemotePinningService
-	CID           cid.Cid
+	Time        time.Time
+	ServiceName string
+	ServiceAPI  config.RemotePinningServiceAPI
+	Policy      config.RemotePinningServiceMFSPolicy
+	CID         cid.Cid
 }
 
 func (x lastPin) IsValid() bool {
@@ -43,8 +44,21 @@ type pinMFSNode interface {
 	RootNode() (ipld.Node, error)
 	Identity() peer.ID
 	PeerHost() host.Host
+	Queue() remotePinQueue
 }
 
+// remotePinQueue is the queue the requests of the remote pinning policies are
+// sent to.
+type remotePinQueue interface {
+	Enqueue(ctx context.Context, j remotepin.Job) (remotepin.Job, error)
+	Jobs(ctx context.Context) ([]remotepin.Job, error)
+	Cancel(ctx context.Context, id string) error
+}
+
+// mfsPinJobKey identifies the queued MFS pins, so that a new MFS root
+// supersedes the one waiting to be pinned.
+const mfsPinJobKey = "mfs"
+
 type ipfsPinMFSNode struct {
 	node *core.IpfsNode
 }
@@ -61,6 +75,10 @@ func (x *ipfsPinMFSNode) PeerHost() host.Host {
 	return x.node.PeerHost
 }
 
+func (x *ipfsPinMFSNode) Queue() remotePinQueue {
+	return x.node.RemotePins
+}
+
 func startPinMFS(configPollInterval time.Duration, cctx pinMFSContext, node pinMFSNode) {
 	errCh := make(chan error)
 	go pinMFSOnChange(configPollInterval, cctx, node, errCh)
@@ -163,7 +181,7 @@ func pinAllMFS(ctx context.Context, node pinMFSNode, cfg *config.Config, rootCid
 
 		// do nothing, if MFS has not changed since last pin on the exact same service or waiting for MFS.RepinInterval
 		if last, ok := lastPins[svcName]; ok {
-			if last.ServiceConfig == svcConfig && (last.CID == rootCid || time.Since(last.Time) < repinInterval) {
+			if last.ServiceAPI == svcConfig.API && last.Policy == svcConfig.Policies.MFS && (last.CID == rootCid || time.Since(last.Time) < repinInterval) {
 				if last.CID == rootCid {
 					mfslog.Debugf("pinning MFS root to %q: pin for %q exists since %s, skipping", svcName, rootCid, last.Time.String())
 				} else {
@@ -194,6 +212,9 @@ func pinAllMFS(ctx context.Context, node pinMFSNode, cfg *config.Config, rootCid
 	}
 }
 
+// pinMFS queues a request to pin the MFS root to a remote service. The
+// request supersedes the ones still queued for an older root, and replaces
+// the existing MFS pin on the service once processed.
 func pinMFS(
 	ctx context.Context,
 	node pinMFSNode,
@@ -201,72 +222,46 @@ func pinMFS(
 	svcName string,
 	svcConfig config.RemotePinningService,
 ) (lastPin, error) {
-	c := pinclient.NewClient(svcConfig.API.Endpoint, svcConfig.API.Key)
-
 	pinName := svcConfig.Policies.MFS.PinName
 	if pinName == "" {
 		pinName = fmt.Sprintf("policy/%s/mfs", node.Identity().String())
 	}
 
-	// check if MFS pin exists (across all possible states) and inspect its CID
-	pinStatuses := []pinclient.Status{pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned, pinclient.StatusFailed}
-	lsPinCh, lsErrCh := c.Ls(ctx, pinclient.PinOpts.FilterName(pinName), pinclient.PinOpts.FilterStatus(pinStatuses...))
-	existingRequestID := "" // is there any pre-existing MFS pin with pinName (for any CID)?
-	pinning := false        // is CID for current MFS already being pinned?
-	pinTime := time.Now().UTC()
-	pinStatusMsg := "pinning to %q: received pre-existing %q status for %q (requestid=%q)"
-	for ps := range lsPinCh {
-		existingRequestID = ps.GetRequestId()
-		if ps.GetPin().GetCid() == cid && ps.GetStatus() == pinclient.StatusFailed {
-			mfslog.Errorf(pinStatusMsg, svcName, pinclient.StatusFailed, cid, existingRequestID)
-		} else {
-			mfslog.Debugf(pinStatusMsg, svcName, ps.GetStatus(), ps.GetPin().GetCid(), existingRequestID)
-		}
-		if ps.GetPin().GetCid() == cid && ps.GetStatus() != pinclient.StatusFailed {
-			pinning = true
-			pinTime = ps.GetCreated().UTC()
-			break
-		}
-	}
-	for range lsPinCh { // in case the prior loop exits early
-	}
-	if err := <-lsErrCh; err != nil {
-		return lastPin{}, fmt.Errorf("error while listing remote pins: %v", err)
+	origins, err := pinOrigins(node.PeerHost())
+	if err != nil {
+		return lastPin{}, err
 	}
 
-	// CID of the current MFS root is already being pinned, nothing to do
-	if pinning {
-		mfslog.Debugf("pinning MFS to %q: pin for %q exists since %s, skipping", svcName, cid, pinTime.String())
-		return lastPin{Time: pinTime, ServiceName: svcName, ServiceConfig: svcConfig, CID: cid}, nil
+	mfslog.Debugf("pinning to %q: queueing MFS root pin for %q", svcName, cid)
+	_, err = node.Queue().Enqueue(ctx, remotepin.Job{
+		Service: svcName,
+		Op:      remotepin.OpAdd,
+		Cid:     cid,
+		Name:    pinName,
+		Origins: origins,
+		Replace: true,
+		Key:     mfsPinJobKey,
+	})
+	if err != nil {
+		return lastPin{}, err
 	}
+	return lastPin{Time: time.Now().UTC(), ServiceName: svcName, ServiceAPI: svcConfig.API, Policy: svcConfig.Policies.MFS, CID: cid}, nil
+}
 
-	// Prepare Pin.name
-	addOpts := []pinclient.AddOption{pinclient.PinOpts.WithName(pinName)}
-
-	// Prepare Pin.origins
-	// Add own multiaddrs to the 'origins' array, so Pinning Service can
-	// use that as a hint and connect back to us (if possible)
-	if node.PeerHost() != nil {
-		addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost()))
-		if err != nil {
-			return lastPin{}, err
-		}
-		addOpts = append(addOpts, pinclient.PinOpts.WithOrigins(addrs...))
+// pinOrigins returns the own multiaddrs to send as the 'origins' of remote
+// pins, so Pinning Service can use that as a hint and connect back to us (if
+// possible)
+func pinOrigins(h host.Host) ([]string, error) {
+	if h == nil {
+		return nil, nil
 	}
-
-	// Create or replace pin for MFS root
-	if existingRequestID != "" {
-		mfslog.Debugf("pinning to %q: replacing existing MFS root pin with %q", svcName, cid)
-		_, err := c.Replace(ctx, existingRequestID, cid, addOpts...)
-		if err != nil {
-			return lastPin{}, err
-		}
-	} else {
-		mfslog.Debugf("pinning to %q: creating a new MFS root pin for %q", svcName, cid)
-		_, err := c.Add(ctx, cid, addOpts...)
-		if err != nil {
-			return lastPin{}, err
-		}
+	addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(h))
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		origins = append(origins, a.String())
 	}
-	return lastPin{Time: pinTime, ServiceName: svcName, ServiceConfig: svcConfig, CID: cid}, nil
+	return origins, nil
 }
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	ipld "github.com/ipfs/go-ipld-format"
 	merkledag "github.com/ipfs/go-merkledag"
 	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/remotepin"
 	"github.com/libp2p/go-libp2p/core/host"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 )
@@ -29,7 +31,8 @@ func (x *testPinMFSContext) GetConfig() (*config.Config, error) {
 }
 
 type testPinMFSNode struct {
-	err error
+	err   error
+	queue testPinMFSQueue
 }
 
 func (x *testPinMFSNode) RootNode() (ipld.Node, error) {
@@ -44,6 +47,88 @@ func (x *testPinMFSNode) PeerHost() host.Host {
 	return nil
 }
 
+func (x *testPinMFSNode) Queue() remotePinQueue {
+	return &x.queue
+}
+
+type testPinMFSQueue struct {
+	mu       sync.Mutex
+	enqueued []remotepin.Job // every job enqueued
+	queued   []remotepin.Job // jobs not accepted by the service yet
+}
+
+func (x *testPinMFSQueue) Enqueue(ctx context.Context, j remotepin.Job) (remotepin.Job, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	j.ID = fmt.Sprint(len(x.enqueued))
+	j.State = remotepin.StateQueued
+	queued := x.queued[:0]
+	for _, old := range x.queued {
+		if j.Key == "" || old.Service != j.Service || old.Key != j.Key {
+			queued = append(queued, old)
+		}
+	}
+	x.queued = append(queued, j)
+	x.enqueued = append(x.enqueued, j)
+	return j, nil
+}
+
+func (x *testPinMFSQueue) Jobs(ctx context.Context) ([]remotepin.Job, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return append([]remotepin.Job(nil), x.queued...), nil
+}
+
+// Enqueued returns every job enqueued, in order.
+func (x *testPinMFSQueue) Enqueued() []remotepin.Job {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return append([]remotepin.Job(nil), x.enqueued...)
+}
+
+func (x *testPinMFSQueue) Cancel(ctx context.Context, id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, j := range x.queued {
+		if j.ID == id {
+			x.queued = append(x.queued[:i], x.queued[i+1:]...)
+			return nil
+		}
+	}
+	return remotepin.ErrJobNotFound
+}
+
+// accept marks the queued jobs as accepted by the service, removing them
+// unless they are kept once done.
+func (x *testPinMFSQueue) accept() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	queued := x.queued[:0]
+	for _, j := range x.queued {
+		if j.KeepDone {
+			j.State = remotepin.StateDone
+			queued = append(queued, j)
+		}
+	}
+	x.queued = queued
+}
+
+// fail marks the queued jobs as failed.
+func (x *testPinMFSQueue) fail() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i := range x.queued {
+		x.queued[i].State = remotepin.StateFailed
+	}
+}
+
+// cancel removes the queued jobs, as canceled by the user.
+func (x *testPinMFSQueue) cancel() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.queued = nil
+}
+
 var testConfigPollInterval = time.Second
 
 func isErrorSimilar(e1, e2 error) bool {
@@ -150,8 +235,8 @@ func TestPinMFSService(t *testing.T) {
 		},
 	}
 	testPinMFSServiceWithError(t, cfgInvalidInterval, "remote pinning service \"invalid_interval\" has invalid MFS.RepinInterval")
-	testPinMFSServiceWithError(t, cfgValidUnnamed, "error while listing remote pins: empty response from remote pinning service")
-	testPinMFSServiceWithError(t, cfgValidNamed, "error while listing remote pins: empty response from remote pinning service")
+	testPinMFSServiceQueued(t, cfgValidUnnamed, "valid_unnamed", fmt.Sprintf("policy/%s/mfs", peer.ID("test_id")))
+	testPinMFSServiceQueued(t, cfgValidNamed, "valid_named", "pin_name")
 }
 
 func testPinMFSServiceWithError(t *testing.T, cfg *config.Config, expectedErrorPrefix string) {
@@ -177,3 +262,23 @@ func testPinMFSServiceWithError(t *testing.T, cfg *config.Config, expectedErrorP
 		t.Errorf("expecting error containing %q", expectedErrorPrefix)
 	}
 }
+
+func testPinMFSServiceQueued(t *testing.T, cfg *config.Config, svcName, pinName string) {
+	node := &testPinMFSNode{}
+	rootNode, _ := node.RootNode()
+	errCh := make(chan error, 1)
+	pinAllMFS(context.Background(), node, cfg, rootNode.Cid(), map[string]lastPin{}, errCh)
+	close(errCh)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := node.queue.Enqueued()
+	if len(jobs) != 1 {
+		t.Fatalf("expected one queued pin for %q, got %d", svcName, len(jobs))
+	}
+	j := jobs[0]
+	if j.Service != svcName || j.Op != remotepin.OpAdd || j.Name != pinName || !j.Replace || j.Cid != rootNode.Cid() {
+		t.Errorf("unexpected queued pin %+v", j)
+	}
+}
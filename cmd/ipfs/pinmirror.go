@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/go-mfs"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/remotepin"
+)
+
+// mirrorlog is the logger for mirroring local pins to remote services
+var mirrorlog = logging.Logger("remotepinning/pins")
+
+// mirroredPinsPrefix is where the pins mirrored to each remote service are
+// recorded, so that they can be removed from it once unpinned locally.
+var mirroredPinsPrefix = ds.NewKey("/local/pins/remote/mirror")
+
+// localPin is a recursive local pin, with its metadata.
+type localPin struct {
+	Cid      cid.Cid
+	Metadata pinmeta.Metadata
+}
+
+type pinMirrorNode interface {
+	Identity() peer.ID
+	PeerHost() host.Host
+	Queue() remotePinQueue
+	Datastore() ds.Datastore
+	RecursivePins(ctx context.Context) ([]localPin, error)
+	MFSCids(ctx context.Context, path string) (*cid.Set, error)
+}
+
+func (x *ipfsPinMFSNode) Datastore() ds.Datastore {
+	return x.node.Repo.Datastore()
+}
+
+func (x *ipfsPinMFSNode) RecursivePins(ctx context.Context) ([]localPin, error) {
+	keys, err := x.node.Pinning.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pins := make([]localPin, 0, len(keys))
+	for _, k := range keys {
+		p := localPin{Cid: k}
+		if x.node.PinMeta != nil {
+			if p.Metadata, err = x.node.PinMeta.Store().Get(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+		pins = append(pins, p)
+	}
+	return pins, nil
+}
+
+// MFSCids returns the cids of the MFS node at path, and of every file and
+// directory below it.
+func (x *ipfsPinMFSNode) MFSCids(ctx context.Context, path string) (*cid.Set, error) {
+	fsn, err := mfs.Lookup(x.node.FilesRoot, path)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	set := cid.NewSet()
+	set.Add(nd.Cid())
+
+	var walk func(d *mfs.Directory) error
+	walk = func(d *mfs.Directory) error {
+		var dirs []string
+		err := d.ForEachEntry(ctx, func(e mfs.NodeListing) error {
+			c, err := cid.Decode(e.Hash)
+			if err != nil {
+				return err
+			}
+			set.Add(c)
+			if e.Type == int(mfs.TDir) {
+				dirs = append(dirs, e.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range dirs {
+			child, err := d.Child(name)
+			if err != nil {
+				return err
+			}
+			if cd, ok := child.(*mfs.Directory); ok {
+				if err := walk(cd); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if d, ok := fsn.(*mfs.Directory); ok {
+		if err := walk(d); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func startPinMirror(configPollInterval time.Duration, cctx pinMFSContext, node pinMirrorNode) {
+	errCh := make(chan error)
+	go pinMirrorOnInterval(configPollInterval, cctx, node, errCh)
+	go func() {
+		for {
+			select {
+			case err, isOpen := <-errCh:
+				if !isOpen {
+					return
+				}
+				mirrorlog.Errorf("%v", err)
+			case <-cctx.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func pinMirrorOnInterval(configPollInterval time.Duration, cctx pinMFSContext, node pinMirrorNode, errCh chan<- error) {
+	defer close(errCh)
+
+	tmo := time.NewTimer(configPollInterval)
+	defer tmo.Stop()
+
+	lastReconcile := map[string]time.Time{}
+	for {
+		select {
+		case <-cctx.Context().Done():
+			return
+		case <-tmo.C:
+		}
+		tmo.Reset(configPollInterval)
+
+		// reread the config, which may have changed in the meantime
+		cfg, err := cctx.GetConfig()
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("mirroring pins reading config (%v)", err):
+			case <-cctx.Context().Done():
+				return
+			}
+			continue
+		}
+
+		for svcName, svcConfig := range cfg.Pinning.RemoteServices {
+			policy := svcConfig.Policies.Pins
+			if !policy.Enable {
+				delete(lastReconcile, svcName)
+				continue
+			}
+			interval := policy.ReconcileInterval.WithDefault(config.DefaultRemotePinsReconcileInterval)
+			if last, ok := lastReconcile[svcName]; ok && time.Since(last) < interval {
+				continue
+			}
+			lastReconcile[svcName] = time.Now()
+
+			if err := reconcilePinMirror(cctx.Context(), node, svcName, policy); err != nil {
+				select {
+				case errCh <- fmt.Errorf("mirroring pins to %q (%v)", svcName, err):
+				case <-cctx.Context().Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// mirroredPin is a pin of a service the local pins are mirrored to, or the
+// request queued to add or remove it.
+type mirroredPin struct {
+	Cid  cid.Cid
+	Name string
+
+	// Mirrored is set once the service accepted the pin.
+	Mirrored bool `json:",omitempty"`
+
+	// Job and Op are the ID and the operation of the queued request for the
+	// pin, if any.
+	Job string       `json:",omitempty"`
+	Op  remotepin.Op `json:",omitempty"`
+}
+
+// reconcilePinMirror queues the local pins matching the policy which are not
+// mirrored to the service yet, and the removal of the mirrored ones which no
+// longer match. Pins are only recorded as mirrored once the service accepted
+// them, and requests which failed or were canceled are queued again.
+func reconcilePinMirror(ctx context.Context, node pinMirrorNode, svcName string, policy config.RemotePinningServicePinsPolicy) error {
+	pins, err := node.RecursivePins(ctx)
+	if err != nil {
+		return err
+	}
+
+	var inMFS *cid.Set
+	if policy.MFSPath != "" {
+		if inMFS, err = node.MFSCids(ctx, policy.MFSPath); err != nil {
+			return fmt.Errorf("reading MFS path %q: %w", policy.MFSPath, err)
+		}
+	}
+
+	// Pins are identified by their CID and name, a renamed pin being
+	// removed from the service and added again with its new name.
+	filter := pinmeta.Filter{Name: policy.Name, Labels: policy.Labels}
+	wanted := make(map[string]localPin)
+	for _, p := range pins {
+		if !filter.Match(p.Metadata) || (inMFS != nil && !inMFS.Has(p.Cid)) {
+			continue
+		}
+		if p.Metadata.Name == "" {
+			p.Metadata.Name = fmt.Sprintf("policy/%s/pins", node.Identity().String())
+		}
+		wanted[mirrorPinJobKey(p.Cid, p.Metadata.Name)] = p
+	}
+
+	key := mirroredPinsPrefix.ChildString(svcName)
+	mirrored, err := loadMirroredPins(ctx, node.Datastore(), key)
+	if err != nil {
+		return err
+	}
+
+	q := node.Queue()
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]remotepin.Job, len(jobs))
+	for _, j := range jobs {
+		queued[j.ID] = j
+	}
+	for k, p := range mirrored {
+		if p.Job == "" {
+			continue
+		}
+		j, ok := queued[p.Job]
+		switch {
+		case !ok:
+			// The request was canceled, it is queued again below.
+			mirrorlog.Debugf("%s of pin %q for %s on %q was canceled", p.Op, p.Name, p.Cid, svcName)
+		case j.State == remotepin.StateQueued:
+			// The request is still waiting for the service.
+			continue
+		case j.State == remotepin.StateFailed:
+			// The request failed, it is queued again below.
+			mirrorlog.Debugf("%s of pin %q for %s on %q failed: %s", p.Op, p.Name, p.Cid, svcName, j.LastError)
+		default:
+			// The service accepted the request, which can now be forgotten.
+			if err := q.Cancel(ctx, j.ID); err != nil && err != remotepin.ErrJobNotFound {
+				return err
+			}
+			if p.Op == remotepin.OpRm {
+				delete(mirrored, k)
+				continue
+			}
+			p.Mirrored = true
+		}
+		p.Job, p.Op = "", ""
+	}
+
+	origins, err := pinOrigins(node.PeerHost())
+	if err != nil {
+		return err
+	}
+
+	for k, lp := range wanted {
+		p, ok := mirrored[k]
+		if ok && (p.Op == remotepin.OpAdd || (p.Op == "" && p.Mirrored)) {
+			continue
+		}
+		mirrorlog.Debugf("mirroring pin %q for %s to %q", lp.Metadata.Name, lp.Cid, svcName)
+		j, err := q.Enqueue(ctx, remotepin.Job{
+			Service:  svcName,
+			Op:       remotepin.OpAdd,
+			Cid:      lp.Cid,
+			Name:     lp.Metadata.Name,
+			Origins:  origins,
+			Key:      k,
+			KeepDone: true,
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			p = &mirroredPin{Cid: lp.Cid, Name: lp.Metadata.Name}
+			mirrored[k] = p
+		}
+		p.Job, p.Op = j.ID, j.Op
+	}
+	for k, p := range mirrored {
+		if _, ok := wanted[k]; ok || p.Op == remotepin.OpRm {
+			continue
+		}
+		mirrorlog.Debugf("removing mirrored pin %q for %s from %q", p.Name, p.Cid, svcName)
+		j, err := q.Enqueue(ctx, remotepin.Job{
+			Service:  svcName,
+			Op:       remotepin.OpRm,
+			Cid:      p.Cid,
+			Name:     p.Name,
+			Key:      k,
+			KeepDone: true,
+		})
+		if err != nil {
+			return err
+		}
+		p.Job, p.Op = j.ID, j.Op
+	}
+
+	return saveMirroredPins(ctx, node.Datastore(), key, mirrored)
+}
+
+// mirrorPinJobKey identifies the queued requests for a mirrored pin, so that
+// pinning and unpinning it supersede each other.
+func mirrorPinJobKey(c cid.Cid, name string) string {
+	return "pins/" + c.String() + "/" + name
+}
+
+func loadMirroredPins(ctx context.Context, d ds.Datastore, key ds.Key) (map[string]*mirroredPin, error) {
+	var pins []*mirroredPin
+	b, err := d.Get(ctx, key)
+	switch {
+	case err == ds.ErrNotFound:
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &pins); err != nil {
+			return nil, err
+		}
+	}
+
+	mirrored := make(map[string]*mirroredPin, len(pins))
+	for _, p := range pins {
+		mirrored[mirrorPinJobKey(p.Cid, p.Name)] = p
+	}
+	return mirrored, nil
+}
+
+func saveMirroredPins(ctx context.Context, d ds.Datastore, key ds.Key, mirrored map[string]*mirroredPin) error {
+	pins := make([]*mirroredPin, 0, len(mirrored))
+	for _, p := range mirrored {
+		pins = append(pins, p)
+	}
+	b, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	if err := d.Put(ctx, key, b); err != nil {
+		return err
+	}
+	return d.Sync(ctx, key)
+}
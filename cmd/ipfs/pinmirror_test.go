@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	merkledag "github.com/ipfs/go-merkledag"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/remotepin"
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+type testPinMirrorNode struct {
+	ds    ds.Datastore
+	pins  []localPin
+	mfs   *cid.Set
+	queue testPinMFSQueue
+}
+
+func (x *testPinMirrorNode) Identity() peer.ID {
+	return peer.ID("test_id")
+}
+
+func (x *testPinMirrorNode) PeerHost() host.Host {
+	return nil
+}
+
+func (x *testPinMirrorNode) Queue() remotePinQueue {
+	return &x.queue
+}
+
+func (x *testPinMirrorNode) Datastore() ds.Datastore {
+	return x.ds
+}
+
+func (x *testPinMirrorNode) RecursivePins(ctx context.Context) ([]localPin, error) {
+	return x.pins, nil
+}
+
+func (x *testPinMirrorNode) MFSCids(ctx context.Context, path string) (*cid.Set, error) {
+	return x.mfs, nil
+}
+
+func TestPinMirrorReconcile(t *testing.T) {
+	ctx := context.Background()
+	a := merkledag.NewRawNode([]byte("a")).Cid()
+	b := merkledag.NewRawNode([]byte("b")).Cid()
+	node := &testPinMirrorNode{
+		ds: dssync.MutexWrap(ds.NewMapDatastore()),
+		pins: []localPin{
+			{Cid: a, Metadata: pinmeta.Metadata{Name: "site", Labels: map[string]string{"backup": "yes"}}},
+			{Cid: b, Metadata: pinmeta.Metadata{Labels: map[string]string{"backup": "no"}}},
+		},
+	}
+	policy := config.RemotePinningServicePinsPolicy{
+		Enable: true,
+		Labels: map[string]string{"backup": "yes"},
+	}
+
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs := node.queue.Enqueued()
+	if len(jobs) != 1 || jobs[0].Op != remotepin.OpAdd || jobs[0].Cid != a || jobs[0].Name != "site" {
+		t.Fatalf("expected the labeled pin to be mirrored, got %+v", jobs)
+	}
+
+	// Pins waiting for the service are left alone.
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := node.queue.Enqueued(); len(jobs) != 1 {
+		t.Fatalf("expected no new request, got %+v", jobs)
+	}
+
+	// Requests which failed are queued again.
+	node.queue.fail()
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs = node.queue.Enqueued()
+	if len(jobs) != 2 || jobs[1].Op != remotepin.OpAdd || jobs[1].Cid != a {
+		t.Fatalf("expected the failed request to be queued again, got %+v", jobs)
+	}
+
+	// Pins which are mirrored already are left alone.
+	node.queue.accept()
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := node.queue.Enqueued(); len(jobs) != 2 {
+		t.Fatalf("expected no new request, got %+v", jobs)
+	}
+
+	// Renamed pins are mirrored again under their new name.
+	node.pins[0].Metadata.Name = "blog"
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs = node.queue.Enqueued()[2:]
+	if len(jobs) != 2 || jobs[0].Op != remotepin.OpAdd || jobs[0].Name != "blog" ||
+		jobs[1].Op != remotepin.OpRm || jobs[1].Cid != a || jobs[1].Name != "site" {
+		t.Fatalf("expected the pin to be renamed on the service, got %+v", jobs)
+	}
+	node.queue.accept()
+
+	node.pins = node.pins[1:]
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs = node.queue.Enqueued()[4:]
+	if len(jobs) != 1 || jobs[0].Op != remotepin.OpRm || jobs[0].Cid != a || jobs[0].Name != "blog" {
+		t.Fatalf("expected the unpinned content to be removed from the service, got %+v", jobs)
+	}
+
+	// Removed pins are forgotten once the service accepted the removal.
+	node.queue.accept()
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	mirrored, err := loadMirroredPins(ctx, node.ds, mirroredPinsPrefix.ChildString("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrored) != 0 || len(node.queue.Enqueued()) != 5 {
+		t.Fatalf("expected no mirrored pin left, got %+v", mirrored)
+	}
+}
+
+func TestPinMirrorMFSPath(t *testing.T) {
+	ctx := context.Background()
+	a := merkledag.NewRawNode([]byte("a")).Cid()
+	b := merkledag.NewRawNode([]byte("b")).Cid()
+	node := &testPinMirrorNode{
+		ds:   dssync.MutexWrap(ds.NewMapDatastore()),
+		pins: []localPin{{Cid: a}, {Cid: b}},
+		mfs:  cid.NewSet(),
+	}
+	node.mfs.Add(b)
+	policy := config.RemotePinningServicePinsPolicy{
+		Enable:  true,
+		MFSPath: "/backup",
+	}
+
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs := node.queue.Enqueued()
+	if len(jobs) != 1 || jobs[0].Cid != b || jobs[0].Name != "policy/"+peer.ID("test_id").String()+"/pins" {
+		t.Fatalf("expected only the pin found in MFS to be mirrored, got %+v", jobs)
+	}
+}
+
+func TestPinMirrorCanceled(t *testing.T) {
+	ctx := context.Background()
+	a := merkledag.NewRawNode([]byte("a")).Cid()
+	node := &testPinMirrorNode{
+		ds:   dssync.MutexWrap(ds.NewMapDatastore()),
+		pins: []localPin{{Cid: a, Metadata: pinmeta.Metadata{Name: "site"}}},
+	}
+	policy := config.RemotePinningServicePinsPolicy{Enable: true}
+
+	// Canceled requests are not mistaken for accepted ones.
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	node.queue.cancel()
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs := node.queue.Enqueued()
+	if len(jobs) != 2 || jobs[1].Op != remotepin.OpAdd || jobs[1].Cid != a {
+		t.Fatalf("expected the canceled pin request to be queued again, got %+v", jobs)
+	}
+
+	node.queue.accept()
+	node.pins = nil
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := node.queue.Jobs(ctx); len(queued) != 1 || queued[0].Op != remotepin.OpRm {
+		t.Fatalf("expected the accepted request to be forgotten and the removal queued, got %+v", queued)
+	}
+	node.queue.cancel()
+	if err := reconcilePinMirror(ctx, node, "svc", policy); err != nil {
+		t.Fatal(err)
+	}
+	jobs = node.queue.Enqueued()
+	if len(jobs) != 4 || jobs[3].Op != remotepin.OpRm || jobs[3].Cid != a {
+		t.Fatalf("expected the canceled removal to be queued again, got %+v", jobs)
+	}
+	mirrored, err := loadMirroredPins(ctx, node.ds, mirroredPinsPrefix.ChildString("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrored) != 1 {
+		t.Fatalf("expected the mirrored pin to be kept until removed, got %+v", mirrored)
+	}
+}
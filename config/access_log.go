@@ -0,0 +1,33 @@
+package config
+
+const (
+	AccessLogFormatCombined = "combined"
+	AccessLogFormatJSON     = "json"
+
+	// AccessLogStdout is the AccessLog.Path writing to the standard output.
+	AccessLogStdout = "stdout"
+
+	DefaultAccessLogMaxSize    = 100 << 20
+	DefaultAccessLogMaxBackups = 3
+)
+
+// AccessLog configures the per-request log of an HTTP server.
+type AccessLog struct {
+	// Path is the file the log is appended to, relative to the repo when
+	// not absolute, or "stdout". The log is disabled when empty.
+	Path string `json:",omitempty"`
+
+	// Format is either "combined" (the Combined Log Format) or "json".
+	Format *OptionalString `json:",omitempty"`
+
+	// MaxSize is the size, in bytes, over which the file is rotated.
+	// Setting to 0 disables rotation.
+	MaxSize *OptionalInteger `json:",omitempty"`
+
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups *OptionalInteger `json:",omitempty"`
+
+	// RedactQueryStrings removes the query strings from the logged URLs,
+	// including the Referer.
+	RedactQueryStrings bool `json:",omitempty"`
+}
@@ -8,4 +8,9 @@ type Addresses struct {
 	NoAnnounce     []string // swarm addresses not to announce to the network
 	API            Strings  // address for the local API (RPC)
 	Gateway        Strings  // address to listen on for IPFS HTTP object gateway
+	PinningService Strings  `json:",omitempty"` // address to listen on for the Pinning Service API
+	WebDAV         Strings  `json:",omitempty"` // address to listen on for the WebDAV server
+	S3             Strings  `json:",omitempty"` // address to listen on for the S3-compatible API
+
+	DelegatedRouting Strings `json:",omitempty"` // address to listen on for the delegated routing server
 }
@@ -1,5 +1,50 @@
 package config
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
 type API struct {
 	HTTPHeaders map[string][]string // HTTP headers to return with the API.
+
+	// Authorizations are the bearer tokens accepted by the RPC API, by name.
+	// When there are none, the RPC API does not require authentication.
+	Authorizations map[string]*APIAuthorization `json:",omitempty"`
+
+	// AccessLog configures the log of the requests made to the RPC API.
+	AccessLog AccessLog
+}
+
+// APIAuthorization is a bearer token accepted by the RPC API, restricted to
+// a set of commands.
+type APIAuthorization struct {
+	// TokenHash is the hex encoded SHA-256 hash of the token.
+	TokenHash string
+
+	// AllowedPaths are the commands the token grants access to, like "cat"
+	// or "pin/ls". A command also grants access to its subcommands, and "/"
+	// grants access to all of them.
+	AllowedPaths []string
+}
+
+// HashAPIToken returns the hash of an RPC API token, as stored in
+// APIAuthorization.TokenHash.
+func HashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Allows returns whether the authorization grants access to the command at
+// path, like "pin/ls".
+func (a *APIAuthorization) Allows(path string) bool {
+	path = strings.Trim(path, "/")
+	for _, p := range a.AllowedPaths {
+		p = strings.Trim(p, "/")
+		if p == "" || path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
 }
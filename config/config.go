@@ -36,6 +36,9 @@ type Config struct {
 	Experimental Experiments
 	Plugins      Plugins
 	Pinning      Pinning
+	Denylist     Denylist
+	WebDAV       WebDAV
+	S3           S3
 
 	Internal Internal // experimental/unstable options
 }
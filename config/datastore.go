@@ -12,6 +12,7 @@ type Datastore struct {
 	StorageMax         string // in B, kB, kiB, MB, ...
 	StorageGCWatermark int64  // in percentage to multiply on StorageMax
 	GCPeriod           string // in ns, us, ms, s, m, h
+	GCIncremental      Flag   `json:",omitempty"`
 
 	// deprecated fields, use Spec
 	Type   string           `json:",omitempty"`
@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+// DefaultDenylistReloadInterval is the default value of
+// Denylist.ReloadInterval.
+const DefaultDenylistReloadInterval = 10 * time.Second
+
+// Denylist configures the content the node refuses to serve.
+type Denylist struct {
+	// Files are the denylist files, relative to the repo unless absolute.
+	// The gateway refuses to serve the content they list.
+	Files []string `json:",omitempty"`
+
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval *OptionalDuration `json:",omitempty"`
+
+	// Bitswap makes bitswap refuse to send blocked blocks to other peers.
+	Bitswap Flag `json:",omitempty"`
+
+	// Commands makes 'ipfs cat' and 'ipfs get' refuse blocked content.
+	Commands Flag `json:",omitempty"`
+}
@@ -1,6 +1,12 @@
 package config
 
-const DefaultInlineDNSLink = false
+const (
+	DefaultInlineDNSLink = false
+
+	DefaultGatewayCacheMaxSize      = 32 << 20
+	DefaultGatewayCacheMaxEntrySize = 256 << 10
+	DefaultGatewayCacheMaxDiskSize  = 1 << 30
+)
 
 type GatewaySpec struct {
 	// Paths is explicit list of path prefixes that should be handled by
@@ -68,4 +74,88 @@ type Gateway struct {
 	// PublicGateways configures behavior of known public gateways.
 	// Each key is a fully qualified domain name (FQDN).
 	PublicGateways map[string]*GatewaySpec
+
+	// RateLimits configures the admission control of the gateway.
+	RateLimits GatewayRateLimits
+
+	// Cache configures the cache of resolved paths and small responses, in
+	// memory and optionally on disk.
+	Cache GatewayCache
+
+	// AccessLog configures the log of the requests made to the gateway.
+	AccessLog AccessLog
+
+	// PubSub configures the bridge between HTTP clients and pubsub topics.
+	PubSub GatewayPubSub
+}
+
+// GatewayPubSub configures the pubsub bridge of the gateway, which lets HTTP
+// clients subscribe to topics with Server-Sent Events and publish to them
+// with POST requests. It is enabled when topics are allowed.
+type GatewayPubSub struct {
+	// SubscribeTopics are the topics clients can subscribe to. "*" allows
+	// all topics.
+	SubscribeTopics []string `json:",omitempty"`
+
+	// PublishTopics are the topics clients can publish to. "*" allows all
+	// topics.
+	PublishTopics []string `json:",omitempty"`
+}
+
+// Enabled returns whether clients can subscribe or publish to any topic.
+func (ps *GatewayPubSub) Enabled() bool {
+	return len(ps.SubscribeTopics) > 0 || len(ps.PublishTopics) > 0
+}
+
+// GatewayCache configures the cache of the gateway. Only immutable data is
+// cached: /ipfs/ paths resolved to their CID, and small files and blocks by
+// CID. IPNS names and DNSLinks are resolved on every request, through the
+// namesys cache that honors their TTLs.
+type GatewayCache struct {
+	// MaxSize is the memory, in bytes, used by the cache. Setting to 0
+	// disables the cache, including on disk.
+	MaxSize *OptionalInteger `json:",omitempty"`
+
+	// MaxEntrySize is the size, in bytes, of the largest file or block
+	// cached.
+	MaxEntrySize *OptionalInteger `json:",omitempty"`
+
+	// Namespace is the datastore namespace where the cached files and blocks
+	// are also kept, so that they outlive their eviction from memory and
+	// restarts. They are only kept in memory when it is empty.
+	Namespace string `json:",omitempty"`
+
+	// MaxDiskSize is the space, in bytes, used by the cache in Namespace.
+	MaxDiskSize *OptionalInteger `json:",omitempty"`
+}
+
+// GatewayRateLimits limits the requests the gateway accepts. Requests over
+// the limits are refused with 429 Too Many Requests. Zero values disable the
+// corresponding limit.
+type GatewayRateLimits struct {
+	// RequestsPerSecond is the rate of requests accepted from each client IP.
+	RequestsPerSecond *OptionalInteger `json:",omitempty"`
+
+	// Burst is the number of requests a client IP can make at once.
+	// Defaults to RequestsPerSecond when unset or zero.
+	Burst *OptionalInteger `json:",omitempty"`
+
+	// ExpensiveRequestsPerSecond is the rate of expensive requests (CAR, TAR
+	// and ZIP responses, generated directory listings and pubsub
+	// subscriptions) accepted from each client IP, on top of
+	// RequestsPerSecond.
+	ExpensiveRequestsPerSecond *OptionalInteger `json:",omitempty"`
+
+	// ExpensiveBurst is the number of expensive requests a client IP can
+	// make at once. Defaults to ExpensiveRequestsPerSecond when unset or
+	// zero.
+	ExpensiveBurst *OptionalInteger `json:",omitempty"`
+
+	// MaxConcurrentRequests is the number of requests the gateway serves at
+	// once, across all clients.
+	MaxConcurrentRequests *OptionalInteger `json:",omitempty"`
+
+	// TrustedProxies are the IPs and CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For header tells the client IP.
+	TrustedProxies []string `json:",omitempty"`
 }
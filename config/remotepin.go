@@ -1,12 +1,39 @@
 package config
 
+import "time"
+
+// DefaultExpiredPinsReapInterval is the default value of
+// Pinning.ExpiredPinsReapInterval.
+const DefaultExpiredPinsReapInterval = time.Minute
+
+// DefaultRemotePinsReconcileInterval is the default value of
+// Pinning.RemoteServices.<name>.Policies.Pins.ReconcileInterval.
+const DefaultRemotePinsReconcileInterval = 5 * time.Minute
+
 var (
 	RemoteServicesPath     = "Pinning.RemoteServices"
 	PinningConcealSelector = []string{"Pinning", "RemoteServices", "*", "API", "Key"}
+
+	// PinningServiceConcealSelector selects the secrets of the pinning
+	// service served by the node.
+	PinningServiceConcealSelector = []string{"Pinning", "Service", "AccessTokens"}
 )
 
 type Pinning struct {
 	RemoteServices map[string]RemotePinningService
+
+	// ExpiredPinsReapInterval is how often the daemon removes expired pins.
+	ExpiredPinsReapInterval *OptionalDuration `json:",omitempty"`
+
+	// Service configures the Pinning Service API served on
+	// Addresses.PinningService.
+	Service PinningService
+}
+
+// PinningService configures the Pinning Service API served by the node.
+type PinningService struct {
+	// AccessTokens are the bearer tokens accepted from clients.
+	AccessTokens []string `json:",omitempty"`
 }
 
 type RemotePinningService struct {
@@ -20,7 +47,8 @@ type RemotePinningServiceAPI struct {
 }
 
 type RemotePinningServicePolicies struct {
-	MFS RemotePinningServiceMFSPolicy
+	MFS  RemotePinningServiceMFSPolicy
+	Pins RemotePinningServicePinsPolicy
 }
 
 type RemotePinningServiceMFSPolicy struct {
@@ -31,3 +59,20 @@ type RemotePinningServiceMFSPolicy struct {
 	// RepinInterval determines the repin interval when the policy is enabled. In ns, us, ms, s, m, h.
 	RepinInterval string
 }
+
+// RemotePinningServicePinsPolicy mirrors the recursive local pins to the
+// remote service, and removes them from it once they are unpinned locally.
+type RemotePinningServicePinsPolicy struct {
+	// Enable enables mirroring the local pins.
+	Enable bool
+	// Name only mirrors the local pins with this name.
+	Name string `json:",omitempty"`
+	// Labels only mirrors the local pins with all of these labels.
+	Labels map[string]string `json:",omitempty"`
+	// MFSPath only mirrors the local pins of content found under this MFS
+	// path.
+	MFSPath string `json:",omitempty"`
+	// ReconcileInterval is how often the local pins are compared with the
+	// ones mirrored to the service.
+	ReconcileInterval *OptionalDuration `json:",omitempty"`
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 // Routing defines configuration options for libp2p routing
@@ -20,7 +21,7 @@ type Routing struct {
 
 type Router struct {
 
-	// Currenly supported Types are "reframe", "dht", "parallel", "sequential".
+	// Currenly supported Types are "reframe", "dht", "static", "cache", "parallel", "sequential".
 	// Reframe type allows to add other resolvers using the Reframe spec:
 	// https://github.com/ipfs/specs/tree/main/reframe
 	// In the future we will support "dht" and other Types here.
@@ -82,6 +83,10 @@ func (r *RouterParser) UnmarshalJSON(b []byte) error {
 		p = &ReframeRouterParams{}
 	case RouterTypeDHT:
 		p = &DHTRouterParams{}
+	case RouterTypeStatic:
+		p = &StaticRouterParams{}
+	case RouterTypeCache:
+		p = &CacheRouterParams{}
 	case RouterTypeSequential:
 		p = &ComposableRouterParams{}
 	case RouterTypeParallel:
@@ -105,6 +110,8 @@ type RouterType string
 const (
 	RouterTypeReframe    RouterType = "reframe"
 	RouterTypeDHT        RouterType = "dht"
+	RouterTypeStatic     RouterType = "static"
+	RouterTypeCache      RouterType = "cache"
 	RouterTypeSequential RouterType = "sequential"
 	RouterTypeParallel   RouterType = "parallel"
 )
@@ -141,6 +148,64 @@ type DHTRouterParams struct {
 	PublicIPNetwork      bool
 }
 
+// Defaults of the parameters of static routers.
+const (
+	// DefaultStaticRouterReloadInterval is how often the file of a static
+	// router is checked for changes.
+	DefaultStaticRouterReloadInterval = 10 * time.Second
+
+	// DefaultStaticRouterProvideTTL is how long provide records are kept,
+	// like the provider records of the DHT.
+	DefaultStaticRouterProvideTTL = 24 * time.Hour
+)
+
+type StaticRouterParams struct {
+	// Path is a JSON or CSV file listing providers and peer addresses. It is
+	// reloaded when it changes. Relative paths are relative to the repo.
+	Path string `json:",omitempty"`
+
+	// Namespace is the datastore namespace where the provider records
+	// received by the router are stored. They are kept in memory when it is
+	// empty.
+	Namespace string `json:",omitempty"`
+
+	// ReloadInterval is how often Path is checked for changes.
+	ReloadInterval *OptionalDuration `json:",omitempty"`
+
+	// ProvideTTL is how long the provider records received by the router
+	// are kept, unless they are provided again.
+	ProvideTTL *OptionalDuration `json:",omitempty"`
+}
+
+// Defaults of the parameters of cache routers.
+const (
+	DefaultCacheRouterProvidersTTL = 5 * time.Minute
+	DefaultCacheRouterPeersTTL     = 10 * time.Minute
+	DefaultCacheRouterNegativeTTL  = time.Minute
+	DefaultCacheRouterMaxEntries   = 10000
+)
+
+type CacheRouterParams struct {
+	// RouterName is the name of the router whose results are cached.
+	RouterName string
+
+	// ProvidersTTL and PeersTTL are how long the providers of a CID, and
+	// the addresses of a peer, are cached.
+	ProvidersTTL *OptionalDuration `json:",omitempty"`
+	PeersTTL     *OptionalDuration `json:",omitempty"`
+
+	// NegativeTTL is how long lookups which found nothing are cached.
+	NegativeTTL *OptionalDuration `json:",omitempty"`
+
+	// MaxEntries bounds the number of cached lookups of each kind.
+	MaxEntries *OptionalInteger `json:",omitempty"`
+
+	// Namespace is the datastore namespace where the cached lookups are
+	// persisted across restarts. They are only kept in memory when it is
+	// empty.
+	Namespace string `json:",omitempty"`
+}
+
 type ComposableRouterParams struct {
 	Routers []ConfigRouter
 	Timeout *OptionalDuration `json:",omitempty"`
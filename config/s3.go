@@ -0,0 +1,12 @@
+package config
+
+// S3ConcealSelector selects the secrets of the S3-compatible API.
+var S3ConcealSelector = []string{"S3", "Credentials"}
+
+// S3 configures the S3-compatible API served on Addresses.S3, where buckets
+// are the top-level directories of MFS.
+type S3 struct {
+	// Credentials maps the access key IDs accepted from clients to their
+	// secret access keys, used to verify AWS Signature Version 4.
+	Credentials map[string]string `json:",omitempty"`
+}
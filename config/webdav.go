@@ -0,0 +1,12 @@
+package config
+
+// WebDAVConcealSelector selects the secrets of the WebDAV server.
+var WebDAVConcealSelector = []string{"WebDAV", "AccessTokens"}
+
+// WebDAV configures the WebDAV server giving access to MFS, served on
+// Addresses.WebDAV.
+type WebDAV struct {
+	// AccessTokens are the tokens accepted from clients, as bearer tokens or
+	// basic auth passwords.
+	AccessTokens []string `json:",omitempty"`
+}
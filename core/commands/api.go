@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	config "github.com/ipfs/kubo/config"
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+)
+
+const (
+	apiTokenAllowOptionName = "allow"
+	apiTokenNameArgName     = "name"
+)
+
+// APICmd is the 'ipfs api' command.
+var APICmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage access to the RPC API.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"token": apiTokenCmd,
+	},
+}
+
+var apiTokenCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the tokens accepted by the RPC API.",
+		ShortDescription: `
+Once a token exists, every request to the RPC API must carry one in its
+'Authorization: Bearer <token>' header. Requests without a valid token are
+rejected with '401 Unauthorized', and requests for commands the token is not
+allowed to run with '403 Forbidden'.
+
+The CLI sends the token passed with the global '--api-auth' option:
+
+  $ ipfs --api-auth=<token> cat <cid>
+
+Tokens are kept in API.Authorizations in the config, as a hash.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"create": apiTokenCreateCmd,
+		"ls":     apiTokenLsCmd,
+		"revoke": apiTokenRevokeCmd,
+	},
+}
+
+// APIToken is an RPC API token.
+type APIToken struct {
+	Name         string
+	Token        string `json:",omitempty"`
+	AllowedPaths []string
+}
+
+var apiTokenCreateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a token for the RPC API.",
+		ShortDescription: `
+Creates a token allowed to run the commands passed with '--allow', and their
+subcommands. '--allow=/' allows all the commands.
+
+  $ ipfs api token create --allow=cat,add,pin/ls reader
+
+The token is only shown once, and cannot be recovered later. Remember to
+create a token for yourself before the first one for someone else, as the CLI
+also needs one once tokens are in use.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg(apiTokenNameArgName, true, false, "Name of the token."),
+	},
+	Options: []cmds.Option{
+		cmds.DelimitedStringsOption(",", apiTokenAllowOptionName, "Commands the token is allowed to run, like 'cat' or 'pin/ls' (comma-separated)."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		name := req.Arguments[0]
+		allowed, _ := req.Options[apiTokenAllowOptionName].([]string)
+		if len(allowed) == 0 {
+			return fmt.Errorf("a token must be allowed to run at least one command, pass --%s", apiTokenAllowOptionName)
+		}
+		for i, p := range allowed {
+			if p = strings.Trim(p, "/"); p == "" {
+				p = "/"
+			}
+			allowed[i] = p
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token := base64.RawURLEncoding.EncodeToString(b)
+
+		err := updateAPIAuthorizations(env, func(auths map[string]*config.APIAuthorization) error {
+			if _, ok := auths[name]; ok {
+				return fmt.Errorf("token %q already exists", name)
+			}
+			auths[name] = &config.APIAuthorization{
+				TokenHash:    config.HashAPIToken(token),
+				AllowedPaths: allowed,
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &APIToken{Name: name, Token: token, AllowedPaths: allowed})
+	},
+	Type: APIToken{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *APIToken) error {
+			fmt.Fprintln(w, out.Token)
+			return nil
+		}),
+	},
+}
+
+var apiTokenLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the tokens of the RPC API.",
+	},
+
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		r, err := fsrepo.Open(cfgRoot)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(cfg.API.Authorizations))
+		for name := range cfg.API.Authorizations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var allowed []string
+			if auth := cfg.API.Authorizations[name]; auth != nil {
+				allowed = auth.AllowedPaths
+			}
+			if err := res.Emit(&APIToken{Name: name, AllowedPaths: allowed}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: APIToken{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *APIToken) error {
+			fmt.Fprintf(w, "%s\t%s\n", out.Name, strings.Join(out.AllowedPaths, ","))
+			return nil
+		}),
+	},
+}
+
+var apiTokenRevokeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Revoke tokens of the RPC API.",
+		ShortDescription: "Revoked tokens are rejected right away, including by a running daemon.",
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg(apiTokenNameArgName, true, true, "Name of the token."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return updateAPIAuthorizations(env, func(auths map[string]*config.APIAuthorization) error {
+			for _, name := range req.Arguments {
+				if _, ok := auths[name]; !ok {
+					return fmt.Errorf("token %q not found", name)
+				}
+				delete(auths, name)
+			}
+			return nil
+		})
+	},
+}
+
+// updateAPIAuthorizations applies update to a copy of API.Authorizations,
+// and saves the result.
+func updateAPIAuthorizations(env cmds.Environment, update func(map[string]*config.APIAuthorization) error) error {
+	cfgRoot, err := cmdenv.GetConfigRoot(env)
+	if err != nil {
+		return err
+	}
+	r, err := fsrepo.Open(cfgRoot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	// the config is shared with the running daemon, so work on a copy
+	cfg, err = cfg.Clone()
+	if err != nil {
+		return err
+	}
+	if cfg.API.Authorizations == nil {
+		cfg.API.Authorizations = make(map[string]*config.APIAuthorization)
+	}
+	if err := update(cfg.API.Authorizations); err != nil {
+		return err
+	}
+	return r.SetConfig(cfg)
+}
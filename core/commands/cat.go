@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/denylist"
 
 	"github.com/cheggaaa/pb"
 	"github.com/ipfs/go-ipfs-cmds"
@@ -60,6 +61,12 @@ var CatCmd = &cmds.Command{
 			return err
 		}
 
+		for _, p := range req.Arguments {
+			if err := checkDenylist(req.Context, env, api, path.New(p)); err != nil {
+				return err
+			}
+		}
+
 		readers, length, err := cat(req.Context, api, req.Arguments, int64(offset), int64(max))
 		if err != nil {
 			return err
@@ -121,6 +128,37 @@ var CatCmd = &cmds.Command{
 	},
 }
 
+// checkDenylist returns an error if the denylist blocks p, and the node
+// enforces it on commands.
+func checkDenylist(ctx context.Context, env cmds.Environment, api iface.CoreAPI, p path.Path) error {
+	node, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	if node.Denylist == nil {
+		return nil
+	}
+	cfg, err := node.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if !cfg.Denylist.Commands.WithDefault(false) {
+		return nil
+	}
+
+	if err := node.Denylist.CheckPath(denylist.SourceCommands, p.String()); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	rp, err := api.ResolvePath(ctx, p)
+	if err != nil {
+		return err
+	}
+	if err := node.Denylist.CheckCid(denylist.SourceCommands, rp.Cid()); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
 func cat(ctx context.Context, api iface.CoreAPI, paths []string, offset int64, max int64) ([]io.Reader, uint64, error) {
 	readers := make([]io.Reader, 0, len(paths))
 	length := uint64(0)
@@ -74,6 +74,11 @@ func TestROCommands(t *testing.T) {
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/api",
+		"/api/token",
+		"/api/token/create",
+		"/api/token/ls",
+		"/api/token/revoke",
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
@@ -207,6 +212,10 @@ func TestCommands(t *testing.T) {
 		"/pin/remote/add",
 		"/pin/remote/ls",
 		"/pin/remote/rm",
+		"/pin/remote/queue",
+		"/pin/remote/queue/cancel",
+		"/pin/remote/queue/ls",
+		"/pin/remote/queue/retry",
 		"/pin/remote/service",
 		"/pin/remote/service/add",
 		"/pin/remote/service/ls",
@@ -238,6 +247,7 @@ func TestCommands(t *testing.T) {
 		"/stats/dht",
 		"/stats/provide",
 		"/stats/repo",
+		"/stats/routing-cache",
 		"/swarm",
 		"/swarm/addrs",
 		"/swarm/addrs/listen",
@@ -213,6 +213,21 @@ NOTE: For security reasons, this command will omit your private key and remote s
 			return err
 		}
 
+		cfg, err = scrubOptionalValue(cfg, config.PinningServiceConcealSelector)
+		if err != nil {
+			return err
+		}
+
+		cfg, err = scrubOptionalValue(cfg, config.WebDAVConcealSelector)
+		if err != nil {
+			return err
+		}
+
+		cfg, err = scrubOptionalValue(cfg, config.S3ConcealSelector)
+		if err != nil {
+			return err
+		}
+
 		return cmds.EmitOnce(res, &cfg)
 	},
 	Encoders: cmds.EncoderMap{
@@ -561,6 +576,33 @@ func replaceConfig(r repo.Repo, file io.Reader) error {
 		}
 	}
 
+	// Handle Pinning.Service (AccessTokens are secrets, hidden by 'config show')
+	if len(newCfg.Pinning.Service.AccessTokens) == 0 {
+		oldCfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		newCfg.Pinning.Service.AccessTokens = oldCfg.Pinning.Service.AccessTokens
+	}
+
+	// Handle WebDAV (AccessTokens are secrets, hidden by 'config show')
+	if len(newCfg.WebDAV.AccessTokens) == 0 {
+		oldCfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		newCfg.WebDAV.AccessTokens = oldCfg.WebDAV.AccessTokens
+	}
+
+	// Handle S3 (Credentials are secrets, hidden by 'config show')
+	if len(newCfg.S3.Credentials) == 0 {
+		oldCfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		newCfg.S3.Credentials = oldCfg.S3.Credentials
+	}
+
 	return r.SetConfig(&newCfg)
 }
 
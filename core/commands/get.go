@@ -3,6 +3,7 @@ package commands
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,10 +14,12 @@ import (
 
 	"github.com/ipfs/kubo/core/commands/cmdenv"
 	"github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/coreunix"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/ipfs/tar-utils"
 )
@@ -26,6 +29,7 @@ var ErrInvalidCompressionLevel = errors.New("compression level must be between 1
 const (
 	outputOptionName           = "output"
 	archiveOptionName          = "archive"
+	archiveFormatOptionName    = "archive-format"
 	compressOptionName         = "compress"
 	compressionLevelOptionName = "compression-level"
 )
@@ -40,6 +44,9 @@ By default, the output will be stored at './<ipfs-path>', but an alternate
 path can be specified with '--output=<path>' or '-o=<path>'.
 
 To output a TAR archive instead of unpacked files, use '--archive' or '-a'.
+To output a ZIP archive instead, use '--archive-format=zip'. ZIP archives are
+always compressed, and cannot be combined with '--compress' or
+'--compression-level'.
 
 To compress the output with GZIP compression, use '--compress' or '-C'. You
 may also specify the level of compression by specifying '-l=<1-9>'.
@@ -52,11 +59,15 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 	Options: []cmds.Option{
 		cmds.StringOption(outputOptionName, "o", "The path where the output should be stored."),
 		cmds.BoolOption(archiveOptionName, "a", "Output a TAR archive."),
+		cmds.StringOption(archiveFormatOptionName, "Format of the archive: tar or zip. Implies --archive. Default: tar."),
 		cmds.BoolOption(compressOptionName, "C", "Compress the output with GZIP compression."),
 		cmds.IntOption(compressionLevelOptionName, "l", "The level of compression (1-9)."),
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data.").WithDefault(true),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		if _, _, err := getArchiveFormat(req); err != nil {
+			return err
+		}
 		_, err := getCompressOptions(req)
 		return err
 	},
@@ -66,6 +77,10 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		if err != nil {
 			return err
 		}
+		format, archive, err := getArchiveFormat(req)
+		if err != nil {
+			return err
+		}
 
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
@@ -74,6 +89,10 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 
 		p := path.New(req.Arguments[0])
 
+		if err := checkDenylist(ctx, env, api, p); err != nil {
+			return err
+		}
+
 		file, err := api.Unixfs().Get(ctx, p)
 		if err != nil {
 			return err
@@ -86,8 +105,13 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 
 		res.SetLength(uint64(size))
 
-		archive, _ := req.Options[archiveOptionName].(bool)
-		reader, err := fileArchive(file, p.String(), archive, cmplvl)
+		var reader io.ReadCloser
+		if format == archiveFormatZip {
+			file.Close()
+			reader, err = zipArchive(ctx, api, p, p.String())
+		} else {
+			reader, err = fileArchive(file, p.String(), archive, cmplvl)
+		}
 		if err != nil {
 			return err
 		}
@@ -122,16 +146,21 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 				return err
 			}
 
-			archive, _ := req.Options[archiveOptionName].(bool)
+			format, archive, err := getArchiveFormat(req)
+			if err != nil {
+				return err
+			}
+
 			progress, _ := req.Options[progressOptionName].(bool)
 
 			gw := getWriter{
-				Out:         os.Stdout,
-				Err:         os.Stderr,
-				Archive:     archive,
-				Compression: cmplvl,
-				Size:        int64(res.Length()),
-				Progress:    progress,
+				Out:           os.Stdout,
+				Err:           os.Stderr,
+				Archive:       archive,
+				ArchiveFormat: format,
+				Compression:   cmplvl,
+				Size:          int64(res.Length()),
+				Progress:      progress,
 			}
 
 			return gw.Write(outReader, outPath)
@@ -189,10 +218,11 @@ type getWriter struct {
 	Out io.Writer // for output to user
 	Err io.Writer // for progress bar output
 
-	Archive     bool
-	Compression int
-	Size        int64
-	Progress    bool
+	Archive       bool
+	ArchiveFormat string
+	Compression   int
+	Size          int64
+	Progress      bool
 }
 
 func (gw *getWriter) Write(r io.Reader, fpath string) error {
@@ -203,9 +233,13 @@ func (gw *getWriter) Write(r io.Reader, fpath string) error {
 }
 
 func (gw *getWriter) writeArchive(r io.Reader, fpath string) error {
-	// adjust file name if tar
+	// adjust file name if tar or zip
 	if gw.Archive {
-		if !strings.HasSuffix(fpath, ".tar") && !strings.HasSuffix(fpath, ".tar.gz") {
+		if gw.ArchiveFormat == archiveFormatZip {
+			if !strings.HasSuffix(fpath, ".zip") {
+				fpath += ".zip"
+			}
+		} else if !strings.HasSuffix(fpath, ".tar") && !strings.HasSuffix(fpath, ".tar.gz") {
 			fpath += ".tar"
 		}
 	}
@@ -265,6 +299,33 @@ func getCompressOptions(req *cmds.Request) (int, error) {
 	return cmplvl, nil
 }
 
+// Formats of the archives output by get.
+const (
+	archiveFormatTar = "tar"
+	archiveFormatZip = "zip"
+)
+
+// getArchiveFormat returns the format of the archive to output, and whether
+// to output an archive at all: with --archive, or an explicit
+// --archive-format.
+func getArchiveFormat(req *cmds.Request) (format string, archive bool, err error) {
+	archive, _ = req.Options[archiveOptionName].(bool)
+	format, found := req.Options[archiveFormatOptionName].(string)
+	switch format {
+	case "", archiveFormatTar:
+		return archiveFormatTar, archive || found, nil
+	case archiveFormatZip:
+		if cmprs, _ := req.Options[compressOptionName].(bool); cmprs {
+			return "", false, fmt.Errorf("--%s cannot be used with --%s=zip, ZIP archives are always compressed", compressOptionName, archiveFormatOptionName)
+		}
+		if _, ok := req.Options[compressionLevelOptionName].(int); ok {
+			return "", false, fmt.Errorf("--%s cannot be used with --%s=zip, ZIP archives are always compressed", compressionLevelOptionName, archiveFormatOptionName)
+		}
+		return archiveFormatZip, true, nil
+	}
+	return "", false, fmt.Errorf("unsupported archive format %q, must be tar or zip", format)
+}
+
 // DefaultBufSize is the buffer size for gets. for now, 1MiB, which is ~4 blocks.
 // TODO: does this need to be configurable?
 var DefaultBufSize = 1048576
@@ -349,6 +410,25 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	return piper, nil
 }
 
+func zipArchive(ctx context.Context, api coreiface.CoreAPI, p path.Path, name string) (io.ReadCloser, error) {
+	rp, err := api.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	_, filename := gopath.Split(gopath.Clean(name))
+
+	piper, pipew := io.Pipe()
+	go func() {
+		bufw := bufio.NewWriterSize(pipew, DefaultBufSize)
+		err := coreunix.WriteZip(ctx, api, rp.Cid(), filename, bufw)
+		if err == nil {
+			err = bufw.Flush()
+		}
+		_ = pipew.CloseWithError(err)
+	}()
+	return piper, nil
+}
+
 func newMaybeGzWriter(w io.Writer, compression int) (io.WriteCloser, error) {
 	if compression != gzip.NoCompression {
 		return gzip.NewWriterLevel(w, compression)
@@ -66,3 +66,43 @@ func TestGetOutputPath(t *testing.T) {
 		})
 	}
 }
+
+func TestGetArchiveFormat(t *testing.T) {
+	cases := []struct {
+		opts    cmds.OptMap
+		format  string
+		archive bool
+		err     bool
+	}{
+		{opts: cmds.OptMap{}, format: archiveFormatTar},
+		{opts: cmds.OptMap{"archive": true}, format: archiveFormatTar, archive: true},
+		{opts: cmds.OptMap{"archive-format": "tar"}, format: archiveFormatTar, archive: true},
+		{opts: cmds.OptMap{"archive-format": "zip"}, format: archiveFormatZip, archive: true},
+		{opts: cmds.OptMap{"archive-format": "zip", "compress": true}, err: true},
+		{opts: cmds.OptMap{"archive-format": "zip", "compression-level": 9}, err: true},
+		{opts: cmds.OptMap{"archive-format": "rar"}, err: true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%s-%d", t.Name(), i), func(t *testing.T) {
+			req, err := cmds.NewRequest(context.Background(), []string{}, tc.opts, []string{"/ipfs/cid"}, nil, GetCmd)
+			if err != nil {
+				t.Fatalf("error creating a command request: %v", err)
+			}
+
+			format, archive, err := getArchiveFormat(req)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tc.format || archive != tc.archive {
+				t.Errorf("expected %s (archive: %t), got %s (archive: %t)", tc.format, tc.archive, format, archive)
+			}
+		})
+	}
+}
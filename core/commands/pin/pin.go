@@ -3,9 +3,12 @@ package pin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	bserv "github.com/ipfs/go-blockservice"
@@ -22,6 +25,8 @@ import (
 	core "github.com/ipfs/kubo/core"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/pinmeta"
 )
 
 var PinCmd = &cmds.Command{
@@ -51,12 +56,35 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinExpiresInOptionName = "expires-in"
+	pinExpiresAtOptionName = "expires-at"
+	pinLabelOptionName     = "label"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Pins can be made to expire with --expires-in or --expires-at. Once expired,
+the objects are treated as unpinned, by 'ipfs pin ls' and the garbage
+collector alike, and the daemon removes
+the pin (see Pinning.ExpiredPinsReapInterval). Pinning an object again
+without these options makes its pin permanent, and an object which is pinned
+permanently stays so when pinned again with them.
+
+Pins can be given a name with --name, and key/value labels with --label,
+which can be repeated. Pinning an object again updates its name and adds to
+its labels. Both are kept when the pin is moved with 'ipfs pin update', and
+can be used to filter 'ipfs pin ls'.
+
+Examples:
+  > ipfs pin add --expires-in=72h <cid>
+  > ipfs pin add --expires-at=2030-01-01T00:00:00Z <cid>
+  > ipfs pin add --name=website --label=owner=alice --label=project=www <cid>
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +93,10 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinExpiresInOptionName, "Remove the pin after the given duration, e.g. 72h."),
+		cmds.StringOption(pinExpiresAtOptionName, "Remove the pin at the given time, in RFC 3339 format."),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
+		cmds.StringsOption(pinLabelOptionName, "A label for the pin, as key=value. Can be repeated."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -77,6 +109,11 @@ var addPinCmd = &cmds.Command{
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
 
+		meta, err := pinAddMetadata(req)
+		if err != nil {
+			return err
+		}
+
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
 		}
@@ -87,7 +124,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, meta)
 			if err != nil {
 				return err
 			}
@@ -105,7 +142,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, meta)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -181,7 +218,15 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, meta *pinmeta.Metadata) ([]string, error) {
+	var mapi coreapi.PinMetadataAPI
+	if meta != nil {
+		var err error
+		if mapi, err = pinMetadataAPI(api); err != nil {
+			return nil, err
+		}
+	}
+
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -189,7 +234,12 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if meta != nil {
+			err = mapi.AddWithMetadata(ctx, rp, *meta, options.Pin.Recursive(recursive))
+		} else {
+			err = api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive))
+		}
+		if err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -198,6 +248,84 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 	return added, nil
 }
 
+// pinAddMetadata returns the metadata requested with the options of pin add,
+// or nil when none was.
+func pinAddMetadata(req *cmds.Request) (*pinmeta.Metadata, error) {
+	expires, err := pinExpiry(req)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := pinLabels(req)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := req.Options[pinNameOptionName].(string)
+
+	meta := pinmeta.Metadata{Name: name, Labels: labels, Expires: expires}
+	if meta.IsZero() {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+// pinLabels parses the key=value pairs given with --label.
+func pinLabels(req *cmds.Request) (map[string]string, error) {
+	pairs, _ := req.Options[pinLabelOptionName].([]string)
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid --%s %q, must be key=value", pinLabelOptionName, pair)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// pinExpiry returns the expiry requested with --expires-in or --expires-at,
+// or nil for a permanent pin.
+func pinExpiry(req *cmds.Request) (*time.Time, error) {
+	in, hasIn := req.Options[pinExpiresInOptionName].(string)
+	at, hasAt := req.Options[pinExpiresAtOptionName].(string)
+
+	switch {
+	case hasIn && hasAt:
+		return nil, fmt.Errorf("--%s and --%s cannot be used together", pinExpiresInOptionName, pinExpiresAtOptionName)
+	case hasIn:
+		d, err := time.ParseDuration(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %w", pinExpiresInOptionName, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("--%s must be positive", pinExpiresInOptionName)
+		}
+		t := time.Now().Add(d).UTC()
+		return &t, nil
+	case hasAt:
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %w", pinExpiresAtOptionName, err)
+		}
+		t = t.UTC()
+		return &t, nil
+	default:
+		return nil, nil
+	}
+}
+
+// pinMetadataAPI returns the pin API of api with access to pin metadata.
+func pinMetadataAPI(api coreiface.CoreAPI) (coreapi.PinMetadataAPI, error) {
+	mapi, ok := api.Pin().(coreapi.PinMetadataAPI)
+	if !ok {
+		return nil, errors.New("pin metadata is not supported by this node")
+	}
+	return mapi, nil
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove object from pin-list.",
@@ -300,6 +428,10 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Use --name and --label to only list the pins with the given name, or
+carrying the given labels. Indirect pins have no name nor labels, so they are
+never listed when filtering.
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -324,6 +456,8 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "Return pins with the given name (case-sensitive, exact match)."),
+		cmds.StringsOption(pinLabelOptionName, "Return pins with the given label, as key=value. Can be repeated."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -334,6 +468,12 @@ Example:
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
 
+		var filter pinmeta.Filter
+		filter.Name, _ = req.Options[pinNameOptionName].(string)
+		if filter.Labels, err = pinLabels(req); err != nil {
+			return err
+		}
+
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
 		default:
@@ -347,15 +487,20 @@ Example:
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:    obj.PinLsObject.Type,
+					Name:    obj.PinLsObject.Name,
+					Labels:  obj.PinLsObject.Labels,
+					Expires: obj.PinLsObject.Expires,
+				}
 				return nil
 			}
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, filter, api, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, filter, api, emit)
 		}
 		if err != nil {
 			return err
@@ -390,7 +535,8 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					o := out.PinLsObject
+					fmt.Fprintf(w, "%s %s%s\n", o.Cid, o.Type, formatPinMetadata(o.Name, o.Labels, o.Expires))
 				}
 				return nil
 			}
@@ -399,7 +545,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, formatPinMetadata(v.Name, v.Labels, v.Expires))
 				}
 			}
 
@@ -408,6 +554,27 @@ Example:
 	},
 }
 
+// formatPinMetadata formats the metadata of a pin to follow its type in the
+// text output of pin ls.
+func formatPinMetadata(name string, labels map[string]string, expires *time.Time) string {
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, " %q", name)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, labels[k])
+	}
+	if expires != nil {
+		b.WriteString(" expires " + expires.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
 // PinLsOutputWrapper is the output type of the pin ls command.
 // Pin ls needs to output two different type depending on if it's streamed or not.
 // We use this to bypass the cmds lib refusing to have interface{}
@@ -423,16 +590,22 @@ type PinLsList struct {
 
 // PinLsType contains the type of a pin
 type PinLsType struct {
-	Type string
+	Type    string
+	Name    string            `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string            `json:",omitempty"`
+	Type    string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsKeys(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -464,16 +637,30 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			return fmt.Errorf("path '%s' is not pinned", p)
 		}
 
+		var meta pinmeta.Metadata
 		switch pinType {
-		case "direct", "indirect", "recursive", "internal":
+		case "direct", "recursive":
+			if mapi, err := pinMetadataAPI(api); err == nil {
+				meta, err = mapi.Metadata(req.Context, rp)
+				if err != nil {
+					return err
+				}
+			}
+		case "indirect", "internal":
 		default:
 			pinType = "indirect through " + pinType
 		}
+		if !filter.Match(meta) {
+			continue
+		}
 
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
+				Type:    pinType,
+				Cid:     enc.Encode(rp.Cid()),
+				Name:    meta.Name,
+				Labels:  meta.Labels,
+				Expires: meta.Expires,
 			},
 		})
 		if err != nil {
@@ -484,7 +671,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -502,7 +689,16 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		panic("unhandled pin type")
 	}
 
-	pins, err := api.Pin().Ls(req.Context, opt)
+	var pins <-chan coreiface.Pin
+	if filter.IsZero() {
+		pins, err = api.Pin().Ls(req.Context, opt)
+	} else {
+		var mapi coreapi.PinMetadataAPI
+		if mapi, err = pinMetadataAPI(api); err != nil {
+			return err
+		}
+		pins, err = mapi.LsMatching(req.Context, filter, opt)
+	}
 	if err != nil {
 		return err
 	}
@@ -511,10 +707,17 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		if err := p.Err(); err != nil {
 			return err
 		}
+		var meta pinmeta.Metadata
+		if mp, ok := p.(coreapi.PinWithMetadata); ok {
+			meta = mp.Metadata()
+		}
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
+				Type:    p.Type(),
+				Cid:     enc.Encode(p.Path().Cid()),
+				Name:    meta.Name,
+				Labels:  meta.Labels,
+				Expires: meta.Expires,
 			},
 		})
 		if err != nil {
@@ -541,6 +744,8 @@ derivative of an existing one, particularly for large objects. This allows a mor
 efficient DAG-traversal which fully skips already-pinned branches from the old
 object. As a requirement, the old object needs to be an existing recursive
 pin.
+
+The name, labels and expiry of the old pin are carried over to the new one.
 `,
 	},
 
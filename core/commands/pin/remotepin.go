@@ -22,9 +22,11 @@ import (
 	path "github.com/ipfs/interface-go-ipfs-core/path"
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/remotepin"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/libp2p/go-libp2p/core/host"
 	peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 var log = logging.Logger("core/commands/cmdenv")
@@ -38,6 +40,7 @@ var remotePinCmd = &cmds.Command{
 		"add":     addRemotePinCmd,
 		"ls":      listRemotePinCmd,
 		"rm":      rmRemotePinCmd,
+		"queue":   remotePinQueueCmd,
 		"service": remotePinServiceCmd,
 	},
 }
@@ -64,6 +67,8 @@ const pinServiceKeyArgName = "key"
 const pinServiceStatOptionName = "stat"
 const pinBackgroundOptionName = "background"
 const pinForceOptionName = "force"
+const pinQueueOptionName = "queue"
+const pinJobIDArgName = "job-id"
 
 type RemotePinOutput struct {
 	Status string
@@ -116,6 +121,14 @@ after remote service confirms 'queued' status, add the '--background' flag:
 
 Status of background pin requests can be inspected with the 'ls' command.
 
+To hand the request over to the daemon instead, add the '--queue' flag:
+
+  $ ipfs pin remote add --service=mysrv --name=mypin --queue bafkqaaa
+
+Queued requests are kept in the repo and sent by the daemon, which retries
+them with a backoff while the service is unreachable. They can be inspected
+with 'ipfs pin remote queue ls'.
+
 To list all pins for the CID across all statuses:
 
   $ ipfs pin remote ls --service=mysrv --cid=bafkqaaa --status=queued \
@@ -135,6 +148,7 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 		pinServiceNameOption,
 		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
 		cmds.BoolOption(pinBackgroundOptionName, "Add to the queue on the remote service and return immediately (does not wait for pinned status).").WithDefault(false),
+		cmds.BoolOption(pinQueueOptionName, "Add to the local queue of remote pin requests and return immediately.").WithDefault(false),
 	},
 	Type: RemotePinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -146,6 +160,7 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 		if err != nil {
 			return err
 		}
+		queue, _ := req.Options[pinQueueOptionName].(bool)
 
 		// Prepare value for Pin.cid
 		if len(req.Arguments) != 1 {
@@ -180,16 +195,38 @@ NOTE: a comma-separated notation is supported in CLI for convenience:
 			return err
 		}
 
+		var origins []ma.Multiaddr
 		if isInBlockstore && node.PeerHost != nil {
-			addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost))
+			origins, err = peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost))
 			if err != nil {
 				return err
 			}
-			opts = append(opts, pinclient.PinOpts.WithOrigins(addrs...))
+			opts = append(opts, pinclient.PinOpts.WithOrigins(origins...))
 		} else if isInBlockstore && !node.IsOnline && cmds.GetEncoding(req, cmds.Text) == cmds.Text {
 			fmt.Fprintf(os.Stdout, "WARNING: the local node is offline and remote pinning may fail if there is no other provider for this CID\n")
 		}
 
+		// Leave the request to the daemon when --queue is passed
+		if queue {
+			q, err := getRemotePinQueue(env)
+			if err != nil {
+				return err
+			}
+			j := remotepin.Job{
+				Service: req.Options[pinServiceNameOptionName].(string),
+				Op:      remotepin.OpAdd,
+				Cid:     rp.Cid(),
+			}
+			j.Name, _ = req.Options[pinNameOptionName].(string)
+			for _, o := range origins {
+				j.Origins = append(j.Origins, o.String())
+			}
+			if _, err := q.Enqueue(ctx, j); err != nil {
+				return err
+			}
+			return res.Emit(RemotePinOutput{Status: "enqueued", Cid: j.Cid.String(), Name: j.Name})
+		}
+
 		// Execute remote pin request
 		// TODO: fix panic when pinning service is down
 		ps, err := c.Add(ctx, rp.Cid(), opts...)
@@ -322,17 +359,12 @@ func lsRemote(ctx context.Context, req *cmds.Request, c *pinclient.Client) (chan
 		}
 		opts = append(opts, pinclient.PinOpts.FilterCIDs(parsedCIDs...))
 	}
-	if statusRaw, statusFound := req.Options[pinStatusOptionName]; statusFound {
-		statusRawArr := statusRaw.([]string)
-		var parsedStatuses []pinclient.Status
-		for _, rawStatus := range statusRawArr {
-			s := pinclient.Status(rawStatus)
-			if s.String() == string(pinclient.StatusUnknown) {
-				return nil, nil, fmt.Errorf("status %q is not valid", rawStatus)
-			}
-			parsedStatuses = append(parsedStatuses, s)
-		}
-		opts = append(opts, pinclient.PinOpts.FilterStatus(parsedStatuses...))
+	statuses, err := remotePinStatuses(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(statuses) > 0 {
+		opts = append(opts, pinclient.PinOpts.FilterStatus(statuses...))
 	}
 
 	psCh, errCh := c.Ls(ctx, opts...)
@@ -340,6 +372,23 @@ func lsRemote(ctx context.Context, req *cmds.Request, c *pinclient.Client) (chan
 	return psCh, errCh, nil
 }
 
+// remotePinStatuses returns the statuses passed with --status, if any.
+func remotePinStatuses(req *cmds.Request) ([]pinclient.Status, error) {
+	statusRaw, statusFound := req.Options[pinStatusOptionName]
+	if !statusFound {
+		return nil, nil
+	}
+	var parsedStatuses []pinclient.Status
+	for _, rawStatus := range statusRaw.([]string) {
+		s := pinclient.Status(rawStatus)
+		if s.String() == string(pinclient.StatusUnknown) {
+			return nil, fmt.Errorf("status %q is not valid", rawStatus)
+		}
+		parsedStatuses = append(parsedStatuses, s)
+	}
+	return parsedStatuses, nil
+}
+
 var rmRemotePinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Remove pins from remote pinning service.",
@@ -367,6 +416,13 @@ To list and then remove all pending pin requests, pass an explicit status list:
   $ ipfs pin remote ls --service=mysrv --status=queued,pinning,failed
   $ ipfs pin remote rm --service=mysrv --status=queued,pinning,failed --force
 
+To leave the removal to the daemon, pass '--queue' along with the CIDs to
+unpin. The pins of each CID matching '--name' and '--status' are removed once
+the service is reachable. Without '--force', the request fails when more than
+one pin of a CID matches:
+
+  $ ipfs pin remote rm --service=mysrv --cid=bafkqaaa --queue
+
 `,
 	},
 
@@ -377,6 +433,7 @@ To list and then remove all pending pin requests, pass an explicit status list:
 		cmds.DelimitedStringsOption(",", pinCIDsOptionName, "Remove pins for the specified CIDs."),
 		cmds.DelimitedStringsOption(",", pinStatusOptionName, "Remove pins with the specified statuses (queued,pinning,pinned,failed).").WithDefault([]string{"pinned"}),
 		cmds.BoolOption(pinForceOptionName, "Allow removal of multiple pins matching the query without additional confirmation.").WithDefault(false),
+		cmds.BoolOption(pinQueueOptionName, "Add to the local queue of remote pin requests and return immediately (requires --cid).").WithDefault(false),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		ctx, cancel := context.WithCancel(req.Context)
@@ -387,6 +444,10 @@ To list and then remove all pending pin requests, pass an explicit status list:
 			return err
 		}
 
+		if queue, _ := req.Options[pinQueueOptionName].(bool); queue {
+			return queueRemotePinRm(ctx, req, env)
+		}
+
 		rmIDs := []string{}
 		if len(req.Arguments) == 0 {
 			psCh, errCh, err := lsRemote(ctx, req, c)
@@ -416,6 +477,178 @@ To list and then remove all pending pin requests, pass an explicit status list:
 	},
 }
 
+// queueRemotePinRm queues the removal of the pins of every CID passed
+// with --cid.
+func queueRemotePinRm(ctx context.Context, req *cmds.Request, env cmds.Environment) error {
+	if len(req.Arguments) != 0 {
+		return fmt.Errorf("unexpected argument %q", req.Arguments[0])
+	}
+	rawCIDs, _ := req.Options[pinCIDsOptionName].([]string)
+	if len(rawCIDs) == 0 {
+		return fmt.Errorf("queued removals require at least one --%s", pinCIDsOptionName)
+	}
+
+	statuses, err := remotePinStatuses(req)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		// Like the service does when no status is given.
+		statuses = []pinclient.Status{pinclient.StatusPinned}
+	}
+	force, _ := req.Options[pinForceOptionName].(bool)
+
+	q, err := getRemotePinQueue(env)
+	if err != nil {
+		return err
+	}
+
+	var jobs []remotepin.Job
+	for _, rawCID := range rawCIDs {
+		parsedCID, err := cid.Decode(rawCID)
+		if err != nil {
+			return fmt.Errorf("CID %q cannot be parsed: %v", rawCID, err)
+		}
+		j := remotepin.Job{
+			Service:  req.Options[pinServiceNameOptionName].(string),
+			Op:       remotepin.OpRm,
+			Cid:      parsedCID,
+			Statuses: statuses,
+			Force:    force,
+		}
+		j.Name, _ = req.Options[pinNameOptionName].(string)
+		jobs = append(jobs, j)
+	}
+	for _, j := range jobs {
+		if _, err := q.Enqueue(ctx, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remote pin queue commands
+
+var remotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the local queue of remote pin requests.",
+		ShortDescription: `
+Requests made with 'ipfs pin remote add --queue' and 'ipfs pin remote rm
+--queue', and the pins of the MFS policy, are kept in a queue in the repo and
+sent by the daemon. A request leaves the queue once the service accepts it.
+
+Requests to an unreachable service are retried with an increasing delay. After
+too many attempts they are marked as failed, and stay in the queue until they
+are retried or canceled.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":     lsRemotePinQueueCmd,
+		"retry":  retryRemotePinQueueCmd,
+		"cancel": cancelRemotePinQueueCmd,
+	},
+}
+
+var lsRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List queued remote pin requests.",
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceNameOptionName, "Only list the requests to this remote pinning service."),
+	},
+	Type: remotepin.Job{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		q, err := getRemotePinQueue(env)
+		if err != nil {
+			return err
+		}
+		jobs, err := q.Jobs(req.Context)
+		if err != nil {
+			return err
+		}
+
+		service, _ := req.Options[pinServiceNameOptionName].(string)
+		for _, j := range jobs {
+			if service != "" && j.Service != service {
+				continue
+			}
+			if err := res.Emit(j); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *remotepin.Job) error {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d", out.ID, out.Service, out.Op, out.Cid, out.State, out.Attempts)
+			if out.LastError != "" {
+				fmt.Fprintf(w, "\t%s", cmdenv.EscNonPrint(out.LastError))
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
+
+var retryRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Retry queued remote pin requests right away.",
+		ShortDescription: "Resets the attempts of the given requests, including failed ones, and retries them.",
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg(pinJobIDArgName, true, true, "ID of the request to retry, as listed by 'ipfs pin remote queue ls'."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		q, err := getRemotePinQueue(env)
+		if err != nil {
+			return err
+		}
+		for _, id := range req.Arguments {
+			if err := q.Retry(req.Context, id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		return nil
+	},
+}
+
+var cancelRemotePinQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Remove requests from the queue of remote pin requests.",
+		ShortDescription: "Removes the given requests from the queue. Requests already accepted by the service are not affected.",
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg(pinJobIDArgName, true, true, "ID of the request to cancel, as listed by 'ipfs pin remote queue ls'."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		q, err := getRemotePinQueue(env)
+		if err != nil {
+			return err
+		}
+		for _, id := range req.Arguments {
+			if err := q.Cancel(req.Context, id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		return nil
+	},
+}
+
+func getRemotePinQueue(env cmds.Environment) (*remotepin.Queue, error) {
+	node, err := cmdenv.GetNode(env)
+	if err != nil {
+		return nil, err
+	}
+	if node.RemotePins == nil {
+		return nil, fmt.Errorf("the queue of remote pin requests is not available")
+	}
+	return node.RemotePins, nil
+}
+
 // remote service commands
 
 var addRemotePinServiceCmd = &cmds.Command{
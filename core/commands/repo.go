@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"text/tabwriter"
@@ -14,6 +15,7 @@ import (
 	oldcmds "github.com/ipfs/kubo/commands"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	corerepo "github.com/ipfs/kubo/core/corerepo"
+	"github.com/ipfs/kubo/gc"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations/ipfsfetcher"
@@ -49,14 +51,23 @@ var RepoCmd = &cmds.Command{
 
 // GcResult is the result returned by "repo gc" command.
 type GcResult struct {
-	Key   cid.Cid
-	Error string `json:",omitempty"`
+	Key      cid.Cid
+	Error    string       `json:",omitempty"`
+	Progress *gc.Progress `json:",omitempty"`
+
+	// Set by dry runs only.
+	Size   uint64     `json:",omitempty"`
+	Codec  string     `json:",omitempty"`
+	Report *gc.Report `json:",omitempty"`
 }
 
 const (
 	repoStreamErrorsOptionName   = "stream-errors"
 	repoQuietOptionName          = "quiet"
 	repoSilentOptionName         = "silent"
+	repoIncrementalOptionName    = "incremental"
+	repoDryRunOptionName         = "dry-run"
+	repoTopRootsOptionName       = "top-roots"
 	repoAllowDowngradeOptionName = "allow-downgrade"
 )
 
@@ -67,12 +78,36 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+`,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+By default the blockstore is locked for the whole run, so adding and
+pinning content has to wait until the garbage collection is done. With
+--incremental, the reachable blocks are marked and the repo is scanned
+without holding the lock, and it is only taken for the final sweep.
+Blocks written in the meantime are kept. The progress of each phase
+is reported as it happens. The default is taken from
+Datastore.GCIncremental.
+
+With --dry-run nothing is removed. Instead, every object that would be
+removed is listed with its size and codec, followed by a report with
+the totals by codec and the pins and MFS roots keeping the most data
+alive. Objects reachable from several roots are counted for the first
+one: recursive pins, then MFS, then direct pins. Use --enc=json to get
+a report that can be compared between runs. As the repo is not locked,
+the report may be inaccurate if content is added or pinned meanwhile.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
 		cmds.BoolOption(repoSilentOptionName, "Write no output."),
+		cmds.BoolOption(repoIncrementalOptionName, "Only lock the repo for the final sweep, and report progress."),
+		cmds.BoolOption(repoDryRunOptionName, "Report what would be removed without removing anything."),
+		cmds.IntOption(repoTopRootsOptionName, "Number of roots listed in the --dry-run report, -1 for all.").WithDefault(gc.DefaultTopRoots),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -82,8 +117,31 @@ order to reclaim hard disk space.
 
 		silent, _ := req.Options[repoSilentOptionName].(bool)
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
+		incremental, ok := req.Options[repoIncrementalOptionName].(bool)
+		if !ok {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				return err
+			}
+			incremental = cfg.Datastore.GCIncremental.WithDefault(false)
+		}
+
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+		topRoots, _ := req.Options[repoTopRootsOptionName].(int)
+
+		if dryRun {
+			if incremental && ok {
+				return fmt.Errorf("--%s and --%s cannot be used together", repoDryRunOptionName, repoIncrementalOptionName)
+			}
+			return repoGcDryRun(req, re, corerepo.DryRunGarbageCollectAsync(n, req.Context, topRoots))
+		}
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
+		var gcOutChan <-chan gc.Result
+		if incremental {
+			gcOutChan = corerepo.IncrementalGarbageCollectAsync(n, req.Context)
+		} else {
+			gcOutChan = corerepo.GarbageCollectAsync(n, req.Context)
+		}
 
 		if streamErrors {
 			errs := false
@@ -93,6 +151,10 @@ order to reclaim hard disk space.
 						return err
 					}
 					errs = true
+				} else if res.Progress != nil {
+					if err := re.Emit(&GcResult{Progress: res.Progress}); err != nil {
+						return err
+					}
 				} else {
 					if err := re.Emit(&GcResult{Key: res.KeyRemoved}); err != nil {
 						return err
@@ -103,7 +165,7 @@ order to reclaim hard disk space.
 				return errors.New("encountered errors during gc run")
 			}
 		} else {
-			err := corerepo.CollectResult(req.Context, gcOutChan, func(k cid.Cid) {
+			err := corerepo.CollectResultWithProgress(req.Context, gcOutChan, func(k cid.Cid) {
 				if silent {
 					return
 				}
@@ -111,6 +173,11 @@ order to reclaim hard disk space.
 				// most likely means that the client is gone but
 				// we still need to let the GC finish.
 				_ = re.Emit(&GcResult{Key: k})
+			}, func(p *gc.Progress) {
+				if silent {
+					return
+				}
+				_ = re.Emit(&GcResult{Progress: p})
 			})
 			if err != nil {
 				return err
@@ -134,17 +201,107 @@ order to reclaim hard disk space.
 				return err
 			}
 
-			prefix := "removed "
+			if r := gcr.Report; r != nil {
+				if quiet {
+					return nil
+				}
+				return writeGcReport(w, r)
+			}
+
+			if p := gcr.Progress; p != nil {
+				if quiet {
+					return nil
+				}
+				state := "in progress"
+				if p.Done {
+					state = "done"
+				}
+				_, err := fmt.Fprintf(w, "%s phase %s: %d marked, %d written, %d candidates, %d removed\n",
+					p.Phase, state, p.Marked, p.Written, p.Candidates, p.Removed)
+				return err
+			}
+
 			if quiet {
-				prefix = ""
+				_, err := fmt.Fprintf(w, "%s\n", gcr.Key)
+				return err
 			}
 
-			_, err := fmt.Fprintf(w, "%s%s\n", prefix, gcr.Key)
+			if gcr.Codec != "" {
+				_, err := fmt.Fprintf(w, "would remove %s (%s, %s)\n", gcr.Key, humanize.Bytes(gcr.Size), gcr.Codec)
+				return err
+			}
+
+			_, err := fmt.Fprintf(w, "removed %s\n", gcr.Key)
 			return err
 		}),
 	},
 }
 
+// repoGcDryRun emits the results of a dry run. Errors are only emitted with
+// --stream-errors, and returned at the end otherwise.
+func repoGcDryRun(req *cmds.Request, re cmds.ResponseEmitter, gcOutChan <-chan gc.Result) error {
+	silent, _ := req.Options[repoSilentOptionName].(bool)
+	streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
+
+	var errs []error
+	for res := range gcOutChan {
+		var gcr *GcResult
+		switch {
+		case res.Error != nil:
+			errs = append(errs, res.Error)
+			if !streamErrors {
+				continue
+			}
+			gcr = &GcResult{Error: res.Error.Error()}
+		case res.Report != nil:
+			gcr = &GcResult{Report: res.Report}
+		case silent:
+			continue
+		default:
+			gcr = &GcResult{Key: res.KeyRemoved, Size: res.Size, Codec: res.Codec}
+		}
+		if err := re.Emit(gcr); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case len(errs) == 0:
+		return nil
+	case streamErrors:
+		return errors.New("encountered errors during gc run")
+	case len(errs) == 1:
+		return errs[0]
+	default:
+		return corerepo.NewMultiError(errs...)
+	}
+}
+
+func writeGcReport(w io.Writer, r *gc.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Would remove %d blocks (%s).\n", r.Removed.Blocks, humanize.Bytes(r.Removed.Size))
+	codecs := make([]string, 0, len(r.Codecs))
+	for codec := range r.Codecs {
+		codecs = append(codecs, codec)
+	}
+	sort.Strings(codecs)
+	for _, codec := range codecs {
+		t := r.Codecs[codec]
+		fmt.Fprintf(tw, "  %s\t%d blocks\t%s\n", codec, t.Blocks, humanize.Bytes(t.Size))
+	}
+
+	fmt.Fprintf(tw, "Would keep %d blocks (%s).\n", r.Kept.Blocks, humanize.Bytes(r.Kept.Size))
+	if len(r.Roots) > 0 {
+		fmt.Fprintln(tw, "Largest roots:")
+	}
+	for _, root := range r.Roots {
+		fmt.Fprintf(tw, "  %s\t%s\t%d blocks\t%s\n", root.Cid, root.Kind, root.Blocks, humanize.Bytes(root.Size))
+	}
+
+	return tw.Flush()
+}
+
 const (
 	repoSizeOnlyOptionName = "size-only"
 	repoHumanOptionName    = "human"
@@ -26,12 +26,13 @@ const (
 	LocalOption      = "local" // DEPRECATED: use OfflineOption
 	OfflineOption    = "offline"
 	ApiOption        = "api" //nolint
+	ApiAuthOption    = "api-auth"
 )
 
 var Root = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:  "Global p2p merkle-dag filesystem.",
-		Synopsis: "ipfs [--config=<config> | -c] [--debug | -D] [--help] [-h] [--api=<api>] [--offline] [--cid-base=<base>] [--upgrade-cidv0-in-output] [--encoding=<encoding> | --enc] [--timeout=<timeout>] <command> ...",
+		Synopsis: "ipfs [--config=<config> | -c] [--debug | -D] [--help] [-h] [--api=<api>] [--api-auth=<token>] [--offline] [--cid-base=<base>] [--upgrade-cidv0-in-output] [--encoding=<encoding> | --enc] [--timeout=<timeout>] <command> ...",
 		Subcommands: `
 BASIC COMMANDS
   init          Initialize local IPFS configuration
@@ -75,6 +76,7 @@ NETWORK COMMANDS
 
 TOOL COMMANDS
   config        Manage configuration
+  api           Manage access to the RPC API
   version       Show IPFS version information
   diag          Generate diagnostic reports
   update        Download and apply go-ipfs updates
@@ -107,6 +109,7 @@ The CLI will exit with one of the following values:
 		cmds.BoolOption(LocalOption, "L", "Run the command locally, instead of using the daemon. DEPRECATED: use --offline."),
 		cmds.BoolOption(OfflineOption, "Run the command offline."),
 		cmds.StringOption(ApiOption, "Use a specific API instance (defaults to /ip4/127.0.0.1/tcp/5001)"),
+		cmds.StringOption(ApiAuthOption, "Bearer token to authenticate with the API instance, see 'ipfs api token'."),
 
 		// global options, added to every command
 		cmdenv.OptionCidBase,
@@ -133,6 +136,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"repo":      RepoCmd,
 	"stats":     StatsCmd,
 	"bootstrap": BootstrapCmd,
+	"api":       APICmd,
 	"config":    ConfigCmd,
 	"dag":       dag.DagCmd,
 	"dht":       DhtCmd,
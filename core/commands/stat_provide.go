@@ -9,15 +9,29 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/reprovider"
+	irouting "github.com/ipfs/kubo/routing"
 
 	"github.com/ipfs/go-ipfs-provider/batched"
 )
 
+// ProvideStats are the statistics of the provider system: of the batched
+// provider when Experimental.AcceleratedDHTClient is enabled, of the
+// reprovider otherwise, and of the provide calls of each router.
+type ProvideStats struct {
+	*batched.BatchedProviderStats
+	Reprovider *reprovider.Stats       `json:",omitempty"`
+	Routers    []irouting.ProvideStats `json:",omitempty"`
+}
+
 var statProvideCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Returns statistics about the node's (re)provider system.",
 		ShortDescription: `
-Returns statistics about the content the node is advertising.
+Returns statistics about the content the node is advertising: the progress
+of the reprovide cycle, its estimated time left and the keys which could not
+be announced, as well as the latency and failures of the provide calls of
+each router.
 
 This interface is not stable and may change from release to release.
 `,
@@ -34,35 +48,76 @@ This interface is not stable and may change from release to release.
 			return ErrNotOnline
 		}
 
-		sys, ok := nd.Provider.(*batched.BatchProvidingSystem)
-		if !ok {
-			return fmt.Errorf("can only return stats if Experimental.AcceleratedDHTClient is enabled")
-		}
-
-		stats, err := sys.Stat(req.Context)
-		if err != nil {
-			return err
+		stats := &ProvideStats{
+			Routers: irouting.ProvideStatsSnapshot(),
 		}
-
-		if err := res.Emit(stats); err != nil {
-			return err
+		if sys, ok := nd.Provider.(*batched.BatchProvidingSystem); ok {
+			bstats, err := sys.Stat(req.Context)
+			if err != nil {
+				return err
+			}
+			stats.BatchedProviderStats = &bstats
+		} else if nd.Reprovider != nil {
+			rstats := nd.Reprovider.Stat()
+			stats.Reprovider = &rstats
+		} else {
+			return fmt.Errorf("can not return stats when Experimental.StrategicProviding is enabled")
 		}
 
-		return nil
+		return res.Emit(stats)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *batched.BatchedProviderStats) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *ProvideStats) error {
 			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 			defer wtr.Flush()
 
-			fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(s.TotalProvides))
-			fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(s.AvgProvideDuration))
-			fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(s.LastReprovideDuration))
-			fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(s.LastReprovideBatchSize))
+			if b := s.BatchedProviderStats; b != nil {
+				fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(b.TotalProvides))
+				fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(b.AvgProvideDuration))
+				fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(b.LastReprovideDuration))
+				fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(b.LastReprovideBatchSize))
+			}
+
+			if r := s.Reprovider; r != nil {
+				if r.CycleStart.IsZero() {
+					if !r.NextCycle.IsZero() {
+						fmt.Fprintf(wtr, "NextCycle:\t%s\n", r.NextCycle.Format(time.RFC3339))
+					}
+				} else {
+					fmt.Fprintf(wtr, "CycleStart:\t%s\n", r.CycleStart.Format(time.RFC3339))
+					progress := humanNumber(int(r.CycleProvided + r.CycleFailed))
+					if r.CycleKeys > 0 {
+						progress = fmt.Sprintf("%s / %s", progress, humanNumber(int(r.CycleKeys)))
+					}
+					fmt.Fprintf(wtr, "CycleProgress:\t%s\n", progress)
+					fmt.Fprintf(wtr, "CycleFailed:\t%s\n", humanNumber(int(r.CycleFailed)))
+					if r.ETA > 0 {
+						fmt.Fprintf(wtr, "ETA:\t%s\n", r.ETA.Truncate(time.Second))
+					}
+				}
+				if !r.LastCycleStart.IsZero() {
+					fmt.Fprintf(wtr, "LastCycleDuration:\t%s\n", humanDuration(r.LastCycleDuration))
+					fmt.Fprintf(wtr, "LastCycleKeys:\t%s\n", humanNumber(int(r.LastCycleKeys)))
+					fmt.Fprintf(wtr, "LastCycleFailed:\t%s\n", humanNumber(int(r.LastCycleFailed)))
+				}
+				fmt.Fprintf(wtr, "TotalProvided:\t%s\n", humanNumber(int(r.TotalProvided)))
+				fmt.Fprintf(wtr, "TotalFailed:\t%s\n", humanNumber(int(r.TotalFailed)))
+			}
+
+			for _, rs := range s.Routers {
+				fmt.Fprintf(wtr, "Router %s:\t%s calls, %s keys, %s failures, %s avg latency, %s last latency\n",
+					rs.Name,
+					humanFull(float64(rs.Calls), 0),
+					humanFull(float64(rs.Keys), 0),
+					humanFull(float64(rs.Failures), 0),
+					humanDuration(rs.AvgLatency),
+					humanDuration(rs.LastLatency),
+				)
+			}
 			return nil
 		}),
 	},
-	Type: batched.BatchedProviderStats{},
+	Type: ProvideStats{},
 }
 
 func humanDuration(val time.Duration) string {
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	irouting "github.com/ipfs/kubo/routing"
+)
+
+// RoutingCacheStats are the statistics of the cache routers of
+// Routing.Routers.
+type RoutingCacheStats struct {
+	Routers []irouting.CacheStats
+}
+
+var statRoutingCacheCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Returns statistics about the caches of the routing system.",
+		ShortDescription: `
+Returns the hits and misses of the 'cache' routers of Routing.Routers, for
+provider and peer lookups. Negative hits are the hits of cached lookups which
+found nothing.
+
+This interface is not stable and may change from release to release.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		return res.Emit(&RoutingCacheStats{Routers: irouting.CacheStatsSnapshot()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *RoutingCacheStats) error {
+			if len(s.Routers) == 0 {
+				fmt.Fprintln(w, "No cache router is configured in Routing.Routers.")
+				return nil
+			}
+
+			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer wtr.Flush()
+
+			fmt.Fprintln(wtr, "Router\tProviders\tProvider hit ratio\tPeers\tPeer hit ratio\tNegative hits")
+			for _, c := range s.Routers {
+				fmt.Fprintf(wtr, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					c.Name,
+					humanFull(float64(c.Providers), 0),
+					hitRatio(c.ProviderHits, c.ProviderMisses),
+					humanFull(float64(c.Peers), 0),
+					hitRatio(c.PeerHits, c.PeerMisses),
+					humanFull(float64(c.NegativeHits), 0),
+				)
+			}
+			return nil
+		}),
+	},
+	Type: RoutingCacheStats{},
+}
+
+func hitRatio(hits, misses int64) string {
+	if hits+misses == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%s/%s)", 100*float64(hits)/float64(hits+misses),
+		humanFull(float64(hits), 0), humanFull(float64(hits+misses), 0))
+}
@@ -48,10 +48,15 @@ import (
 	"github.com/ipfs/kubo/core/bootstrap"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/denylist"
 	"github.com/ipfs/kubo/fuse/mount"
+	"github.com/ipfs/kubo/gc"
 	"github.com/ipfs/kubo/p2p"
 	"github.com/ipfs/kubo/peering"
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/remotepin"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/reprovider"
 	irouting "github.com/ipfs/kubo/routing"
 )
 
@@ -67,6 +72,9 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinMeta         *pinmeta.Pinner        `optional:"true"` // the pinning manager, with access to pin metadata
+	RemotePins      *remotepin.Queue       `optional:"true"` // queue of requests to remote pinning services
+	Denylist        *denylist.Denylist     `optional:"true"` // content the node refuses to serve
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -77,6 +85,7 @@ type IpfsNode struct {
 	Filestore            *filestore.Filestore      `optional:"true"` // the filestore blockstore
 	BaseBlocks           node.BaseBlocks           // the raw blockstore, no filestore wrapping
 	GCLocker             bstore.GCLocker           // the locker used to protect the blockstore during gc
+	GCBarrier            *gc.WriteBarrier          `optional:"true"` // records writes during incremental gc
 	Blocks               bserv.BlockService        // the block service, get/add blocks.
 	DAG                  ipld.DAGService           // the merkle dag service, get/add objects.
 	IPLDFetcherFactory   fetcher.Factory           `name:"ipldFetcher"`   // fetcher that paths over the IPLD data model
@@ -96,6 +105,7 @@ type IpfsNode struct {
 	Exchange        exchange.Interface         // the block exchange + strategy (bitswap)
 	Namesys         namesys.NameSystem         // the name system, resolves paths to hashes
 	Provider        provider.System            // the value provider system
+	Reprovider      *reprovider.Reprovider     `optional:"true"` // the reprovider of the provider system, unless batched
 	IpnsRepub       *ipnsrp.Republisher        `optional:"true"`
 	GraphExchange   graphsync.GraphExchange    `optional:"true"`
 	ResourceManager network.ResourceManager    `optional:"true"`
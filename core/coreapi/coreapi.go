@@ -42,6 +42,7 @@ import (
 	"github.com/ipfs/go-namesys"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/node"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/repo"
 )
 
@@ -55,6 +56,8 @@ type CoreAPI struct {
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
 	pinning    pin.Pinner
+	pinMeta    *pinmeta.Store
+	metaPinner *pinmeta.Pinner
 
 	blocks               bserv.BlockService
 	dag                  ipld.DAGService
@@ -190,6 +193,11 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		parentOpts: settings,
 	}
 
+	if n.PinMeta != nil {
+		subAPI.pinMeta = n.PinMeta.Store()
+		subAPI.metaPinner = n.PinMeta
+	}
+
 	subAPI.checkOnline = func(allowOffline bool) error {
 		if !n.IsOnline && !allowOffline {
 			return coreiface.ErrOffline
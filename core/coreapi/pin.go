@@ -2,6 +2,7 @@ package coreapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	bserv "github.com/ipfs/go-blockservice"
@@ -12,6 +13,7 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/tracing"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -19,10 +21,63 @@ import (
 
 type PinAPI CoreAPI
 
+// PinMetadataAPI is implemented by the PinAPI of this package. It gives access
+// to the metadata kept about local pins, which coreiface.PinAPI has no
+// options for.
+type PinMetadataAPI interface {
+	coreiface.PinAPI
+
+	// Metadata returns the metadata of the pin on the given path.
+	Metadata(ctx context.Context, p path.Path) (pinmeta.Metadata, error)
+
+	// SetMetadata replaces the metadata of the recursive or direct pin on the
+	// given path.
+	SetMetadata(ctx context.Context, p path.Path, m pinmeta.Metadata) error
+
+	// AddWithMetadata pins the given path like Add, and merges m into the
+	// metadata of the pin.
+	AddWithMetadata(ctx context.Context, p path.Path, m pinmeta.Metadata, opts ...caopts.PinAddOption) error
+
+	// LsMatching lists pins like Ls, keeping those whose metadata matches f.
+	// Indirect pins have no metadata, they are only listed for the zero
+	// Filter.
+	LsMatching(ctx context.Context, f pinmeta.Filter, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error)
+}
+
+// PinWithMetadata is implemented by the pins listed by PinAPI.Ls.
+type PinWithMetadata interface {
+	coreiface.Pin
+
+	// Metadata of the pin, always zero for indirect pins.
+	Metadata() pinmeta.Metadata
+}
+
+var (
+	_ PinMetadataAPI  = (*PinAPI)(nil)
+	_ PinWithMetadata = (*pinInfo)(nil)
+)
+
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
 	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Add", trace.WithAttributes(attribute.String("path", p.String())))
 	defer span.End()
 
+	return api.add(ctx, p, nil, opts...)
+}
+
+func (api *PinAPI) AddWithMetadata(ctx context.Context, p path.Path, m pinmeta.Metadata, opts ...caopts.PinAddOption) error {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "AddWithMetadata", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if api.pinMeta == nil {
+		return errNoPinMetadata
+	}
+
+	return api.add(ctx, p, &m, opts...)
+}
+
+func (api *PinAPI) add(ctx context.Context, p path.Path, m *pinmeta.Metadata, opts ...caopts.PinAddOption) error {
+	span := trace.SpanFromContext(ctx)
+
 	dagNode, err := api.core().ResolveNode(ctx, p)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
@@ -37,7 +92,11 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 
 	defer api.blockstore.PinLock(ctx).Unlock(ctx)
 
-	err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
+	if m != nil {
+		err = api.metaPinner.PinWithMetadata(ctx, dagNode, settings.Recursive, *m)
+	} else {
+		err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
+	}
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
 	}
@@ -53,6 +112,19 @@ func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan c
 	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Ls")
 	defer span.End()
 
+	return api.ls(ctx, pinmeta.Filter{}, opts...)
+}
+
+func (api *PinAPI) LsMatching(ctx context.Context, f pinmeta.Filter, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "LsMatching", trace.WithAttributes(attribute.String("name", f.Name)))
+	defer span.End()
+
+	return api.ls(ctx, f, opts...)
+}
+
+func (api *PinAPI) ls(ctx context.Context, f pinmeta.Filter, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
+	span := trace.SpanFromContext(ctx)
+
 	settings, err := caopts.PinLsOptions(opts...)
 	if err != nil {
 		return nil, err
@@ -66,7 +138,7 @@ func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan c
 		return nil, fmt.Errorf("invalid type '%s', must be one of {direct, indirect, recursive, all}", settings.Type)
 	}
 
-	return api.pinLsAll(ctx, settings.Type), nil
+	return api.pinLsAll(ctx, settings.Type, f), nil
 }
 
 func (api *PinAPI) IsPinned(ctx context.Context, p path.Path, opts ...caopts.PinIsPinnedOption) (string, bool, error) {
@@ -155,6 +227,48 @@ func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opt
 	return api.pinning.Flush(ctx)
 }
 
+func (api *PinAPI) Metadata(ctx context.Context, p path.Path) (pinmeta.Metadata, error) {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Metadata", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if api.pinMeta == nil {
+		return pinmeta.Metadata{}, errNoPinMetadata
+	}
+
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return pinmeta.Metadata{}, err
+	}
+
+	return api.pinMeta.Get(ctx, rp.Cid())
+}
+
+func (api *PinAPI) SetMetadata(ctx context.Context, p path.Path, m pinmeta.Metadata) error {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "SetMetadata", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	if api.pinMeta == nil {
+		return errNoPinMetadata
+	}
+
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	mode, pinned, err := api.pinning.IsPinned(ctx, rp.Cid())
+	if err != nil {
+		return err
+	}
+	if !pinned || (mode != "recursive" && mode != "direct") {
+		return fmt.Errorf("pin: %s: %w", rp.Cid(), pin.ErrNotPinned)
+	}
+
+	return api.pinMeta.Put(ctx, rp.Cid(), m)
+}
+
+var errNoPinMetadata = errors.New("pin metadata is not available on this node")
+
 type pinStatus struct {
 	cid      cid.Cid
 	ok       bool
@@ -240,6 +354,7 @@ func (api *PinAPI) Verify(ctx context.Context) (<-chan coreiface.PinStatus, erro
 type pinInfo struct {
 	pinType string
 	path    path.Resolved
+	meta    pinmeta.Metadata
 	err     error
 }
 
@@ -255,22 +370,28 @@ func (p *pinInfo) Err() error {
 	return p.err
 }
 
+func (p *pinInfo) Metadata() pinmeta.Metadata {
+	return p.meta
+}
+
 // pinLsAll is an internal function for returning a list of pins
 //
 // The caller must keep reading results until the channel is closed to prevent
 // leaking the goroutine that is fetching pins.
-func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreiface.Pin {
+func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string, filter pinmeta.Filter) <-chan coreiface.Pin {
 	out := make(chan coreiface.Pin, 1)
 
 	keys := cid.NewSet()
+	metas := make(map[cid.Cid]pinmeta.Metadata)
 
 	AddToResultKeys := func(keyList []cid.Cid, typeStr string) error {
 		for _, c := range keyList {
-			if keys.Visit(c) {
+			if keys.Visit(c) && filter.Match(metas[c]) {
 				select {
 				case out <- &pinInfo{
 					pinType: typeStr,
 					path:    path.IpldPath(c),
+					meta:    metas[c],
 				}:
 				case <-ctx.Done():
 					return ctx.Err()
@@ -289,6 +410,20 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	go func() {
 		defer close(out)
 
+		// Indirect pins have no metadata to match.
+		listIndirect := filter.IsZero()
+
+		if api.pinMeta != nil && typeStr != "indirect" {
+			entries, err := api.pinMeta.Query(ctx)
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
+			}
+			for _, e := range entries {
+				metas[e.Cid] = e.Metadata
+			}
+		}
+
 		var dkeys, rkeys []cid.Cid
 		var err error
 		if typeStr == "recursive" || typeStr == "all" {
@@ -313,7 +448,7 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 				return
 			}
 		}
-		if typeStr == "all" {
+		if typeStr == "all" && listIndirect {
 			set := cid.NewSet()
 			for _, k := range rkeys {
 				err = merkledag.Walk(
@@ -331,7 +466,7 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 				return
 			}
 		}
-		if typeStr == "indirect" {
+		if typeStr == "indirect" && listIndirect {
 			// We need to first visit the direct pins that have priority
 			// without emitting them
 
@@ -0,0 +1,332 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogOption logs the requests served by the handlers registered
+// after it, as configured by cfg. Relative paths are relative to repoPath.
+//
+// Handlers can add the CID they resolved and the format of their response
+// to the log with setAccessLogContent.
+func AccessLogOption(handlerName string, cfg config.AccessLog, repoPath string) ServeOption {
+	var (
+		once sync.Once
+		w    io.Writer
+		err  error
+	)
+	return func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		if cfg.Path == "" {
+			return mux, nil
+		}
+
+		format := cfg.Format.WithDefault(config.AccessLogFormatCombined)
+		if format != config.AccessLogFormatCombined && format != config.AccessLogFormatJSON {
+			return nil, fmt.Errorf("unsupported access log format %q", format)
+		}
+
+		// The log is opened once for all the listeners of the handler.
+		once.Do(func() {
+			w, err = openAccessLog(cfg, repoPath)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening the %s access log: %w", handlerName, err)
+		}
+
+		childMux := http.NewServeMux()
+		mux.Handle("/", &accessLogHandler{
+			name:        handlerName,
+			json:        format == config.AccessLogFormatJSON,
+			redactQuery: cfg.RedactQueryStrings,
+			out:         w,
+			next:        childMux,
+		})
+		return childMux, nil
+	}
+}
+
+type accessLogHandler struct {
+	name        string
+	json        bool
+	redactQuery bool
+	out         io.Writer
+	next        http.Handler
+}
+
+// accessLogEntry is a logged request.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Handler   string    `json:"handler"`
+	Client    string    `json:"client"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration"` // in seconds
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Cid       string    `json:"cid,omitempty"`
+	Format    string    `json:"format,omitempty"`
+}
+
+type accessLogContextKey struct{}
+
+// setAccessLogContent adds the CID resolved for the request, and the format
+// of its response, to its access log entry, if the request is logged.
+func setAccessLogContent(ctx context.Context, c cid.Cid, format string) {
+	e, ok := ctx.Value(accessLogContextKey{}).(*accessLogEntry)
+	if !ok {
+		return
+	}
+	if c.Defined() {
+		e.Cid = c.String()
+	}
+	e.Format = format
+}
+
+// redactQueryString removes the query string and fragment of a URL.
+func redactQueryString(u string) string {
+	u, _, _ = strings.Cut(u, "#")
+	u, _, _ = strings.Cut(u, "?")
+	return u
+}
+
+func (h *accessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e := &accessLogEntry{
+		Time:      time.Now(),
+		Handler:   h.name,
+		Method:    r.Method,
+		URI:       r.URL.RequestURI(),
+		Proto:     r.Proto,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e.Client = host
+	} else {
+		e.Client = r.RemoteAddr
+	}
+	if h.redactQuery {
+		e.URI = r.URL.EscapedPath()
+		e.Referer = redactQueryString(e.Referer)
+	}
+
+	lw := &accessLogResponseWriter{ResponseWriter: w}
+	defer func() {
+		e.Duration = time.Since(e.Time).Seconds()
+		e.Status = lw.status
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		e.Bytes = lw.bytes
+		h.write(e)
+	}()
+	h.next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, e)))
+}
+
+func (h *accessLogHandler) write(e *accessLogEntry) {
+	var line []byte
+	if h.json {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			log.Errorf("failed to encode the access log entry: %s", err)
+			return
+		}
+	} else {
+		line = []byte(formatCombinedLog(e))
+	}
+	line = append(line, '\n')
+	if _, err := h.out.Write(line); err != nil {
+		log.Errorf("failed to write the %s access log: %s", h.name, err)
+	}
+}
+
+// formatCombinedLog formats e in the Combined Log Format, followed by the
+// resolved CID, the response format and the duration in seconds.
+func formatCombinedLog(e *accessLogEntry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] %s %d %s %s %s %s %s %.3f",
+		e.Client,
+		e.Time.Format(clfTimeFormat),
+		clfQuote(e.Method+" "+e.URI+" "+e.Proto),
+		e.Status,
+		bytes,
+		clfQuote(e.Referer),
+		clfQuote(e.UserAgent),
+		clfQuote(e.Cid),
+		clfQuote(e.Format),
+		e.Duration,
+	)
+}
+
+// clfQuote quotes s, or returns "-" when it is empty.
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// accessLogResponseWriter records the status and size of responses.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var (
+	accessLogFilesLk sync.Mutex
+	// accessLogFiles are the open log files, by path, so handlers logging
+	// to the same file share it.
+	accessLogFiles = make(map[string]*rotatingFile)
+)
+
+func openAccessLog(cfg config.AccessLog, repoPath string) (io.Writer, error) {
+	if cfg.Path == config.AccessLogStdout {
+		return &syncWriter{w: os.Stdout}, nil
+	}
+
+	path := cfg.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	accessLogFilesLk.Lock()
+	defer accessLogFilesLk.Unlock()
+	if f, ok := accessLogFiles[path]; ok {
+		return f, nil
+	}
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    cfg.MaxSize.WithDefault(config.DefaultAccessLogMaxSize),
+		maxBackups: int(cfg.MaxBackups.WithDefault(config.DefaultAccessLogMaxBackups)),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	accessLogFiles[path] = f
+	return f, nil
+}
+
+// syncWriter serializes the writes to w, so lines are not interleaved.
+type syncWriter struct {
+	lk sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.w.Write(p)
+}
+
+// rotatingFile is a file renamed to path.1 once it grows over maxSize, the
+// previous path.1 being renamed to path.2, and so on up to maxBackups.
+type rotatingFile struct {
+	lk         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size = file, st.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return f.open()
+	}
+	for i := f.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(f.backupPath(i), f.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) backupPath(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
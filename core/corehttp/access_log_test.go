@@ -0,0 +1,135 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/coreapi"
+)
+
+func accessLogConfig(t *testing.T, js string) config.AccessLog {
+	var cfg config.AccessLog
+	if err := json.Unmarshal([]byte(js), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestAccessLog(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		format string
+		check  func(t *testing.T, line, cid string)
+	}{
+		{config.AccessLogFormatJSON, func(t *testing.T, line, cid string) {
+			var e accessLogEntry
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Handler != "gateway" || e.Client != "127.0.0.1" || e.Method != http.MethodGet ||
+				e.URI != "/ipfs/"+cid || e.Status != http.StatusOK || e.Bytes != 5 ||
+				e.Cid != cid || e.Format != "application/vnd.ipld.raw" || e.Referer != "https://example.com/page" {
+				t.Errorf("unexpected entry %+v", e)
+			}
+		}},
+		{config.AccessLogFormatCombined, func(t *testing.T, line, cid string) {
+			prefix := `127.0.0.1 - - [`
+			middle := `] "GET /ipfs/` + cid + ` HTTP/1.1" 200 5 "https://example.com/page" "test" "` + cid + `" "application/vnd.ipld.raw" `
+			if !strings.HasPrefix(line, prefix) || !strings.Contains(line, middle) {
+				t.Errorf("unexpected line %s", line)
+			}
+		}},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			logPath := tc.format + ".log"
+			cfg := accessLogConfig(t, `{"Path": "`+logPath+`", "Format": "`+tc.format+`", "RedactQueryStrings": true}`)
+
+			dh := &delegatedHandler{}
+			ts := httptest.NewServer(dh)
+			defer ts.Close()
+			dh.Handler, err = makeHandler(n, ts.Listener,
+				AccessLogOption("gateway", cfg, dir),
+				GatewayOption(false, "/ipfs", "/ipns"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			api, err := coreapi.NewCoreAPI(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("fnord")), options.Unixfs.RawLeaves(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := p.Cid().String()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/ipfs/"+c+"?format=raw", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", "test")
+			req.Header.Set("Referer", "https://example.com/page?token=secret")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			data, err := os.ReadFile(filepath.Join(dir, logPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("expected one line, got %q", data)
+			}
+			tc.check(t, lines[0], c)
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := &rotatingFile{path: path, maxSize: 10, maxBackups: 2}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, expected := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", p, expected, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only %d backups to be kept", f.maxBackups)
+	}
+}
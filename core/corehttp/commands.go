@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net"
@@ -151,6 +152,76 @@ func commandsOption(cctx oldcmds.Context, command *cmds.Command, allowGet bool)
 	}
 }
 
+// APIAuthorizationOption requires the requests to every handler registered
+// after it to carry a bearer token, like the RPC API. Endpoints outside of the
+// RPC API, like /debug/pprof or /logs, are allowed by their path.
+func APIAuthorizationOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		mux.Handle("/", withAPIAuthorization(n, childMux))
+		return childMux, nil
+	}
+}
+
+// withAPIAuthorization requires requests to carry a bearer token allowed to
+// run the requested command, or to access the requested path outside of the
+// RPC API, when API.Authorizations is set. The config is read on every
+// request, so that tokens can be created and revoked while the daemon runs.
+func withAPIAuthorization(n *core.IpfsNode, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflight requests never carry credentials
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(cfg.API.Authorizations) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		auth := findAPIAuthorization(cfg.API.Authorizations, r)
+		if auth == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kubo"`)
+			http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+
+		cmdPath := r.URL.Path
+		if strings.HasPrefix(cmdPath, APIPath+"/") {
+			cmdPath = strings.TrimPrefix(cmdPath, APIPath)
+		}
+		cmdPath = strings.Trim(cmdPath, "/")
+		if !auth.Allows(cmdPath) {
+			http.Error(w, fmt.Sprintf("API token is not allowed to run %q", cmdPath), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func findAPIAuthorization(auths map[string]*config.APIAuthorization, r *http.Request) *config.APIAuthorization {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil
+	}
+	hash := []byte(config.HashAPIToken(header[len(prefix):]))
+
+	var found *config.APIAuthorization
+	for _, auth := range auths {
+		if auth != nil && subtle.ConstantTimeCompare(hash, []byte(auth.TokenHash)) == 1 {
+			found = auth
+		}
+	}
+	return found
+}
+
 // CommandsOption constructs a ServerOption for hooking the commands into the
 // HTTP server. It will NOT allow GET requests.
 func CommandsOption(cctx oldcmds.Context) ServeOption {
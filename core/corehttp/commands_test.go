@@ -0,0 +1,121 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	oldcmds "github.com/ipfs/kubo/commands"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	repo "github.com/ipfs/kubo/repo"
+)
+
+func newAPIAuthorizationTestNode(t *testing.T) *core.IpfsNode {
+	c := config.Config{
+		Identity: config.Identity{
+			PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+		},
+	}
+	c.API.Authorizations = map[string]*config.APIAuthorization{
+		"reader":  {TokenHash: config.HashAPIToken("reader-token"), AllowedPaths: []string{"cat", "pin/ls"}},
+		"admin":   {TokenHash: config.HashAPIToken("admin-token"), AllowedPaths: []string{"/"}},
+		"scraper": {TokenHash: config.HashAPIToken("scraper-token"), AllowedPaths: []string{"debug/metrics/prometheus"}},
+	}
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	n := newAPIAuthorizationTestNode(t)
+
+	h := withAPIAuthorization(n, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		method, path, auth string
+		status             int
+	}{
+		{http.MethodPost, APIPath + "/cat", "", http.StatusUnauthorized},
+		{http.MethodPost, APIPath + "/cat", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, APIPath + "/cat", "Basic cmVhZGVyLXRva2Vu", http.StatusUnauthorized},
+		{http.MethodPost, APIPath + "/cat", "Bearer reader-token", http.StatusOK},
+		{http.MethodPost, APIPath + "/pin/ls", "Bearer reader-token", http.StatusOK},
+		{http.MethodPost, APIPath + "/pin/lsx", "Bearer reader-token", http.StatusForbidden},
+		{http.MethodPost, APIPath + "/pin/add", "Bearer reader-token", http.StatusForbidden},
+		{http.MethodPost, APIPath + "/config/show", "Bearer reader-token", http.StatusForbidden},
+		{http.MethodPost, APIPath + "/config/show", "Bearer admin-token", http.StatusOK},
+		{http.MethodOptions, APIPath + "/cat", "", http.StatusOK},
+		// Endpoints outside of the RPC API are allowed by their path
+		{http.MethodGet, "/debug/pprof/heap", "", http.StatusUnauthorized},
+		{http.MethodGet, "/debug/pprof/heap", "Bearer reader-token", http.StatusForbidden},
+		{http.MethodGet, "/debug/pprof/heap", "Bearer admin-token", http.StatusOK},
+		{http.MethodGet, "/debug/metrics/prometheus", "Bearer scraper-token", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s with %q: expected status %d, got %d", tc.method, tc.path, tc.auth, tc.status, rec.Code)
+		}
+	}
+
+	// The option applies to the handlers registered after it.
+	h, err := makeHandler(n, nil, APIAuthorizationOption(), LogOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected /logs to require a token, got %d", rec.Code)
+	}
+}
+
+func TestAPIAuthorizationOnlyOnAPI(t *testing.T) {
+	n := newAPIAuthorizationTestNode(t)
+	cctx := oldcmds.Context{
+		ReqLog:        &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+	}
+
+	get := func(opts ...ServeOption) int {
+		t.Helper()
+		dh := &delegatedHandler{}
+		ts := httptest.NewServer(dh)
+		defer ts.Close()
+		var err error
+		dh.Handler, err = makeHandler(n, ts.Listener, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(ts.URL+APIPath+"/version", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := get(APIAuthorizationOption(), CommandsOption(cctx)); status != http.StatusUnauthorized {
+		t.Errorf("expected the RPC API to require a token, got %d", status)
+	}
+	gwctx := cctx
+	gwctx.Gateway = true
+	if status := get(CommandsROOption(gwctx)); status != http.StatusOK {
+		t.Errorf("expected the read-only API of the gateway to work without a token, got %d", status)
+	}
+}
@@ -0,0 +1,187 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	drc "github.com/ipfs/go-delegated-routing/client"
+	drs "github.com/ipfs/go-delegated-routing/server"
+	core "github.com/ipfs/kubo/core"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const (
+	// ReframePath is the endpoint of the Reframe delegated routing API, to
+	// use as the Endpoint of reframe routers.
+	ReframePath = "/reframe"
+
+	// RoutingPeersPath is the prefix of the find-peers endpoint of the
+	// Routing V1 HTTP API, which Reframe lacks: GET /routing/v1/peers/{peer
+	// id} returns the peer records of a peer.
+	RoutingPeersPath = "/routing/v1/peers/"
+
+	// Content types of the responses of the Routing V1 HTTP API.
+	routingV1ContentTypeJSON   = "application/json"
+	routingV1ContentTypeNDJSON = "application/x-ndjson"
+
+	// routingV1SchemaPeer is the schema of peer records.
+	routingV1SchemaPeer = "peer"
+)
+
+// delegatedRoutingMaxProviders is the number of providers returned for a
+// CID, like 'ipfs routing findprovs'.
+const delegatedRoutingMaxProviders = 20
+
+// DelegatedRoutingOption serves the routing system of the node to delegated
+// routing clients: find-providers and get/put IPNS over Reframe, and
+// find-peers over the Routing V1 HTTP API.
+func DelegatedRoutingOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		if !n.IsOnline || n.Routing == nil {
+			return nil, errors.New("the delegated routing server requires an online node")
+		}
+
+		mux.Handle(ReframePath, drs.DelegatedRoutingAsyncHandler(&delegatedRoutingService{
+			router:    n.Routing,
+			validator: n.RecordValidator,
+		}))
+		mux.Handle(RoutingPeersPath, &routingPeersHandler{router: n.Routing})
+		return mux, nil
+	}
+}
+
+// delegatedRoutingService answers Reframe requests with a router.
+type delegatedRoutingService struct {
+	router    routing.Routing
+	validator record.Validator
+}
+
+func (s *delegatedRoutingService) FindProviders(ctx context.Context, key cid.Cid) (<-chan drc.FindProvidersAsyncResult, error) {
+	out := make(chan drc.FindProvidersAsyncResult)
+	go func() {
+		defer close(out)
+		for ai := range s.router.FindProvidersAsync(ctx, key, delegatedRoutingMaxProviders) {
+			select {
+			case out <- drc.FindProvidersAsyncResult{AddrInfo: []peer.AddrInfo{ai}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *delegatedRoutingService) GetIPNS(ctx context.Context, id []byte) (<-chan drc.GetIPNSAsyncResult, error) {
+	if _, err := peer.IDFromBytes(id); err != nil {
+		return nil, err
+	}
+	out := make(chan drc.GetIPNSAsyncResult, 1)
+	rec, err := s.router.GetValue(ctx, "/ipns/"+string(id))
+	out <- drc.GetIPNSAsyncResult{Record: rec, Err: err}
+	close(out)
+	return out, nil
+}
+
+func (s *delegatedRoutingService) PutIPNS(ctx context.Context, id []byte, rec []byte) (<-chan drc.PutIPNSAsyncResult, error) {
+	if _, err := peer.IDFromBytes(id); err != nil {
+		return nil, err
+	}
+	// The errors of the routers of the node are ignored, so records are
+	// validated first.
+	key := "/ipns/" + string(id)
+	err := s.validator.Validate(key, rec)
+	if err == nil {
+		err = s.router.PutValue(ctx, key, rec)
+	}
+	out := make(chan drc.PutIPNSAsyncResult, 1)
+	out <- drc.PutIPNSAsyncResult{Err: err}
+	close(out)
+	return out, nil
+}
+
+// Provide is not supported: the node can only announce itself as a
+// provider.
+func (s *delegatedRoutingService) Provide(ctx context.Context, req *drc.ProvideRequest) (<-chan drc.ProvideAsyncResult, error) {
+	out := make(chan drc.ProvideAsyncResult, 1)
+	out <- drc.ProvideAsyncResult{Err: routing.ErrNotSupported}
+	close(out)
+	return out, nil
+}
+
+// routingPeersHandler answers find-peers requests.
+type routingPeersHandler struct {
+	router routing.Routing
+}
+
+// routingPeerRecord is a peer record of the Routing V1 HTTP API.
+type routingPeerRecord struct {
+	Schema string
+	ID     string
+	Addrs  []string
+}
+
+// routingPeersResponse is the JSON response of the find-peers endpoint.
+type routingPeersResponse struct {
+	Peers []routingPeerRecord
+}
+
+func (h *routingPeersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := peer.Decode(strings.TrimPrefix(r.URL.Path, RoutingPeersPath))
+	if err != nil {
+		http.Error(w, "invalid peer ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ai, err := h.router.FindPeer(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, routing.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	rec := routingPeerRecord{Schema: routingV1SchemaPeer, ID: ai.ID.String(), Addrs: make([]string, 0, len(ai.Addrs))}
+	for _, a := range ai.Addrs {
+		rec.Addrs = append(rec.Addrs, a.String())
+	}
+
+	// Records are streamed as NDJSON to the clients asking for it, and
+	// wrapped in a JSON object otherwise.
+	var res interface{} = &routingPeersResponse{Peers: []routingPeerRecord{rec}}
+	contentType := routingV1ContentTypeJSON
+	if acceptsNDJSON(r) {
+		res, contentType = &rec, routingV1ContentTypeNDJSON
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Debugw("failed to write the find-peers response", "error", err)
+	}
+}
+
+// acceptsNDJSON returns whether the Accept header of r asks for NDJSON.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept") {
+		for _, accept := range strings.Split(header, ",") {
+			mediatype, _, _ := strings.Cut(accept, ";")
+			if strings.EqualFold(strings.TrimSpace(mediatype), routingV1ContentTypeNDJSON) {
+				return true
+			}
+		}
+	}
+	return false
+}
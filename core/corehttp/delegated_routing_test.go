@@ -0,0 +1,207 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	offroute "github.com/ipfs/go-ipfs-routing/offline"
+	ipns "github.com/ipfs/go-ipns"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	coremock "github.com/ipfs/kubo/core/mock"
+	irouting "github.com/ipfs/kubo/routing"
+	record "github.com/libp2p/go-libp2p-record"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	host "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// testRouter knows a single provider, of testRoutingCid, and its addresses.
+type testRouter struct {
+	routing.ValueStore
+	routinghelpers.Null
+	provider peer.AddrInfo
+}
+
+var testRoutingCid = cid.MustParse("bafkqaaa")
+
+func (r *testRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, 1)
+	if c.Equals(testRoutingCid) {
+		out <- r.provider
+	}
+	close(out)
+	return out
+}
+
+func (r *testRouter) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	if id != r.provider.ID {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	return r.provider, nil
+}
+
+func (r *testRouter) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	return r.ValueStore.PutValue(ctx, key, val, opts...)
+}
+
+func (r *testRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	return r.ValueStore.GetValue(ctx, key, opts...)
+}
+
+func (r *testRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	return r.ValueStore.SearchValue(ctx, key, opts...)
+}
+
+func TestDelegatedRoutingServer(t *testing.T) {
+	ctx := context.Background()
+
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerID, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := peer.AddrInfo{ID: providerID, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.1/tcp/4001")}}
+
+	n, err := core.NewNode(ctx, &core.BuildCfg{
+		Online: true,
+		Host:   coremock.MockHostOption(mocknet.New()),
+		Routing: func(_ context.Context, _ host.Host, dstore datastore.Batching, validator record.Validator, _ ...peer.AddrInfo) (routing.Routing, error) {
+			return &testRouter{
+				ValueStore: offroute.NewOfflineRouter(dstore, validator),
+				provider:   provider,
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(ts.Close)
+	dh.Handler, err = makeHandler(n, ts.Listener, DelegatedRoutingOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients use the server like any other reframe router.
+	methods := config.Methods{}
+	for _, m := range config.MethodNameList {
+		methods[m] = config.Method{RouterName: "remote"}
+	}
+	client, err := irouting.Parse(config.Routers{
+		"remote": config.RouterParser{
+			Router: config.Router{
+				Type:       config.RouterTypeReframe,
+				Parameters: &config.ReframeRouterParams{Endpoint: ts.URL + ReframePath},
+			},
+		},
+	}, methods, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []peer.AddrInfo
+	for ai := range client.FindProvidersAsync(ctx, testRoutingCid, 0) {
+		found = append(found, ai)
+	}
+	if len(found) != 1 || found[0].ID != providerID || len(found[0].Addrs) != 1 {
+		t.Fatalf("unexpected providers %v", found)
+	}
+
+	sk, pk, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte("/ipfs/"+testRoutingCid.String()), 1, time.Now().Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutValue(ctx, ipns.RecordKey(name), rec); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.GetValue(ctx, ipns.RecordKey(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(rec) {
+		t.Fatal("unexpected IPNS record")
+	}
+	if err := client.PutValue(ctx, ipns.RecordKey(name), []byte("invalid")); err == nil {
+		t.Fatal("expected invalid IPNS records to be rejected")
+	}
+
+	// Reframe lacks find-peers, which is served over the Routing V1 HTTP API.
+	res, err := http.Get(ts.URL + RoutingPeersPath + providerID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var pr struct {
+		Peers []struct {
+			Schema string
+			ID     string
+			Addrs  []string
+		}
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pr); err != nil {
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected Content-Type %q", ct)
+	}
+	if len(pr.Peers) != 1 || pr.Peers[0].Schema != "peer" || pr.Peers[0].ID != providerID.String() ||
+		len(pr.Peers[0].Addrs) != 1 || pr.Peers[0].Addrs[0] != "/ip4/10.0.0.1/tcp/4001" {
+		t.Fatalf("unexpected peers %+v", pr)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+RoutingPeersPath+peer.ToCid(providerID).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var nd routingPeerRecord
+	if err := json.NewDecoder(res.Body).Decode(&nd); err != nil {
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" || nd.ID != providerID.String() {
+		t.Fatalf("unexpected NDJSON response %q %+v", ct, nd)
+	}
+	res, err = http.Get(ts.URL + RoutingPeersPath + name.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown peers to be not found, got %d", res.StatusCode)
+	}
+}
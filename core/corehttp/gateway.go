@@ -7,12 +7,16 @@ import (
 	"net/http"
 	"sort"
 
+	"github.com/ipfs/go-datastore"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	path "github.com/ipfs/interface-go-ipfs-core/path"
 	version "github.com/ipfs/kubo"
+	config "github.com/ipfs/kubo/config"
 	core "github.com/ipfs/kubo/core"
 	coreapi "github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/denylist"
+	routing "github.com/libp2p/go-libp2p/core/routing"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
@@ -21,6 +25,22 @@ type GatewayConfig struct {
 	Headers               map[string][]string
 	Writable              bool
 	FastDirIndexThreshold int
+
+	// Denylist lists the content the gateway refuses to serve, if any.
+	Denylist *denylist.Denylist
+
+	// RateLimits limits the requests the gateway accepts.
+	RateLimits config.GatewayRateLimits
+
+	// Cache configures the cache of resolved paths and small responses.
+	Cache config.GatewayCache
+
+	// Datastore keeps the cached responses when Cache.Namespace is set.
+	Datastore datastore.Datastore
+
+	// Routing is used to fetch the IPNS records served with
+	// ?format=ipns-record. They are not served when nil.
+	Routing routing.ValueStore
 }
 
 // NodeAPI defines the minimal set of API services required by a gateway handler
@@ -82,10 +102,19 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			return nil, err
 		}
 
+		if _, err := parseTrustedProxies(cfg.Gateway.RateLimits.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("Gateway.RateLimits: %w", err)
+		}
+
 		gateway := NewGatewayHandler(GatewayConfig{
 			Headers:               headers,
 			Writable:              writable,
 			FastDirIndexThreshold: int(cfg.Gateway.FastDirIndexThreshold.WithDefault(100)),
+			Denylist:              n.Denylist,
+			RateLimits:            cfg.Gateway.RateLimits,
+			Cache:                 cfg.Gateway.Cache,
+			Datastore:             n.Repo.Datastore(),
+			Routing:               n.Routing,
 		}, api, offlineAPI)
 
 		gateway = otelhttp.NewHandler(gateway, "Gateway.Request")
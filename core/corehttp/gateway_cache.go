@@ -0,0 +1,248 @@
+package corehttp
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	config "github.com/ipfs/kubo/config"
+)
+
+// Keys of the gateway cache, by kind of entry.
+const (
+	cacheKeyResolution = "resolve:" // content path -> ipath.Resolved
+	cacheKeyRawBlock   = "block:"   // CID -> block bytes
+	cacheKeyUnixFSFile = "file:"    // CID -> UnixFS file bytes
+)
+
+// cacheEntryOverhead approximates the memory used by an entry on top of its
+// key and value.
+const cacheEntryOverhead = 128
+
+// gatewayCache is a size-bounded LRU cache of immutable data: resolutions
+// of /ipfs/ paths, and small blocks and files. It never needs invalidation,
+// mutable paths being resolved to immutable ones before being looked up.
+//
+// A nil *gatewayCache caches nothing.
+type gatewayCache struct {
+	maxSize      int64
+	maxEntrySize int64
+
+	// disk keeps the cached bytes under the memory, if any.
+	disk *gatewayDiskCache
+
+	// evicted is called with the keys of the evicted entries, if set.
+	evicted func(key string)
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type gatewayCacheEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// newGatewayCache returns nil when the cache is disabled. The cached bytes
+// are also kept in d when cfg.Namespace is set.
+func newGatewayCache(cfg config.GatewayCache, d datastore.Datastore) *gatewayCache {
+	maxSize := cfg.MaxSize.WithDefault(config.DefaultGatewayCacheMaxSize)
+	if maxSize <= 0 {
+		return nil
+	}
+	maxEntrySize := cfg.MaxEntrySize.WithDefault(config.DefaultGatewayCacheMaxEntrySize)
+	if maxEntrySize > maxSize {
+		maxEntrySize = maxSize
+	}
+	c := newLRUCache(maxSize, maxEntrySize)
+	if cfg.Namespace != "" {
+		if d == nil {
+			log.Error("Gateway.Cache.Namespace is set, but the gateway has no datastore: caching in memory only")
+			return c
+		}
+		maxDiskSize := cfg.MaxDiskSize.WithDefault(config.DefaultGatewayCacheMaxDiskSize)
+		if maxDiskSize > 0 {
+			c.disk = newGatewayDiskCache(namespace.Wrap(d, datastore.NewKey(cfg.Namespace)), maxDiskSize, maxEntrySize)
+		}
+	}
+	return c
+}
+
+func newLRUCache(maxSize, maxEntrySize int64) *gatewayCache {
+	return &gatewayCache{
+		maxSize:      maxSize,
+		maxEntrySize: maxEntrySize,
+		lru:          list.New(),
+		items:        make(map[string]*list.Element),
+	}
+}
+
+// fits tells whether a body of the given size can be cached.
+func (c *gatewayCache) fits(size int64) bool {
+	return c != nil && size >= 0 && size <= c.maxEntrySize
+}
+
+// get returns the value cached for key, looking for it on disk when it is
+// not in memory.
+func (c *gatewayCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if v, ok := c.getMemory(key); ok {
+		return v, true
+	}
+	if c.disk == nil {
+		return nil, false
+	}
+	data, ok := c.disk.get(key)
+	if !ok {
+		return nil, false
+	}
+	c.addMemory(key, data, int64(len(data)))
+	return data, true
+}
+
+func (c *gatewayCache) getMemory(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*gatewayCacheEntry).value, true
+}
+
+// add caches value, of the given size in bytes, evicting the least recently
+// used entries to make room for it. Bytes are also cached on disk.
+func (c *gatewayCache) add(key string, value interface{}, size int64) {
+	if c == nil {
+		return
+	}
+	c.addMemory(key, value, size)
+	if data, ok := value.([]byte); ok && c.disk != nil {
+		c.disk.add(key, data)
+	}
+}
+
+func (c *gatewayCache) addMemory(key string, value interface{}, size int64) {
+	size += int64(len(key)) + cacheEntryOverhead
+	if size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.items[key] = c.lru.PushFront(&gatewayCacheEntry{key: key, value: value, size: size})
+	c.size += size
+}
+
+func (c *gatewayCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*gatewayCacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	if c.evicted != nil {
+		c.evicted(entry.key)
+	}
+}
+
+// gatewayDiskCache keeps cached bytes in a datastore, bounded by size. The
+// least recently used entries are tracked by an in-memory index, which is
+// rebuilt from the datastore when the node starts.
+type gatewayDiskCache struct {
+	ds    datastore.Datastore
+	index *gatewayCache // keys and sizes of the entries in ds
+}
+
+func newGatewayDiskCache(d datastore.Datastore, maxSize, maxEntrySize int64) *gatewayDiskCache {
+	dc := &gatewayDiskCache{ds: d, index: newLRUCache(maxSize, maxEntrySize)}
+	dc.index.evicted = dc.delete
+
+	res, err := d.Query(context.Background(), query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		log.Errorf("failed to load the gateway cache from the datastore: %s", err)
+		return dc
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			log.Errorf("failed to load the gateway cache from the datastore: %s", e.Error)
+			break
+		}
+		size := int64(e.Size)
+		if size < 0 {
+			n, err := d.GetSize(context.Background(), datastore.RawKey(e.Key))
+			if err != nil {
+				continue
+			}
+			size = int64(n)
+		}
+		dc.index.addMemory(strings.TrimPrefix(e.Key, "/"), nil, size)
+	}
+	return dc
+}
+
+func (dc *gatewayDiskCache) get(key string) ([]byte, bool) {
+	if _, ok := dc.index.getMemory(key); !ok {
+		return nil, false
+	}
+	data, err := dc.ds.Get(context.Background(), datastore.NewKey(key))
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorf("failed to read the gateway cache: %s", err)
+		}
+		dc.index.mu.Lock()
+		if e, ok := dc.index.items[key]; ok {
+			dc.index.remove(e)
+		}
+		dc.index.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+func (dc *gatewayDiskCache) add(key string, data []byte) {
+	if _, ok := dc.index.getMemory(key); ok {
+		return
+	}
+	if err := dc.ds.Put(context.Background(), datastore.NewKey(key), data); err != nil {
+		log.Errorf("failed to write the gateway cache: %s", err)
+		return
+	}
+	dc.index.addMemory(key, nil, int64(len(data)))
+}
+
+// delete removes an evicted entry from the datastore.
+func (dc *gatewayDiskCache) delete(key string) {
+	if err := dc.ds.Delete(context.Background(), datastore.NewKey(key)); err != nil {
+		log.Errorf("failed to remove an entry of the gateway cache: %s", err)
+	}
+}
+
+// cachedFile is a files.File reading cached bytes. Unlike the files of
+// files.NewBytesFile, it can seek, which ServeContent needs.
+type cachedFile struct {
+	*bytes.Reader
+}
+
+func newCachedFile(data []byte) cachedFile {
+	return cachedFile{bytes.NewReader(data)}
+}
+
+func (f cachedFile) Close() error { return nil }
+
+func (f cachedFile) Size() (int64, error) { return f.Reader.Size(), nil }
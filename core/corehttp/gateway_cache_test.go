@@ -0,0 +1,100 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/kubo/config"
+)
+
+func TestGatewayCacheEviction(t *testing.T) {
+	var cfg config.GatewayCache
+	if err := json.Unmarshal([]byte(`{"MaxSize": 1000, "MaxEntrySize": 300}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	c := newGatewayCache(cfg, nil)
+
+	if !c.fits(300) || c.fits(301) {
+		t.Fatal("expected entries up to MaxEntrySize to fit")
+	}
+
+	// Each entry takes 200 + its key + the overhead, so three of them fit.
+	for _, k := range []string{"a", "b", "c"} {
+		c.add(k, k, 200)
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.add("d", "d", 200)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b, the least recently used entry, to be evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if v, ok := c.get(k); !ok || v.(string) != k {
+			t.Fatalf("expected %s to be cached", k)
+		}
+	}
+	if c.size > c.maxSize {
+		t.Fatalf("cache size %d is over its maximum %d", c.size, c.maxSize)
+	}
+
+	c.add("too-big", "", 1000)
+	if _, ok := c.get("too-big"); ok {
+		t.Fatal("expected entries larger than the cache not to be cached")
+	}
+
+	if err := json.Unmarshal([]byte(`{"MaxSize": 0}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if c := newGatewayCache(cfg, nil); c != nil || c.fits(0) {
+		t.Fatal("expected MaxSize 0 to disable the cache")
+	}
+}
+
+func TestGatewayCacheDisk(t *testing.T) {
+	var cfg config.GatewayCache
+	if err := json.Unmarshal([]byte(`{"MaxSize": 300, "MaxEntrySize": 200, "Namespace": "gateway-cache", "MaxDiskSize": 300}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	d := dssync.MutexWrap(datastore.NewMapDatastore())
+	c := newGatewayCache(cfg, d)
+
+	// Only one entry fits in memory, and two on disk.
+	for _, k := range []string{"block:a", "block:b"} {
+		c.add(k, []byte(k), 100)
+	}
+	if _, ok := c.getMemory("block:a"); ok {
+		t.Fatal("expected block:a to be evicted from memory")
+	}
+	if v, ok := c.get("block:a"); !ok || string(v.([]byte)) != "block:a" {
+		t.Fatal("expected block:a to be read from disk")
+	}
+
+	// Resolutions are not written to disk.
+	c.add("resolve:/ipfs/a", "a", 10)
+
+	c.add("block:c", []byte("block:c"), 100)
+	if _, ok := c.get("block:a"); !ok {
+		t.Fatal("expected block:a, the most recently used entry on disk, to be cached")
+	}
+	if ok, _ := d.Has(context.Background(), datastore.NewKey("/gateway-cache/block:b")); ok {
+		t.Fatal("expected block:b to be evicted from disk")
+	}
+
+	// The entries on disk outlive the memory.
+	c = newGatewayCache(cfg, d)
+	for _, k := range []string{"block:a", "block:c"} {
+		if v, ok := c.get(k); !ok || string(v.([]byte)) != k {
+			t.Fatalf("expected %s to be read from disk", k)
+		}
+	}
+	if _, ok := c.get("resolve:/ipfs/a"); ok {
+		t.Fatal("expected resolutions to be cached in memory only")
+	}
+	if c.disk.index.size > c.disk.index.maxSize {
+		t.Fatalf("disk cache size %d is over its maximum %d", c.disk.index.size, c.disk.index.maxSize)
+	}
+}
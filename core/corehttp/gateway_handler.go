@@ -2,6 +2,7 @@ package corehttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -13,6 +14,7 @@ import (
 	gopath "path"
 	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +27,8 @@ import (
 	"github.com/ipfs/go-path/resolver"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/denylist"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	routing "github.com/libp2p/go-libp2p/core/routing"
 	prometheus "github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/attribute"
@@ -69,6 +73,8 @@ type gatewayHandler struct {
 	config     GatewayConfig
 	api        NodeAPI
 	offlineAPI NodeAPI
+	limiter    *gatewayLimiter
+	cache      *gatewayCache
 
 	// generic metrics
 	firstContentBlockGetMetric *prometheus.HistogramVec
@@ -77,8 +83,14 @@ type gatewayHandler struct {
 	// response type metrics
 	unixfsFileGetMetric   *prometheus.HistogramVec
 	unixfsGenDirGetMetric *prometheus.HistogramVec
+	codecGetMetric        *prometheus.HistogramVec
+	ipnsRecordGetMetric   *prometheus.HistogramVec
 	carStreamGetMetric    *prometheus.HistogramVec
 	rawBlockGetMetric     *prometheus.HistogramVec
+
+	// cache metrics
+	cacheHitMetric  *prometheus.CounterVec
+	cacheMissMetric *prometheus.CounterVec
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -223,6 +235,7 @@ func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineAPI NodeAPI) *gatewa
 		config:     c,
 		api:        api,
 		offlineAPI: offlineAPI,
+		cache:      newGatewayCache(c.Cache, c.Datastore),
 		// Improved Metrics
 		// ----------------------------
 		// Time till the first content block (bar in /ipfs/cid/foo/bar)
@@ -244,6 +257,16 @@ func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineAPI NodeAPI) *gatewa
 			"gw_unixfs_gen_dir_listing_get_duration_seconds",
 			"The time to serve a generated UnixFS HTML directory listing from the gateway.",
 		),
+		// Codec: time it takes to return an IPLD node in the requested codec
+		codecGetMetric: newGatewayHistogramMetric(
+			"gw_codec_get_duration_seconds",
+			"The time to GET an IPLD node as DAG-JSON, DAG-CBOR, JSON or CBOR from the gateway.",
+		),
+		// IPNS Record: time it takes to return a signed IPNS record
+		ipnsRecordGetMetric: newGatewayHistogramMetric(
+			"gw_ipns_record_get_duration_seconds",
+			"The time to GET a signed IPNS record from the gateway.",
+		),
 		// CAR: time it takes to return requested CAR stream
 		carStreamGetMetric: newGatewayHistogramMetric(
 			"gw_car_stream_get_duration_seconds",
@@ -255,6 +278,18 @@ func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineAPI NodeAPI) *gatewa
 			"The time to GET an entire raw Block from the gateway.",
 		),
 
+		// Cache: lookups of resolved paths and small responses
+		cacheHitMetric: newGatewayCounterMetric(
+			"gw_cache_hits_total",
+			"The number of path resolutions and responses served from the gateway cache.",
+			"gateway", "cache",
+		),
+		cacheMissMetric: newGatewayCounterMetric(
+			"gw_cache_misses_total",
+			"The number of path resolutions and responses not found in the gateway cache.",
+			"gateway", "cache",
+		),
+
 		// Legacy Metrics
 		// ----------------------------
 		unixfsGetMetric: newGatewaySummaryMetric( // TODO: remove?
@@ -263,6 +298,12 @@ func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineAPI NodeAPI) *gatewa
 			"The time to receive the first UnixFS node on a GET from the gateway.",
 		),
 	}
+
+	limiter, err := newGatewayLimiter(c.RateLimits)
+	if err != nil {
+		log.Errorf("gateway rate limits are disabled: %s", err)
+	}
+	i.limiter = limiter
 	return i
 }
 
@@ -300,6 +341,16 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// OPTIONS requests are cheap, and refusing them would break CORS
+	// preflights for the requests we may accept.
+	if r.Method != http.MethodOptions {
+		release, ok := i.limiter.admit(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
 	if i.config.Writable {
 		switch r.Method {
 		case http.MethodPost:
@@ -385,11 +436,24 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	}
 	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResponseFormat", responseFormat))
 
+	// IPNS records are about the name itself, there is no content to resolve
+	if responseFormat == ipnsRecordContentType {
+		if i.isBlocked(w, contentPath) {
+			return
+		}
+		logger.Debugw("serving ipns record", "path", contentPath)
+		setAccessLogContent(r.Context(), cid.Undef, responseFormat)
+		i.addUserHeaders(w)
+		i.serveIpnsRecord(r.Context(), w, r, contentPath, begin, logger)
+		return
+	}
+
 	resolvedPath, contentPath, ok := i.handlePathResolution(w, r, responseFormat, contentPath, logger)
 	if !ok {
 		return
 	}
 	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResolvedPath", resolvedPath.String()))
+	setAccessLogContent(r.Context(), resolvedPath.Cid(), responseFormat)
 
 	// Detect when If-None-Match HTTP header allows returning HTTP 304 Not Modified
 	if inm := r.Header.Get("If-None-Match"); inm != "" {
@@ -417,7 +481,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	// Support custom response formats passed via ?format or Accept HTTP header
 	switch responseFormat {
-	case "": // The implicit response format is UnixFS
+	case "": // The implicit response format is UnixFS, or the codec of the CID
+		if isServedByCodec(resolvedPath.Cid()) {
+			logger.Debugw("serving codec", "path", contentPath)
+			i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, begin, responseFormat)
+			return
+		}
 		logger.Debugw("serving unixfs", "path", contentPath)
 		i.serveUnixFS(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
 		return
@@ -426,14 +495,48 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		i.serveRawBlock(r.Context(), w, r, resolvedPath, contentPath, begin)
 		return
 	case "application/vnd.ipld.car":
+		if !i.limiter.admitExpensive(w, r) {
+			return
+		}
 		logger.Debugw("serving car stream", "path", contentPath)
-		carVersion := formatParams["version"]
-		i.serveCAR(r.Context(), w, r, resolvedPath, contentPath, carVersion, begin)
+		i.serveCAR(r.Context(), w, r, resolvedPath, contentPath, formatParams, begin)
+		return
+	case "application/json", "application/cbor", "application/vnd.ipld.dag-json", "application/vnd.ipld.dag-cbor":
+		// UnixFS cannot be converted to JSON: directories get a listing,
+		// and files are returned as is
+		if responseFormat == "application/json" && i.isDirJSONRequest(r.Context(), r, resolvedPath) {
+			if !i.limiter.admitExpensive(w, r) {
+				return
+			}
+			logger.Debugw("serving directory listing", "path", contentPath)
+			i.serveDirectoryJSON(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
+			return
+		}
+		// Plain JSON and CBOR are only converted from content in an IPLD
+		// codec, other content like JSON files stored as UnixFS or raw
+		// blocks is returned as is
+		if (responseFormat == "application/json" || responseFormat == "application/cbor") && !isServedByCodec(resolvedPath.Cid()) {
+			logger.Debugw("serving unixfs", "path", contentPath)
+			i.serveUnixFS(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
+			return
+		}
+		logger.Debugw("serving codec", "path", contentPath)
+		i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, begin, responseFormat)
 		return
 	case "application/x-tar":
+		if !i.limiter.admitExpensive(w, r) {
+			return
+		}
 		logger.Debugw("serving tar file", "path", contentPath)
 		i.serveTAR(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
 		return
+	case "application/zip":
+		if !i.limiter.admitExpensive(w, r) {
+			return
+		}
+		logger.Debugw("serving zip file", "path", contentPath)
+		i.serveZIP(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
+		return
 	default: // catch-all for unsuported application/vnd.*
 		err := fmt.Errorf("unsupported format %q", responseFormat)
 		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
@@ -746,6 +849,8 @@ func webError(w http.ResponseWriter, message string, err error, defaultCode int)
 		webErrorWithCode(w, message, err, http.StatusNotFound)
 	} else if ipld.IsNotFound(err) {
 		webErrorWithCode(w, message, err, http.StatusNotFound)
+	} else if errors.As(err, new(datamodel.ErrNotExists)) {
+		webErrorWithCode(w, message, err, http.StatusNotFound)
 	} else if err == context.DeadlineExceeded {
 		webErrorWithCode(w, message, err, http.StatusRequestTimeout)
 	} else {
@@ -866,23 +971,72 @@ func customResponseFormat(r *http.Request) (mediaType string, params map[string]
 			return "application/vnd.ipld.car", nil, nil
 		case "tar":
 			return "application/x-tar", nil, nil
+		case "zip":
+			return "application/zip", nil, nil
+		case "json", "dir-json":
+			return "application/json", nil, nil
+		case "cbor":
+			return "application/cbor", nil, nil
+		case "dag-json":
+			return "application/vnd.ipld.dag-json", nil, nil
+		case "dag-cbor":
+			return "application/vnd.ipld.dag-cbor", nil, nil
+		case "ipns-record":
+			return ipnsRecordContentType, nil, nil
 		}
 	}
 	// Browsers and other user agents will send Accept header with generic types like:
 	// Accept:text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8
-	// We only care about explicit, vendor-specific content-types.
-	for _, accept := range r.Header.Values("Accept") {
-		// respond to the very first ipld content type
-		if strings.HasPrefix(accept, "application/vnd.ipld") ||
-			strings.HasPrefix(accept, "application/x-tar") {
-			mediatype, params, err := mime.ParseMediaType(accept)
+	// We only care about explicit, vendor-specific content-types, and the
+	// one with the highest quality wins. text/html competes with them for the
+	// default response, and the first one listed wins on equal quality.
+	var bestQuality float64
+	for _, header := range r.Header.Values("Accept") {
+		for _, accept := range strings.Split(header, ",") {
+			accept = strings.TrimSpace(accept)
+			html := isHTMLResponseFormat(accept)
+			if !html && !isCustomResponseFormat(accept) {
+				continue
+			}
+			mediatype, mtParams, err := mime.ParseMediaType(accept)
 			if err != nil {
 				return "", nil, err
 			}
-			return mediatype, params, nil
+			quality := 1.0
+			if q, ok := mtParams["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					return "", nil, fmt.Errorf("invalid quality %q of %s", q, mediatype)
+				}
+				delete(mtParams, "q")
+			}
+			if quality > bestQuality {
+				mediaType, params, bestQuality = mediatype, mtParams, quality
+				if html {
+					mediaType, params = "", nil
+				}
+			}
 		}
 	}
-	return "", nil, nil
+	return mediaType, params, nil
+}
+
+// isHTMLResponseFormat returns whether an element of the Accept header asks
+// for HTML, which the gateway responds with by default.
+func isHTMLResponseFormat(accept string) bool {
+	mediatype, _, _ := strings.Cut(accept, ";")
+	return strings.EqualFold(strings.TrimSpace(mediatype), "text/html")
+}
+
+// isCustomResponseFormat returns whether an element of the Accept header
+// asks for a response format handled by the gateway.
+func isCustomResponseFormat(accept string) bool {
+	mediatype, _, _ := strings.Cut(accept, ";")
+	mediatype = strings.ToLower(strings.TrimSpace(mediatype))
+	switch mediatype {
+	case "application/x-tar", "application/zip", ipnsRecordContentType, "application/json", "application/cbor":
+		return true
+	}
+	return strings.HasPrefix(mediatype, "application/vnd.ipld")
 }
 
 // returns unquoted path with all special characters revealed as \u codes
@@ -898,11 +1052,25 @@ func debugStr(path string) string {
 // the requested responseFormat. Returned ok flag indicates if gateway handler
 // should continue processing the request.
 func (i *gatewayHandler) handlePathResolution(w http.ResponseWriter, r *http.Request, responseFormat string, contentPath ipath.Path, logger *zap.SugaredLogger) (resolvedPath ipath.Resolved, newContentPath ipath.Path, ok bool) {
+	// Refuse blocked paths and names before resolving them.
+	if i.isBlocked(w, contentPath) {
+		return nil, nil, false
+	}
+
 	// Attempt to resolve the provided path.
-	resolvedPath, err := i.api.ResolvePath(r.Context(), contentPath)
+	immutablePath, resolvedPath, err := i.resolvePath(r.Context(), contentPath)
 
 	switch err {
 	case nil:
+		// The content may be blocked under another path: the /ipfs/ path
+		// a name points to, or its CID.
+		if immutablePath != contentPath && i.isBlocked(w, immutablePath) {
+			return nil, nil, false
+		}
+		if err := i.config.Denylist.CheckCid(denylist.SourceGateway, resolvedPath.Cid()); err != nil {
+			webErrorWithCode(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusGone)
+			return nil, nil, false
+		}
 		return resolvedPath, contentPath, true
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusServiceUnavailable)
@@ -937,6 +1105,54 @@ func (i *gatewayHandler) handlePathResolution(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// isBlocked returns whether contentPath is blocked by the denylist, in which
+// case the request was answered.
+func (i *gatewayHandler) isBlocked(w http.ResponseWriter, contentPath ipath.Path) bool {
+	if err := i.config.Denylist.CheckPath(denylist.SourceGateway, contentPath.String()); err != nil {
+		webErrorWithCode(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusGone)
+		return true
+	}
+	return false
+}
+
+// resolvePath resolves contentPath, using the cache when enabled. The name
+// of /ipns/ paths is resolved first, by namesys which caches it for the TTL
+// of its IPNS record or DNSLink, and only the immutable /ipfs/ path it points
+// to, which is returned as well, is cached.
+func (i *gatewayHandler) resolvePath(ctx context.Context, contentPath ipath.Path) (immutablePath ipath.Path, resolvedPath ipath.Resolved, err error) {
+	ns := contentPath.Namespace()
+	immutablePath = contentPath
+	if contentPath.Mutable() {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(contentPath.String(), "/ipns/"), "/")
+		root, err := i.api.ResolvePath(ctx, ipath.New("/ipns/"+name))
+		if err != nil {
+			return nil, nil, err
+		}
+		immutablePath = ipath.IpfsPath(root.Cid())
+		if rest != "" {
+			immutablePath = ipath.Join(immutablePath, rest)
+		}
+	}
+	if i.cache == nil {
+		resolvedPath, err = i.api.ResolvePath(ctx, immutablePath)
+		return immutablePath, resolvedPath, err
+	}
+
+	key := cacheKeyResolution + immutablePath.String()
+	if v, ok := i.cache.get(key); ok {
+		i.cacheHitMetric.WithLabelValues(ns, "resolution").Inc()
+		return immutablePath, v.(ipath.Resolved), nil
+	}
+	i.cacheMissMetric.WithLabelValues(ns, "resolution").Inc()
+
+	resolvedPath, err = i.api.ResolvePath(ctx, immutablePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	i.cache.add(key, resolvedPath, int64(len(resolvedPath.String())))
+	return immutablePath, resolvedPath, nil
+}
+
 // Detect 'Cache-Control: only-if-cached' in request and return data if it is already in the local datastore.
 // https://github.com/ipfs/specs/blob/main/http-gateways/PATH_GATEWAY.md#cache-control-request-header
 func (i *gatewayHandler) handleOnlyIfCached(w http.ResponseWriter, r *http.Request, contentPath ipath.Path, logger *zap.SugaredLogger) (requestHandled bool) {
@@ -18,12 +18,7 @@ func (i *gatewayHandler) serveRawBlock(ctx context.Context, w http.ResponseWrite
 	ctx, span := tracing.Span(ctx, "Gateway", "ServeRawBlock", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
 	blockCid := resolvedPath.Cid()
-	blockReader, err := i.api.Block().Get(ctx, resolvedPath)
-	if err != nil {
-		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
-		return
-	}
-	block, err := io.ReadAll(blockReader)
+	block, err := i.getRawBlock(ctx, resolvedPath, contentPath.Namespace())
 	if err != nil {
 		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
 		return
@@ -53,3 +48,29 @@ func (i *gatewayHandler) serveRawBlock(ctx context.Context, w http.ResponseWrite
 		i.rawBlockGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 	}
 }
+
+// getRawBlock returns the bytes of a block, from the cache if it is small
+// enough to be cached.
+func (i *gatewayHandler) getRawBlock(ctx context.Context, resolvedPath ipath.Resolved, ns string) ([]byte, error) {
+	key := cacheKeyRawBlock + resolvedPath.Cid().String()
+	if i.cache != nil {
+		if v, ok := i.cache.get(key); ok {
+			i.cacheHitMetric.WithLabelValues(ns, "response").Inc()
+			return v.([]byte), nil
+		}
+		i.cacheMissMetric.WithLabelValues(ns, "response").Inc()
+	}
+
+	blockReader, err := i.api.Block().Get(ctx, resolvedPath)
+	if err != nil {
+		return nil, err
+	}
+	block, err := io.ReadAll(blockReader)
+	if err != nil {
+		return nil, err
+	}
+	if i.cache.fits(int64(len(block))) {
+		i.cache.add(key, block, int64(len(block)))
+	}
+	return block, nil
+}
@@ -1,35 +1,192 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cespare/xxhash"
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
-	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	dag "github.com/ipfs/go-merkledag"
+	ipfspath "github.com/ipfs/go-path"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfs_pb "github.com/ipfs/go-unixfs/pb"
+	"github.com/ipfs/go-unixfsnode"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/ipfs/kubo/tracing"
 	gocar "github.com/ipld/go-car"
-	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	carutil "github.com/ipld/go-car/util"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mc "github.com/multiformats/go-multicodec"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// Scopes of the DAG returned in a CAR response, see dag-scope in
+// https://github.com/ipfs/specs/blob/main/http-gateways/PATH_GATEWAY.md
+const (
+	// dagScopeAll returns the whole DAG below the path.
+	dagScopeAll = "all"
+	// dagScopeEntity returns the entity at the path: a whole file, or a
+	// directory without its children.
+	dagScopeEntity = "entity"
+	// dagScopeBlock returns the block at the path only.
+	dagScopeBlock = "block"
+)
+
+// carParams are the parameters of a CAR request.
+type carParams struct {
+	scope string
+	// entityBytes is the byte range of a file to return blocks for, nil
+	// for the whole file.
+	entityBytes *entityBytes
+	// dups is whether blocks are repeated each time they are met in the
+	// DAG.
+	dups bool
+}
+
+// entityBytes is a byte range as passed in entity-bytes=from:to. A negative
+// from or to is relative to the end of the file, and a nil to is the end
+// of the file.
+type entityBytes struct {
+	from int64
+	to   *int64
+}
+
+// getCarParams reads the CAR parameters of a request, from the query and the
+// Accept header parameters.
+func getCarParams(r *http.Request, formatParams map[string]string) (carParams, error) {
+	q := r.URL.Query()
+	params := carParams{scope: dagScopeAll}
+
+	switch v := formatParams["version"]; v {
+	case "", "1": // we only support version 1
+	default:
+		return params, fmt.Errorf("unsupported CAR version %q, only version=1 is supported", v)
+	}
+
+	order := q.Get("car-order")
+	if order == "" {
+		order = formatParams["order"]
+	}
+	switch order {
+	case "", "dfs", "unk": // blocks are always sent in depth-first order
+	default:
+		return params, fmt.Errorf("unsupported CAR block order %q, only dfs and unk are supported", order)
+	}
+
+	dups := q.Get("car-dups")
+	if dups == "" {
+		dups = formatParams["dups"]
+	}
+	switch dups {
+	case "", "n":
+	case "y":
+		params.dups = true
+	default:
+		return params, fmt.Errorf("invalid CAR duplicates %q, must be y or n", dups)
+	}
+
+	switch scope := q.Get("dag-scope"); scope {
+	case "":
+	case dagScopeAll, dagScopeEntity, dagScopeBlock:
+		params.scope = scope
+	default:
+		return params, fmt.Errorf("invalid dag-scope %q, must be all, entity or block", scope)
+	}
+
+	if rng := q.Get("entity-bytes"); rng != "" {
+		eb, err := parseEntityBytes(rng)
+		if err != nil {
+			return params, err
+		}
+		params.entityBytes = eb
+	}
+	return params, nil
+}
+
+func parseEntityBytes(rng string) (*entityBytes, error) {
+	fromStr, toStr, ok := strings.Cut(rng, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid entity-bytes %q, must be from:to", rng)
+	}
+	from, err := strconv.ParseInt(fromStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity-bytes %q: %w", rng, err)
+	}
+	eb := &entityBytes{from: from}
+	if toStr != "*" {
+		to, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entity-bytes %q: %w", rng, err)
+		}
+		if from >= 0 && to >= 0 && to < from {
+			return nil, fmt.Errorf("invalid entity-bytes %q, from is after to", rng)
+		}
+		eb.to = &to
+	}
+	return eb, nil
+}
+
+// resolve returns the inclusive range of a file of the given size, and
+// whether it is not empty.
+func (eb *entityBytes) resolve(size int64) (from, to int64, ok bool) {
+	from, to = eb.from, size-1
+	if from < 0 {
+		from += size
+		if from < 0 {
+			from = 0
+		}
+	}
+	if eb.to != nil {
+		if to = *eb.to; to < 0 {
+			to += size
+		}
+		if to > size-1 {
+			to = size - 1
+		}
+	}
+	return from, to, from <= to
+}
+
+// etagSuffix distinguishes the Etag of responses with non-default
+// parameters.
+func (p carParams) etagSuffix() string {
+	if p.scope == dagScopeAll && p.entityBytes == nil && !p.dups {
+		return ""
+	}
+	s := fmt.Sprintf("dag-scope=%s;dups=%v", p.scope, p.dups)
+	if eb := p.entityBytes; eb != nil {
+		s += fmt.Sprintf(";entity-bytes=%d:", eb.from)
+		if eb.to != nil {
+			s += strconv.FormatInt(*eb.to, 10)
+		} else {
+			s += "*"
+		}
+	}
+	return "." + strconv.FormatUint(xxhash.Sum64String(s), 32)
+}
+
 // serveCAR returns a CAR stream for specific DAG+selector
-func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, carVersion string, begin time.Time) {
+func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, formatParams map[string]string, begin time.Time) {
 	ctx, span := tracing.Span(ctx, "Gateway", "ServeCAR", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	switch carVersion {
-	case "": // noop, client does not care about version
-	case "1": // noop, we support this
-	default:
-		err := fmt.Errorf("only version=1 is supported")
-		webError(w, "unsupported CAR version", err, http.StatusBadRequest)
+	params, err := getCarParams(r, formatParams)
+	if err != nil {
+		webError(w, "invalid CAR request", err, http.StatusBadRequest)
 		return
 	}
 	rootCid := resolvedPath.Cid()
@@ -48,10 +205,9 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 
 	// Weak Etag W/ because we can't guarantee byte-for-byte identical
 	// responses, but still want to benefit from HTTP Caching. Two CAR
-	// responses for the same CID and selector will be logically equivalent,
-	// but when CAR is streamed, then in theory, blocks may arrive from
-	// datastore in non-deterministic order.
-	etag := `W/` + getEtag(r, rootCid)
+	// responses for the same CID and parameters will be logically
+	// equivalent.
+	etag := `W/` + strings.TrimSuffix(getEtag(r, rootCid), `"`) + params.etagSuffix() + `"`
 	w.Header().Set("Etag", etag)
 
 	// Finish early if Etag match
@@ -60,22 +216,42 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
+	// The blocks needed to verify the path from its root are sent first,
+	// and fetched before answering so errors can still be reported.
+	cw := &carWriter{ctx: ctx, api: i.api, dups: params.dups, seen: make(map[cid.Cid]struct{}), walked: make(map[cid.Cid]struct{})}
+	pathBlocks, terminal, err := cw.resolvePathBlocks(resolvedPath)
+	if err != nil {
+		webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusInternalServerError)
+		return
+	}
+
 	// Make it clear we don't support range-requests over a car stream
-	// Partial downloads and resumes should be handled using requests for
-	// sub-DAGs and IPLD selectors: https://github.com/ipfs/go-ipfs/issues/8769
+	// Partial downloads should use entity-bytes instead.
 	w.Header().Set("Accept-Ranges", "none")
 
-	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	dups := "n"
+	if params.dups {
+		dups = "y"
+	}
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1; order=dfs; dups="+dups)
 	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
 
-	// Same go-car settings as dag.export command
-	store := dagStore{dag: i.api.Dag(), ctx: ctx}
-
-	// TODO: support selectors passed as request param: https://github.com/ipfs/kubo/issues/8769
-	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
-	car := gocar.NewSelectiveCar(ctx, store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
-
-	if err := car.Write(w); err != nil {
+	cw.w = w
+	err = gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{rootCid}, Version: 1}, w)
+	for _, blk := range pathBlocks {
+		if err != nil {
+			break
+		}
+		err = cw.write(blk)
+	}
+	if err == nil {
+		if terminal != nil {
+			err = cw.writeNodeLinks(terminal, params.scope)
+		} else {
+			err = cw.writeEntity(rootCid, params.scope, params.entityBytes)
+		}
+	}
+	if err != nil {
 		// We return error as a trailer, however it is not something browsers can access
 		// (https://github.com/mdn/browser-compat-data/issues/14703)
 		// Due to this, we suggest client always verify that
@@ -88,12 +264,274 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 	i.carStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 }
 
-// FIXME(@Jorropo): https://github.com/ipld/go-car/issues/315
-type dagStore struct {
-	dag coreiface.APIDagService
-	ctx context.Context
+// carWriter writes the blocks of a DAG to a CAR stream, in depth-first
+// order.
+type carWriter struct {
+	ctx  context.Context
+	api  NodeAPI
+	w    io.Writer
+	dups bool
+	seen map[cid.Cid]struct{}
+
+	// walked are the blocks whose whole DAG was written. Unless dups are
+	// written, they are not fetched and walked again when linked again, so
+	// that DAGs linking the same blocks many times stay cheap to write.
+	walked map[cid.Cid]struct{}
+}
+
+func (cw *carWriter) get(c cid.Cid) (blocks.Block, error) {
+	r, err := cw.api.Block().Get(cw.ctx, ipath.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// walk records that the whole DAG below c is being written, and returns
+// false when it already was and does not need to be written again.
+func (cw *carWriter) walk(c cid.Cid) bool {
+	if cw.dups {
+		return true
+	}
+	if _, ok := cw.walked[c]; ok {
+		return false
+	}
+	cw.walked[c] = struct{}{}
+	return true
+}
+
+func (cw *carWriter) write(blk blocks.Block) error {
+	if _, ok := cw.seen[blk.Cid()]; ok && !cw.dups {
+		return nil
+	}
+	cw.seen[blk.Cid()] = struct{}{}
+	return carutil.LdWrite(cw.w, blk.Cid().Bytes(), blk.RawData())
 }
 
-func (ds dagStore) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
-	return ds.dag.Get(ds.ctx, c)
+// resolvePathBlocks returns the blocks traversed to resolve the path of
+// resolvedPath from its root, including HAMT shards, but not the block the
+// path points to. When the path ends inside the fields of a block, that
+// block is included, and the node the path points to is returned.
+func (cw *carWriter) resolvePathBlocks(resolvedPath ipath.Resolved) ([]blocks.Block, ipld.Node, error) {
+	var segments []string
+	for _, s := range ipfspath.Path(resolvedPath.String()).Segments()[2:] {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, nil, nil
+	}
+
+	var pathBlocks []blocks.Block
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.NodeReifier = unixfsnode.Reify
+	lsys.StorageReadOpener = func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link %s", lnk)
+		}
+		blk, err := cw.get(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		pathBlocks = append(pathBlocks, blk)
+		return bytes.NewReader(blk.RawData()), nil
+	}
+	chooser := dagpb.AddSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+
+	lnk := ipld.Link(cidlink.Link{Cid: resolvedPath.Root()})
+	for len(segments) > 0 {
+		lctx := ipld.LinkContext{Ctx: cw.ctx}
+		proto, err := chooser(lnk, lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		node, err := lsys.Load(lctx, lnk, proto)
+		if err != nil {
+			return nil, nil, err
+		}
+		for len(segments) > 0 {
+			node, err = node.LookupBySegment(ipld.ParsePathSegment(segments[0]))
+			if err != nil {
+				return nil, nil, err
+			}
+			segments = segments[1:]
+			if node.Kind() == ipld.Kind_Link {
+				if lnk, err = node.AsLink(); err != nil {
+					return nil, nil, err
+				}
+				break
+			}
+		}
+		if len(segments) == 0 && node.Kind() != ipld.Kind_Link {
+			// the path ends inside the last loaded block
+			return pathBlocks, node, nil
+		}
+	}
+	return pathBlocks, nil, nil
+}
+
+// writeNodeLinks writes the DAGs linked from a node inside a block, for a
+// path ending inside the fields of a block.
+func (cw *carWriter) writeNodeLinks(n ipld.Node, scope string) error {
+	if scope != dagScopeAll {
+		return nil
+	}
+	var links []dagLink
+	collectDagLinks(n, "", &links)
+	for _, l := range links {
+		c, err := cid.Decode(l.CID)
+		if err != nil {
+			return err
+		}
+		if err := cw.writeEntity(c, dagScopeAll, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntity writes the block c, and the blocks below it for the scope.
+// entityBytes only applies when c is a file.
+func (cw *carWriter) writeEntity(c cid.Cid, scope string, eb *entityBytes) error {
+	if scope == dagScopeAll && eb == nil && !cw.walk(c) {
+		return nil
+	}
+	blk, err := cw.get(c)
+	if err != nil {
+		return err
+	}
+	if err := cw.write(blk); err != nil {
+		return err
+	}
+	if scope == dagScopeBlock {
+		return nil
+	}
+
+	switch mc.Code(c.Prefix().Codec) {
+	case mc.Raw:
+		return nil
+	case mc.DagPb:
+		pn, err := dag.DecodeProtobuf(blk.RawData())
+		if err != nil {
+			return err
+		}
+		fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+		if err != nil {
+			// not UnixFS, there is no entity beyond the block
+			if scope == dagScopeAll {
+				return cw.writeLinks(pn)
+			}
+			return nil
+		}
+		switch fsn.Type() {
+		case unixfs_pb.Data_File, unixfs_pb.Data_Raw:
+			if eb == nil {
+				return cw.writeLinks(pn)
+			}
+			from, to, ok := eb.resolve(int64(fsn.FileSize()))
+			if !ok {
+				return nil
+			}
+			return cw.writeFileRange(pn, fsn, from, to)
+		case unixfs_pb.Data_HAMTShard:
+			if scope == dagScopeAll {
+				return cw.writeLinks(pn)
+			}
+			// the entity of a sharded directory is all its shards
+			padLen := len(fmt.Sprintf("%X", fsn.Fanout()-1))
+			for _, l := range pn.Links() {
+				if len(l.Name) == padLen {
+					if err := cw.writeEntity(l.Cid, dagScopeEntity, nil); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		default:
+			if scope == dagScopeAll {
+				return cw.writeLinks(pn)
+			}
+			return nil
+		}
+	default:
+		if scope != dagScopeAll {
+			return nil
+		}
+		decoder, err := multicodec.LookupDecoder(c.Prefix().Codec)
+		if err != nil {
+			return err
+		}
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := decoder(nb, bytes.NewReader(blk.RawData())); err != nil {
+			return err
+		}
+		return cw.writeNodeLinks(nb.Build(), scope)
+	}
+}
+
+func (cw *carWriter) writeLinks(pn *dag.ProtoNode) error {
+	for _, l := range pn.Links() {
+		if err := cw.writeEntity(l.Cid, dagScopeAll, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileRange writes the blocks of the file below pn holding the bytes
+// from to to (inclusive, relative to the start of pn).
+func (cw *carWriter) writeFileRange(pn *dag.ProtoNode, fsn *unixfs.FSNode, from, to int64) error {
+	offset := int64(len(fsn.Data()))
+	for i, l := range pn.Links() {
+		if offset > to {
+			break
+		}
+		size := int64(fsn.BlockSize(i))
+		if offset+size > from {
+			if from <= offset && offset+size-1 <= to && !cw.walk(l.Cid) {
+				// the whole child is in the range, and was already written
+				offset += size
+				continue
+			}
+			if err := cw.writeChildRange(l.Cid, from-offset, to-offset); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+	return nil
+}
+
+func (cw *carWriter) writeChildRange(c cid.Cid, from, to int64) error {
+	blk, err := cw.get(c)
+	if err != nil {
+		return err
+	}
+	if err := cw.write(blk); err != nil {
+		return err
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil
+	}
+	pn, err := dag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return err
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return err
+	}
+	if from < 0 {
+		from = 0
+	}
+	return cw.writeFileRange(pn, fsn, from, to)
 }
@@ -0,0 +1,271 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/assets"
+	"github.com/ipfs/kubo/tracing"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/cbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/json"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/traversal"
+	mc "github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// codecToContentType maps the codecs served with serveCodec to their
+// content type.
+var codecToContentType = map[mc.Code]string{
+	mc.Json:    "application/json",
+	mc.Cbor:    "application/cbor",
+	mc.DagJson: "application/vnd.ipld.dag-json",
+	mc.DagCbor: "application/vnd.ipld.dag-cbor",
+}
+
+// contentTypeToCodec is the reverse of codecToContentType.
+var contentTypeToCodec = map[string]mc.Code{
+	"application/json":              mc.Json,
+	"application/cbor":              mc.Cbor,
+	"application/vnd.ipld.dag-json": mc.DagJson,
+	"application/vnd.ipld.dag-cbor": mc.DagCbor,
+}
+
+// isServedByCodec returns whether content with CID c is served with
+// serveCodec when no response format is requested.
+func isServedByCodec(c cid.Cid) bool {
+	_, ok := codecToContentType[mc.Code(c.Prefix().Codec)]
+	return ok
+}
+
+// serveCodec returns the IPLD node behind resolvedPath encoded with the codec
+// of requestedContentType. The node is returned as is when it already is in
+// the requested codec, and converted otherwise. An empty
+// requestedContentType serves the node in its own codec, or as an HTML page
+// when a browser asks for one.
+func (i *gatewayHandler) serveCodec(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time, requestedContentType string) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeCodec", trace.WithAttributes(attribute.String("path", resolvedPath.String()), attribute.String("requestedContentType", requestedContentType)))
+	defer span.End()
+
+	blockCid := resolvedPath.Cid()
+	cidCodec := mc.Code(blockCid.Prefix().Codec)
+
+	if requestedContentType == "" {
+		if isHTMLRequest(r) {
+			i.serveCodecHTML(ctx, w, r, resolvedPath, contentPath)
+			return
+		}
+		requestedContentType = codecToContentType[cidCodec]
+	}
+	toCodec, ok := contentTypeToCodec[requestedContentType]
+	if !ok {
+		err := fmt.Errorf("unsupported content type %q", requestedContentType)
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	if toCodec == cidCodec && resolvedPath.Remainder() == "" {
+		// The block already is what was asked for.
+		blockReader, err := i.api.Block().Get(ctx, resolvedPath)
+		if err != nil {
+			webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+			return
+		}
+		if data, err = io.ReadAll(blockReader); err != nil {
+			webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		node, err := i.getCodecNode(ctx, resolvedPath)
+		if err != nil {
+			webError(w, "ipfs dag get "+debugStr(contentPath.String()), err, http.StatusNotFound)
+			return
+		}
+		encoder, err := multicodec.LookupEncoder(uint64(toCodec))
+		if err != nil {
+			webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+			return
+		}
+		var buf bytes.Buffer
+		if err := encoder(node, &buf); err != nil {
+			err = fmt.Errorf("cannot convert %s to %s: %w", cidCodec, toCodec, err)
+			webError(w, "failed respond with requested content type", err, http.StatusNotAcceptable)
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	// Set Content-Disposition
+	var name string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	} else {
+		name = blockCid.String() + "." + strings.TrimPrefix(toCodec.String(), "dag-")
+	}
+	disposition := "inline"
+	if toCodec == mc.Cbor || toCodec == mc.DagCbor {
+		// CBOR is binary, browsers would only offer to download it
+		disposition = "attachment"
+	}
+	setContentDispositionHeader(w, name, disposition)
+
+	// Set remaining headers
+	modtime := addCacheControlHeaders(w, r, contentPath, blockCid)
+	w.Header().Set("Content-Type", requestedContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, dataSent, _ := ServeContent(w, r, name, modtime, bytes.NewReader(data))
+
+	if dataSent {
+		i.codecGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// getCodecNode returns the IPLD node at the end of resolvedPath, following
+// its remainder into the fields of the last block.
+func (i *gatewayHandler) getCodecNode(ctx context.Context, resolvedPath ipath.Resolved) (ipld.Node, error) {
+	obj, err := i.api.Dag().Get(ctx, resolvedPath.Cid())
+	if err != nil {
+		return nil, err
+	}
+	universal, ok := obj.(ipldlegacy.UniversalNode)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a valid IPLD node", obj)
+	}
+
+	var node ipld.Node = universal
+	if rem := resolvedPath.Remainder(); rem != "" {
+		if node, err = traversal.Get(node, ipld.ParsePath(rem)); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// dagTemplateData is what the DAG explorer template renders.
+type dagTemplateData struct {
+	Path      string
+	CID       string
+	CodecName string
+	CodecHex  string
+	Remainder string
+	Node      string
+	Links     []dagLink
+}
+
+type dagLink struct {
+	Path string
+	CID  string
+}
+
+// serveCodecHTML presents the IPLD node behind resolvedPath as an HTML page,
+// with links to the blocks it links to.
+func (i *gatewayHandler) serveCodecHTML(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path) {
+	blockCid := resolvedPath.Cid()
+	node, err := i.getCodecNode(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs dag get "+debugStr(contentPath.String()), err, http.StatusNotFound)
+		return
+	}
+
+	var compact, indented bytes.Buffer
+	if err := dagjson.Encode(node, &compact); err != nil {
+		webError(w, "failed to encode DAG-JSON", err, http.StatusInternalServerError)
+		return
+	}
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		webError(w, "failed to encode DAG-JSON", err, http.StatusInternalServerError)
+		return
+	}
+
+	var links []dagLink
+	collectDagLinks(node, "", &links)
+
+	codec := mc.Code(blockCid.Prefix().Codec)
+	data := dagTemplateData{
+		Path:      contentPath.String(),
+		CID:       blockCid.String(),
+		CodecName: codec.String(),
+		CodecHex:  fmt.Sprintf("0x%x", uint64(codec)),
+		Remainder: resolvedPath.Remainder(),
+		Node:      indented.String(),
+		Links:     links,
+	}
+
+	// The page depends on the assets, so the Etag does too.
+	w.Header().Set("Etag", `"DagIndex-`+assets.AssetHash+`_CID-`+blockCid.String()+`"`)
+	if contentPath.Mutable() {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	}
+	w.Header().Set("Content-Type", "text/html")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := dagTemplate.Execute(w, data); err != nil {
+		internalWebError(w, err)
+	}
+}
+
+// collectDagLinks appends the links found in n to links, with their path
+// relative to n.
+func collectDagLinks(n ipld.Node, prefix string, links *[]dagLink) {
+	switch n.Kind() {
+	case ipld.Kind_Link:
+		if l, err := n.AsLink(); err == nil {
+			if cl, ok := l.(cidlink.Link); ok {
+				*links = append(*links, dagLink{Path: prefix, CID: cl.Cid.String()})
+			}
+		}
+	case ipld.Kind_Map:
+		for it := n.MapIterator(); !it.Done(); {
+			k, v, err := it.Next()
+			if err != nil {
+				return
+			}
+			ks, err := k.AsString()
+			if err != nil {
+				continue
+			}
+			collectDagLinks(v, prefix+"/"+ks, links)
+		}
+	case ipld.Kind_List:
+		for it := n.ListIterator(); !it.Done(); {
+			idx, v, err := it.Next()
+			if err != nil {
+				return
+			}
+			collectDagLinks(v, fmt.Sprintf("%s/%d", prefix, idx), links)
+		}
+	}
+}
+
+// isHTMLRequest returns whether the client, most likely a browser, prefers
+// an HTML page.
+func isHTMLRequest(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			if strings.HasPrefix(strings.TrimSpace(t), "text/html") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	namesys "github.com/ipfs/go-namesys"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/tracing"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	routing "github.com/libp2p/go-libp2p/core/routing"
+	mh "github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const ipnsRecordContentType = "application/vnd.ipfs.ipns-record"
+
+// serveIpnsRecord returns the signed IPNS record of the key in contentPath,
+// so clients can verify the resolution themselves.
+func (i *gatewayHandler) serveIpnsRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeIpnsRecord", trace.WithAttributes(attribute.String("path", contentPath.String())))
+	defer span.End()
+
+	if contentPath.Namespace() != "ipns" {
+		err := fmt.Errorf("%s is not an IPNS path", contentPath.String())
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+	segments := strings.Split(strings.Trim(contentPath.String(), "/"), "/")
+	if len(segments) != 2 {
+		err := errors.New("IPNS records are only available for /ipns/{key}, without subpath")
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+	name := segments[1]
+	pid, err := peer.Decode(name)
+	if err != nil {
+		// DNSLink names have no record
+		err = fmt.Errorf("%q is not an IPNS key: %w", name, err)
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+	// The name may be blocked in another form than the requested one.
+	if i.isBlocked(w, ipath.New("/ipns/"+pid.String())) {
+		return
+	}
+	if i.config.Routing == nil {
+		webError(w, "ipfs routing get "+ipns.RecordKey(pid), errors.New("routing is not available"), http.StatusNotImplemented)
+		return
+	}
+
+	// The routing validates the record before returning it.
+	rawRecord, err := i.config.Routing.GetValue(ctx, ipns.RecordKey(pid))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, routing.ErrNotFound) || errors.Is(err, datastore.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		webError(w, "ipfs routing get "+ipns.RecordKey(pid), err, status)
+		return
+	}
+	var record ipns_pb.IpnsEntry
+	if err := record.Unmarshal(rawRecord); err != nil {
+		webError(w, "failed to parse the IPNS record", err, http.StatusInternalServerError)
+		return
+	}
+
+	// The record is cached as long as it would be by IPNS resolvers: for its
+	// TTL, or the default TTL of namesys without one, and no longer than it
+	// is valid.
+	maxAge := namesys.DefaultResolverCacheTTL
+	if record.Ttl != nil {
+		maxAge = time.Duration(record.GetTtl())
+	}
+	if eol, err := ipns.GetEOL(&record); err == nil {
+		if untilEOL := time.Until(eol); untilEOL < maxAge {
+			maxAge = untilEOL
+		}
+		w.Header().Set("Expires", eol.UTC().Format(http.TimeFormat))
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(math.Ceil(maxAge.Seconds()))))
+
+	recordHash, err := mh.Sum(rawRecord, mh.SHA2_256, -1)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	w.Header().Set("Etag", getEtag(r, cid.NewCidV1(cid.Raw, recordHash)))
+
+	// Set Content-Disposition
+	var filename string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		filename = urlFilename
+	} else {
+		filename = name + ".ipns-record"
+	}
+	setContentDispositionHeader(w, filename, "attachment")
+
+	w.Header().Set("Content-Type", ipnsRecordContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, filename, noModtime, bytes.NewReader(rawRecord))
+
+	if dataSent {
+		i.ipnsRecordGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+	logger.Debugw("served ipns record", "name", name)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"html"
+	"io"
 	"net/http"
 	"time"
 
@@ -19,6 +20,15 @@ func (i *gatewayHandler) serveUnixFS(ctx context.Context, w http.ResponseWriter,
 	ctx, span := tracing.Span(ctx, "Gateway", "ServeUnixFS", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
 
+	// Small files are served from the cache, without loading their DAG
+	fileKey := cacheKeyUnixFSFile + resolvedPath.Cid().String()
+	if v, ok := i.cache.get(fileKey); ok {
+		i.cacheHitMetric.WithLabelValues(contentPath.Namespace(), "response").Inc()
+		logger.Debugw("serving cached unixfs file", "path", contentPath)
+		i.serveFile(ctx, w, r, resolvedPath, contentPath, newCachedFile(v.([]byte)), begin)
+		return
+	}
+
 	// Handling UnixFS
 	dr, err := i.api.Unixfs().Get(ctx, resolvedPath)
 	if err != nil {
@@ -29,6 +39,18 @@ func (i *gatewayHandler) serveUnixFS(ctx context.Context, w http.ResponseWriter,
 
 	// Handling Unixfs file
 	if f, ok := dr.(files.File); ok {
+		if _, isSymlink := f.(*files.Symlink); !isSymlink && i.cache != nil {
+			i.cacheMissMetric.WithLabelValues(contentPath.Namespace(), "response").Inc()
+			if size, err := f.Size(); err == nil && i.cache.fits(size) {
+				data, err := io.ReadAll(f)
+				if err != nil {
+					webError(w, "ipfs cat "+html.EscapeString(contentPath.String()), err, http.StatusInternalServerError)
+					return
+				}
+				i.cache.add(fileKey, data, int64(len(data)))
+				f = newCachedFile(data)
+			}
+		}
 		logger.Debugw("serving unixfs file", "path", contentPath)
 		i.serveFile(ctx, w, r, resolvedPath, contentPath, f, begin)
 		return
@@ -92,6 +92,11 @@ func (i *gatewayHandler) serveDirectory(ctx context.Context, w http.ResponseWrit
 		return
 	}
 
+	// Generated listings are expensive, so they have their own rate limit.
+	if !i.limiter.admitExpensive(w, r) {
+		return
+	}
+
 	// A HTML directory index will be presented, be sure to set the correct
 	// type instead of relying on autodetection (which may fail).
 	w.Header().Set("Content-Type", "text/html")
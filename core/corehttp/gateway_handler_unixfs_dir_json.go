@@ -0,0 +1,214 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash"
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// dirJSONListing is a page of a directory listing, as returned with
+// ?format=dir-json.
+type dirJSONListing struct {
+	Path    string
+	Cid     string
+	Entries []dirJSONEntry
+	// NextCursor is the cursor of the next page, empty on the last page.
+	NextCursor string `json:",omitempty"`
+}
+
+type dirJSONEntry struct {
+	Name string
+	Cid  string
+	Type string
+	Size uint64
+}
+
+// Types of the entries of a dirJSONListing.
+const (
+	dirJSONTypeFile      = "file"
+	dirJSONTypeDirectory = "directory"
+	dirJSONTypeSymlink   = "symlink"
+	dirJSONTypeUnknown   = "unknown"
+)
+
+// isDirJSONRequest returns whether a request for JSON asks for a directory
+// listing: when ?format=dir-json is explicit, or the content is a UnixFS
+// directory. UnixFS files are served as is.
+func (i *gatewayHandler) isDirJSONRequest(ctx context.Context, r *http.Request, resolvedPath ipath.Resolved) bool {
+	if r.URL.Query().Get("format") == "dir-json" {
+		return true
+	}
+	if resolvedPath.Cid().Prefix().Codec != cid.DagProtobuf || resolvedPath.Remainder() != "" {
+		return false
+	}
+	// The root block was already fetched by handleGettingFirstBlock.
+	blockReader, err := i.api.Block().Get(ctx, resolvedPath)
+	if err != nil {
+		return false
+	}
+	data, err := io.ReadAll(blockReader)
+	if err != nil {
+		return false
+	}
+	pn, err := dag.DecodeProtobuf(data)
+	if err != nil {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	return err == nil && fsn.IsDir()
+}
+
+// serveDirectoryJSON returns a page of the listing of the UnixFS directory
+// behind resolvedPath as JSON.
+//
+// Pages hold at most FastDirIndexThreshold entries, the type and size of
+// each entry being read from its root block. The "cursor" query parameter
+// is the name of the last entry of the previous page, so that big (HAMT
+// sharded) directories can be listed incrementally, and "limit" requests
+// smaller pages.
+func (i *gatewayHandler) serveDirectoryJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeDirectoryJSON", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+
+	pageSize := i.config.FastDirIndexThreshold
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	query := r.URL.Query()
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			webError(w, "failed to parse the limit", fmt.Errorf("invalid limit %q", limit), http.StatusBadRequest)
+			return
+		}
+		if n < pageSize {
+			pageSize = n
+		}
+	}
+	cursor := query.Get("cursor")
+
+	dr, err := i.api.Unixfs().Get(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs ls "+debugStr(contentPath.String()), err, http.StatusBadRequest)
+		return
+	}
+	dr.Close()
+	if _, ok := dr.(files.Directory); !ok {
+		err := fmt.Errorf("%s is not a UnixFS directory", contentPath.String())
+		webError(w, "failed respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+
+	// Pages are cached like the content they list, and told apart by their
+	// parameters.
+	rootCid := resolvedPath.Cid()
+	modtime := addCacheControlHeaders(w, r, contentPath, rootCid)
+	etag := getEtag(r, rootCid)
+	if cursor != "" || pageSize != i.config.FastDirIndexThreshold {
+		etag = strings.TrimSuffix(etag, `"`) + "." + strconv.FormatUint(xxhash.Sum64String(fmt.Sprintf("%d/%s", pageSize, cursor)), 32) + `"`
+	}
+	w.Header().Set("Etag", etag)
+	// Finish early if Etag match
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Links are listed without fetching the children, only the entries of
+	// the page are fetched below.
+	lsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results, err := i.api.Unixfs().Ls(lsCtx, resolvedPath, options.Unixfs.ResolveChildren(false))
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	listing := dirJSONListing{
+		Path:    contentPath.String(),
+		Cid:     rootCid.String(),
+		Entries: []dirJSONEntry{},
+	}
+	var entryCids []cid.Cid
+	skipping := cursor != ""
+	for link := range results {
+		if link.Err != nil {
+			internalWebError(w, link.Err)
+			return
+		}
+		if skipping {
+			skipping = link.Name != cursor
+			continue
+		}
+		if len(listing.Entries) == pageSize {
+			listing.NextCursor = listing.Entries[pageSize-1].Name
+			break
+		}
+		listing.Entries = append(listing.Entries, dirJSONEntry{
+			Name: link.Name,
+			Cid:  link.Cid.String(),
+		})
+		entryCids = append(entryCids, link.Cid)
+	}
+	if skipping {
+		err := fmt.Errorf("no entry named %q", cursor)
+		webError(w, "failed to parse the cursor", err, http.StatusBadRequest)
+		return
+	}
+
+	for n := range listing.Entries {
+		entry := &listing.Entries[n]
+		entry.Type = dirJSONTypeUnknown
+		nd, err := i.api.Unixfs().Get(ctx, ipath.IpfsPath(entryCids[n]))
+		if err != nil {
+			// Like the HTML listing, go on without the details.
+			logger.Debugw("failed to get directory entry", "name", entry.Name, "error", err)
+			continue
+		}
+		switch nd.(type) {
+		case *files.Symlink:
+			entry.Type = dirJSONTypeSymlink
+		case files.File:
+			entry.Type = dirJSONTypeFile
+		case files.Directory:
+			entry.Type = dirJSONTypeDirectory
+		}
+		if size, err := nd.Size(); err == nil && size > 0 {
+			entry.Size = uint64(size)
+		}
+		nd.Close()
+	}
+
+	data, err := json.Marshal(listing)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, dataSent, _ := ServeContent(w, r, rootCid.String()+".json", modtime, bytes.NewReader(data))
+
+	if dataSent {
+		i.unixfsGenDirGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
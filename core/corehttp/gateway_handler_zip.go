@@ -0,0 +1,64 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/core/coreunix"
+	"github.com/ipfs/kubo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func (i *gatewayHandler) serveZIP(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeZIP", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rootCid := resolvedPath.Cid()
+
+	// Set Cache-Control and read optional Last-Modified time
+	modtime := addCacheControlHeaders(w, r, contentPath, rootCid)
+
+	// Weak Etag W/ because we can't guarantee byte-for-byte identical
+	// responses: entries without UnixFS mtime get the time of the request.
+	etag := `W/` + getEtag(r, rootCid)
+	w.Header().Set("Etag", etag)
+
+	// Finish early if Etag match
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Set Content-Disposition
+	var name string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	} else {
+		name = rootCid.String() + ".zip"
+	}
+	setContentDispositionHeader(w, name, "attachment")
+
+	if !(modtime.IsZero() || modtime.Equal(unixEpochTime)) {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// The ZIP has a top-level directory (or file) named by the CID.
+	if err := coreunix.WriteZip(ctx, i.api, rootCid, rootCid.String(), w); err != nil {
+		w.Header().Set("X-Stream-Error", err.Error())
+		// As for TAR, finish the response stream with the error message,
+		// leaving a corrupted ZIP clients can detect and inspect.
+		_, _ = w.Write([]byte(err.Error()))
+		logger.Debugw("failed to write zip", "path", contentPath, "error", err)
+		return
+	}
+}
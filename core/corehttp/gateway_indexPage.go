@@ -94,6 +94,9 @@ func hasDNSLinkOrigin(gwURL string, path string) bool {
 
 var listingTemplate *template.Template
 
+// dagTemplate presents IPLD nodes to browsers
+var dagTemplate *template.Template
+
 func init() {
 	knownIconsBytes, err := assets.Asset.ReadFile("dir-index-html/knownIcons.txt")
 	if err != nil {
@@ -131,4 +134,12 @@ func init() {
 		"iconFromExt": iconFromExt,
 		"urlEscape":   urlEscape,
 	}).Parse(string(dirIndexBytes)))
+
+	// DAG explorer template
+	dagIndexBytes, err := assets.Asset.ReadFile("dag-index-html/dag-index.html")
+	if err != nil {
+		panic(err)
+	}
+
+	dagTemplate = template.Must(template.New("dag").Parse(string(dagIndexBytes)))
 }
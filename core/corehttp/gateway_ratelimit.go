@@ -0,0 +1,299 @@
+package corehttp
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for refusing a request, as reported in the metrics.
+const (
+	rateLimitReasonClient      = "client"
+	rateLimitReasonExpensive   = "expensive"
+	rateLimitReasonConcurrency = "concurrency"
+)
+
+// idleClientTimeout is how long the buckets of a client IP are kept after
+// its last request.
+const idleClientTimeout = 10 * time.Minute
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket, or returns how long to wait until one
+// is available.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+type clientBuckets struct {
+	requests  tokenBucket
+	expensive tokenBucket
+	lastSeen  time.Time
+}
+
+// gatewayLimiter is the admission control of the gateway. A nil
+// gatewayLimiter admits all requests.
+type gatewayLimiter struct {
+	rate, burst                   float64
+	expensiveRate, expensiveBurst float64
+	trusted                       []*net.IPNet
+	sem                           chan struct{}
+
+	mu        sync.Mutex
+	clients   map[string]*clientBuckets
+	lastPrune time.Time
+	now       func() time.Time
+
+	limitedMetric    *prometheus.CounterVec
+	concurrentMetric prometheus.Gauge
+}
+
+// newGatewayLimiter returns the limiter enforcing cfg, or nil if cfg has no
+// limits.
+func newGatewayLimiter(cfg config.GatewayRateLimits) (*gatewayLimiter, error) {
+	trusted, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &gatewayLimiter{
+		rate:          float64(cfg.RequestsPerSecond.WithDefault(0)),
+		expensiveRate: float64(cfg.ExpensiveRequestsPerSecond.WithDefault(0)),
+		trusted:       trusted,
+		clients:       make(map[string]*clientBuckets),
+		now:           time.Now,
+	}
+	l.burst = burstOrRate(cfg.Burst, l.rate)
+	l.expensiveBurst = burstOrRate(cfg.ExpensiveBurst, l.expensiveRate)
+	if n := cfg.MaxConcurrentRequests.WithDefault(0); n > 0 {
+		l.sem = make(chan struct{}, n)
+	}
+	if l.rate <= 0 && l.expensiveRate <= 0 && l.sem == nil {
+		return nil, nil
+	}
+
+	l.limitedMetric = newGatewayCounterMetric(
+		"gw_rate_limited_requests_total",
+		"The number of requests refused by the gateway rate limits, by reason.",
+		"reason",
+	)
+	l.concurrentMetric = newGatewayGaugeMetric(
+		"gw_concurrent_requests",
+		"The number of requests being served by the gateway.",
+	)
+	return l, nil
+}
+
+// burstOrRate returns burst, defaulting to rate when unset or zero: a bucket
+// without burst would refuse every request.
+func burstOrRate(burst *config.OptionalInteger, rate float64) float64 {
+	if b := burst.WithDefault(0); b > 0 {
+		return float64(b)
+	}
+	return rate
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (l *gatewayLimiter) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client, as told by the X-Forwarded-For
+// header of trusted proxies.
+func (l *gatewayLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !l.isTrusted(ip) {
+		return host
+	}
+
+	// Walk the proxies from the closest one, up to the first one which is
+	// not trusted.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fip == nil {
+			break
+		}
+		ip = fip
+		if !l.isTrusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func (l *gatewayLimiter) buckets(client string, now time.Time) *clientBuckets {
+	if now.Sub(l.lastPrune) > idleClientTimeout {
+		for k, b := range l.clients {
+			if now.Sub(b.lastSeen) > idleClientTimeout {
+				delete(l.clients, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBuckets{}
+		l.clients[client] = b
+	}
+	b.lastSeen = now
+	return b
+}
+
+// admit checks the request against the concurrency cap and the rate of its
+// client. When it returns true, release must be called once the request is
+// served. Otherwise the request has been refused.
+func (l *gatewayLimiter) admit(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	if l.rate > 0 {
+		now := l.now()
+		l.mu.Lock()
+		ok, wait := l.buckets(l.clientIP(r), now).requests.take(now, l.rate, l.burst)
+		l.mu.Unlock()
+		if !ok {
+			l.refuse(w, rateLimitReasonClient, wait)
+			return nil, false
+		}
+	}
+
+	if l.sem == nil {
+		return func() {}, true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		l.concurrentMetric.Inc()
+		return func() {
+			l.concurrentMetric.Dec()
+			<-l.sem
+		}, true
+	default:
+		l.refuse(w, rateLimitReasonConcurrency, time.Second)
+		return nil, false
+	}
+}
+
+// admitExpensive checks an expensive request against the rate of expensive
+// requests of its client. When it returns false, the request has been
+// refused.
+func (l *gatewayLimiter) admitExpensive(w http.ResponseWriter, r *http.Request) bool {
+	if l == nil || l.expensiveRate <= 0 {
+		return true
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	ok, wait := l.buckets(l.clientIP(r), now).expensive.take(now, l.expensiveRate, l.expensiveBurst)
+	l.mu.Unlock()
+	if !ok {
+		l.refuse(w, rateLimitReasonExpensive, wait)
+	}
+	return ok
+}
+
+func (l *gatewayLimiter) refuse(w http.ResponseWriter, reason string, wait time.Duration) {
+	l.limitedMetric.WithLabelValues(reason).Inc()
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "too many requests, retry later", http.StatusTooManyRequests)
+}
+
+func newGatewayCounterMetric(name string, help string, labels ...string) *prometheus.CounterVec {
+	counterMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+	if err := prometheus.Register(counterMetric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counterMetric = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return counterMetric
+}
+
+func newGatewayGaugeMetric(name string, help string) prometheus.Gauge {
+	gaugeMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+	)
+	if err := prometheus.Register(gaugeMetric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			gaugeMetric = are.ExistingCollector.(prometheus.Gauge)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return gaugeMetric
+}
@@ -0,0 +1,156 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+func rateLimits(t *testing.T, js string) config.GatewayRateLimits {
+	var limits config.GatewayRateLimits
+	if err := json.Unmarshal([]byte(js), &limits); err != nil {
+		t.Fatal(err)
+	}
+	return limits
+}
+
+func TestGatewayLimiterRate(t *testing.T) {
+	l, err := newGatewayLimiter(rateLimits(t, `{
+		"RequestsPerSecond": 2,
+		"Burst": 3,
+		"ExpensiveRequestsPerSecond": 1
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	admit := func(remote string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		if release, ok := l.admit(rec, req); ok {
+			release()
+		}
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := admit("10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected the burst to be accepted, got %d", i, rec.Code)
+		}
+	}
+	rec := admit("10.0.0.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the burst, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("expected Retry-After: 1, got %q", got)
+	}
+	if rec := admit("10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected another client to be accepted, got %d", rec.Code)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if rec := admit("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a refilled token to be accepted, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/cid?format=car", nil)
+	req.RemoteAddr = "10.0.0.3:1234"
+	if !l.admitExpensive(httptest.NewRecorder(), req) {
+		t.Fatal("expected the first expensive request to be accepted")
+	}
+	if l.admitExpensive(httptest.NewRecorder(), req) {
+		t.Fatal("expected the second expensive request to be refused")
+	}
+}
+
+func TestGatewayLimiterZeroBurst(t *testing.T) {
+	l, err := newGatewayLimiter(rateLimits(t, `{
+		"RequestsPerSecond": 2,
+		"Burst": 0,
+		"ExpensiveRequestsPerSecond": 1,
+		"ExpensiveBurst": 0
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.burst != 2 || l.expensiveBurst != 1 {
+		t.Fatalf("expected zero bursts to default to the rates, got %v and %v", l.burst, l.expensiveBurst)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	release, ok := l.admit(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("expected the request to be accepted")
+	}
+	release()
+	if !l.admitExpensive(httptest.NewRecorder(), req) {
+		t.Fatal("expected the expensive request to be accepted")
+	}
+}
+
+func TestGatewayLimiterConcurrency(t *testing.T) {
+	l, err := newGatewayLimiter(rateLimits(t, `{"MaxConcurrentRequests": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+	release, ok := l.admit(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("expected the first request to be accepted")
+	}
+	rec := httptest.NewRecorder()
+	if _, ok := l.admit(rec, req); ok || rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the concurrency cap, got %d", rec.Code)
+	}
+	release()
+	if _, ok := l.admit(httptest.NewRecorder(), req); !ok {
+		t.Fatal("expected a request to be accepted once another one is done")
+	}
+}
+
+func TestGatewayLimiterClientIP(t *testing.T) {
+	l, err := newGatewayLimiter(rateLimits(t, `{
+		"RequestsPerSecond": 1,
+		"TrustedProxies": ["127.0.0.1", "10.0.0.0/8"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remote, forwarded, client string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"127.0.0.1:1234", "", "127.0.0.1"},
+		{"127.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"127.0.0.1:1234", "198.51.100.1, 10.1.2.3", "198.51.100.1"},
+		{"127.0.0.1:1234", "203.0.113.1, 198.51.100.1, 10.1.2.3", "198.51.100.1"},
+		{"127.0.0.1:1234", "10.1.2.3", "10.1.2.3"},
+		{"127.0.0.1:1234", "garbage, 198.51.100.1", "198.51.100.1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if got := l.clientIP(req); got != tc.client {
+			t.Errorf("%s with X-Forwarded-For %q: expected client %s, got %s", tc.remote, tc.forwarded, tc.client, got)
+		}
+	}
+
+	if _, err := newGatewayLimiter(config.GatewayRateLimits{TrustedProxies: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("expected an invalid trusted proxy to be rejected")
+	}
+}
@@ -1,11 +1,19 @@
 package corehttp
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
@@ -15,17 +23,23 @@ import (
 	version "github.com/ipfs/kubo"
 	core "github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/denylist"
 	repo "github.com/ipfs/kubo/repo"
 
+	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	syncds "github.com/ipfs/go-datastore/sync"
 	files "github.com/ipfs/go-ipfs-files"
+	ipns "github.com/ipfs/go-ipns"
 	path "github.com/ipfs/go-path"
 	iface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 	config "github.com/ipfs/kubo/config"
+	gocar "github.com/ipld/go-car"
 	ci "github.com/libp2p/go-libp2p/core/crypto"
+	peer "github.com/libp2p/go-libp2p/core/peer"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 )
 
@@ -602,6 +616,622 @@ func TestCacheControlImmutable(t *testing.T) {
 	}
 }
 
+func TestGatewayDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("blocked")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("allowed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+		"secret.txt": files.NewBytesFile([]byte("secret")),
+		"public.txt": files.NewBytesFile([]byte("public")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(dir.String())
+
+	f := filepath.Join(t.TempDir(), "denylist")
+	if err := os.WriteFile(f, []byte(blocked.String()+"\n"+dir.String()+"/secret.txt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if n.Denylist, err = denylist.New(f); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		host, path string
+		status     int
+	}{
+		{"", blocked.String(), http.StatusGone},
+		{"", allowed.String(), http.StatusOK},
+		{"", dir.String() + "/secret.txt", http.StatusGone},
+		// Paths blocked below a CID are blocked through names pointing to it
+		{"", "/ipns/example.net/secret.txt", http.StatusGone},
+		{"", "/ipns/example.net/public.txt", http.StatusOK},
+		{"example.net", "/secret.txt", http.StatusGone},
+		{"example.net", "/public.txt", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.host != "" {
+			req.Host = tc.host
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%s%s: expected status %d, got %d", tc.host, tc.path, tc.status, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayCodecs(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("linked")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := api.Block().Put(ctx, strings.NewReader(`{"hello":"world","link":{"/":"`+file.Cid().String()+`"},"nested":{"a":[1,2]}}`), options.Block.CidCodec("dag-json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "/ipfs/" + node.Path().Cid().String()
+	jsonFile, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte(`{"a":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawJSONFile, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte(`{"b":2}`)), options.Unixfs.RawLeaves(true), options.Unixfs.CidVersion(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const axiosAccept = "application/json, text/plain, */*"
+
+	for _, tc := range []struct {
+		path, accept string
+		status       int
+		contentType  string
+		body         string
+	}{
+		{root, "", http.StatusOK, "application/vnd.ipld.dag-json", `"hello":"world"`},
+		{root + "?format=dag-json", "", http.StatusOK, "application/vnd.ipld.dag-json", `"hello":"world"`},
+		{root, "application/vnd.ipld.dag-cbor", http.StatusOK, "application/vnd.ipld.dag-cbor", "hello"},
+		{root + "?format=json", "", http.StatusNotAcceptable, "", ""},
+		{root + "/nested", "application/json", http.StatusOK, "application/json", `"a"`},
+		{root + "/nested/a/1", "", http.StatusOK, "application/vnd.ipld.dag-json", "2"},
+		{root + "/missing", "", http.StatusNotFound, "", ""},
+		{root, "text/html,*/*", http.StatusOK, "text/html", file.Cid().String()},
+		{root + "/nested", axiosAccept, http.StatusOK, "application/json", `"a"`},
+		{root, "text/html, application/json", http.StatusOK, "text/html", file.Cid().String()},
+		{root, "text/html;q=0.9, application/json;q=0.9", http.StatusOK, "text/html", file.Cid().String()},
+		{root + "/nested", "application/json, text/html", http.StatusOK, "application/json", `"a"`},
+		{root + "/nested", "text/html;q=0.5, application/json", http.StatusOK, "application/json", `"a"`},
+		{root, "application/json;q=0.5, application/vnd.ipld.dag-cbor", http.StatusOK, "application/vnd.ipld.dag-cbor", "hello"},
+		{root, "application/vnd.ipld.dag-cbor;q=0, application/vnd.ipld.dag-json", http.StatusOK, "application/vnd.ipld.dag-json", `"hello":"world"`},
+		// JSON files stored as UnixFS are returned as is
+		{jsonFile.String(), "application/json", http.StatusOK, "application/json", `{"a":1}`},
+		{jsonFile.String(), axiosAccept, http.StatusOK, "application/json", `{"a":1}`},
+		{rawJSONFile.String(), "application/json", http.StatusOK, "application/json", `{"b":2}`},
+		{rawJSONFile.String(), "application/cbor", http.StatusOK, "application/json", `{"b":2}`},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Errorf("%s (Accept: %q): expected status %d, got %d: %s", tc.path, tc.accept, tc.status, res.StatusCode, body)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s (Accept: %q): expected Content-Type %q, got %q", tc.path, tc.accept, tc.contentType, ct)
+		}
+		if !strings.Contains(string(body), tc.body) {
+			t.Errorf("%s (Accept: %q): expected body containing %q, got %q", tc.path, tc.accept, tc.body, body)
+		}
+	}
+}
+
+func TestGatewayIpnsRecord(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte(emptyDir), 1, time.Now().Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Routing.PutValue(n.Context(), ipns.RecordKey(pid), record); err != nil {
+		t.Fatal(err)
+	}
+
+	blockedSk, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := peer.IDFromPrivateKey(blockedSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockedEntry, err := ipns.Create(blockedSk, []byte(emptyDir), 1, time.Now().Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockedRecord, err := blockedEntry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Routing.PutValue(n.Context(), ipns.RecordKey(blocked), blockedRecord); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "denylist")
+	if err := os.WriteFile(f, []byte("/ipns/"+blocked.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if n.Denylist, err = denylist.New(f); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missingSk, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := peer.IDFromPrivateKey(missingSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, accept string
+		status       int
+	}{
+		{"/ipns/" + blocked.String() + "?format=ipns-record", "", http.StatusGone},
+		{"/ipns/" + peer.ToCid(blocked).String() + "?format=ipns-record", "", http.StatusGone},
+		{"/ipns/" + missing.String() + "?format=ipns-record", "", http.StatusNotFound},
+		{"/ipns/" + pid.String() + "?format=ipns-record", "", http.StatusOK},
+		{"/ipns/" + peer.ToCid(pid).String(), "application/vnd.ipfs.ipns-record", http.StatusOK},
+		{"/ipns/" + pid.String() + "/sub?format=ipns-record", "", http.StatusBadRequest},
+		{"/ipns/example.com?format=ipns-record", "", http.StatusBadRequest},
+		{emptyDir + "?format=ipns-record", "", http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.path, tc.status, res.StatusCode, body)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipfs.ipns-record" {
+			t.Errorf("%s: unexpected Content-Type %q", tc.path, ct)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != "public, max-age=300" {
+			t.Errorf("%s: expected the TTL as max-age, got Cache-Control %q", tc.path, cc)
+		}
+		if string(body) != string(record) {
+			t.Errorf("%s: expected the signed record", tc.path)
+		}
+	}
+}
+
+func TestGatewayCarParams(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	// 10 chunks of 100 bytes, the last 3 with the same content
+	content := make([]byte, 1000)
+	for i := range content[:700] {
+		content[i] = byte(i/100 + 1)
+	}
+	dir, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"file": files.NewBytesFile(content),
+	}), options.Unixfs.Chunker("size-100"), options.Unixfs.RawLeaves(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := api.ResolvePath(ctx, ipath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, err := api.Dag().Get(ctx, file.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []string
+	for _, l := range fileNode.Links() {
+		chunks = append(chunks, l.Cid.String())
+	}
+	if len(chunks) != 10 || chunks[7] != chunks[8] || chunks[7] != chunks[9] {
+		t.Fatalf("unexpected file layout: %v", chunks)
+	}
+	dirCid, fileCid := dir.Cid().String(), file.Cid().String()
+
+	getCar := func(query, accept string) (*http.Response, []string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+dir.String()+"/file?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return res, nil
+		}
+		cr, err := gocar.NewCarReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cr.Header.Roots) != 1 || cr.Header.Roots[0].String() != fileCid {
+			t.Errorf("%s: unexpected roots %v", query, cr.Header.Roots)
+		}
+		var got []string
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, blk.Cid().String())
+		}
+		return res, got
+	}
+
+	for _, tc := range []struct {
+		query, accept string
+		blocks        []string
+	}{
+		{"format=car", "", append([]string{dirCid, fileCid}, chunks[:8]...)},
+		{"format=car&dag-scope=block", "", []string{dirCid, fileCid}},
+		{"format=car&dag-scope=entity", "", append([]string{dirCid, fileCid}, chunks[:8]...)},
+		{"format=car&entity-bytes=0:99", "", []string{dirCid, fileCid, chunks[0]}},
+		{"format=car&entity-bytes=150:250", "", []string{dirCid, fileCid, chunks[1], chunks[2]}},
+		{"format=car&entity-bytes=-150:*", "", []string{dirCid, fileCid, chunks[8]}},
+		{"format=car&entity-bytes=950:5000&dag-scope=entity", "", []string{dirCid, fileCid, chunks[9]}},
+		{"format=car&entity-bytes=2000:*", "", []string{dirCid, fileCid}},
+		{"format=car&car-dups=y", "", append([]string{dirCid, fileCid}, chunks...)},
+		{"", "application/vnd.ipld.car; version=1; order=dfs; dups=y", append([]string{dirCid, fileCid}, chunks...)},
+	} {
+		res, got := getCar(tc.query, tc.accept)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s (Accept: %q): expected status 200, got %d", tc.query, tc.accept, res.StatusCode)
+			continue
+		}
+		if strings.Join(got, ",") != strings.Join(tc.blocks, ",") {
+			t.Errorf("%s (Accept: %q): expected blocks\n%v\ngot\n%v", tc.query, tc.accept, tc.blocks, got)
+		}
+	}
+
+	for _, query := range []string{"format=car&dag-scope=children", "format=car&entity-bytes=5", "format=car&entity-bytes=10:5", "format=car&car-dups=maybe", "format=car&car-order=bfs"} {
+		if res, _ := getCar(query, ""); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, res.StatusCode)
+		}
+	}
+
+	res, _ := getCar("format=car&dag-scope=entity", "")
+	etag := res.Header.Get("Etag")
+	if res, _ := getCar("format=car", ""); res.Header.Get("Etag") == etag {
+		t.Errorf("expected different Etags for different scopes, got %s", etag)
+	}
+}
+
+// countingBlockAPI counts the blocks fetched through it.
+type countingBlockAPI struct {
+	iface.BlockAPI
+	gets int
+}
+
+func (b *countingBlockAPI) Get(ctx context.Context, p ipath.Path) (io.Reader, error) {
+	b.gets++
+	return b.BlockAPI.Get(ctx, p)
+}
+
+type countingNodeAPI struct {
+	NodeAPI
+	blocks *countingBlockAPI
+}
+
+func (a countingNodeAPI) Block() iface.BlockAPI {
+	return a.blocks
+}
+
+func TestGatewayCarSharedBlocks(t *testing.T) {
+	_, api, ctx := newTestServerAndNode(t, nil)
+
+	// 3 identical subtrees of 174 identical chunks each
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile(make([]byte, 3*174*100)), options.Unixfs.Chunker("size-100"), options.Unixfs.RawLeaves(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		eb   *entityBytes
+	}{
+		{"all", nil},
+		{"range", &entityBytes{from: 50}},
+	} {
+		cb := &countingBlockAPI{BlockAPI: api.Block()}
+		var buf bytes.Buffer
+		cw := &carWriter{ctx: ctx, api: countingNodeAPI{NodeAPI: api, blocks: cb}, w: &buf, seen: make(map[cid.Cid]struct{}), walked: make(map[cid.Cid]struct{})}
+		if err := cw.writeEntity(file.Cid(), dagScopeAll, tc.eb); err != nil {
+			t.Fatal(err)
+		}
+		if len(cw.seen) != 3 {
+			t.Fatalf("%s: expected 3 unique blocks, got %d", tc.name, len(cw.seen))
+		}
+		// the root, and the subtree and the chunk at most twice each: once
+		// partly in the range, once whole
+		if cb.gets > 5 {
+			t.Errorf("%s: expected shared blocks to be fetched once, got %d fetches", tc.name, cb.gets)
+		}
+	}
+}
+
+func TestGatewayZip(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	dir, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"hello.txt": files.NewBytesFile([]byte("hello")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"ünïcödé.txt": files.NewBytesFile([]byte("world")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := dir.Cid().String()
+
+	for _, tc := range []struct {
+		query, accept string
+	}{
+		{"format=zip", ""},
+		{"", "application/zip"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+dir.String()+"?"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d: %s", tc.query, res.StatusCode, body)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+			t.Errorf("%q: unexpected Content-Type %q", tc.query, ct)
+		}
+		if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, root+".zip") {
+			t.Errorf("%q: unexpected Content-Disposition %q", tc.query, cd)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, f := range zr.File {
+			if f.NonUTF8 {
+				t.Errorf("%s: name not marked as UTF-8", f.Name)
+			}
+			if f.FileInfo().IsDir() {
+				got[f.Name] = ""
+				continue
+			}
+			fr, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(fr)
+			fr.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[f.Name] = string(data)
+		}
+		expected := map[string]string{
+			root + "/":                "",
+			root + "/hello.txt":       "hello",
+			root + "/sub/":            "",
+			root + "/sub/ünïcödé.txt": "world",
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: unexpected archive content %v", tc.query, got)
+		}
+	}
+}
+
+func TestGatewayDirJSON(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	dir, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("aaa")),
+		"b":     files.NewMapDirectory(map[string]files.Node{"c.txt": files.NewBytesFile([]byte("c"))}),
+		"d.txt": files.NewBytesFile([]byte("ddddd")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getPage := func(query, accept string) (*http.Response, dirJSONListing) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+dir.String()+"/?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var listing dirJSONListing
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&listing); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, listing
+	}
+
+	res, listing := getPage("", "application/json")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if listing.Cid != dir.Cid().String() || listing.NextCursor != "" {
+		t.Errorf("unexpected listing %+v", listing)
+	}
+	var got []string
+	for _, e := range listing.Entries {
+		got = append(got, fmt.Sprintf("%s %s %d", e.Name, e.Type, e.Size))
+	}
+	if len(got) != 3 || got[0] != "a.txt file 3" || !strings.HasPrefix(got[1], "b directory ") || got[2] != "d.txt file 5" {
+		t.Errorf("unexpected entries %v", got)
+	}
+
+	// Paging
+	var names []string
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		res, listing := getPage("format=dir-json&limit=2&cursor="+url.QueryEscape(cursor), "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.StatusCode)
+		}
+		if len(listing.Entries) > 2 {
+			t.Fatalf("page too long: %v", listing.Entries)
+		}
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		if cursor = listing.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "a.txt,b,d.txt" {
+		t.Errorf("unexpected paged entries %v", names)
+	}
+
+	for _, query := range []string{"format=dir-json&limit=0", "format=dir-json&cursor=missing"} {
+		if res, _ := getPage(query, ""); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, res.StatusCode)
+		}
+	}
+
+	// Files are returned as is.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+dir.String()+"/a.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err = doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "aaa" {
+		t.Errorf("expected the file, got %d: %q", res.StatusCode, body)
+	}
+}
+
 func TestGoGetSupport(t *testing.T) {
 	ts, _, _ := newTestServerAndNode(t, nil)
 	t.Logf("test server url: %s", ts.URL)
@@ -681,3 +1311,56 @@ func TestEtagMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestGatewayCacheIPNS(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	addDir := func(content string) ipath.Resolved {
+		t.Helper()
+		p, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+			"sub": files.NewMapDirectory(map[string]files.Node{
+				"file.txt": files.NewBytesFile([]byte(content)),
+			}),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	get := func(p string) string {
+		t.Helper()
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", p, res.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	v1 := addDir("version 1")
+	ns["/ipns/example.net"] = path.FromString(v1.String())
+	for i := 0; i < 2; i++ {
+		if body := get("/ipns/example.net/sub/file.txt"); body != "version 1" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+
+	// Resolutions of names are not cached by the gateway, only the
+	// immutable paths they point to.
+	v2 := addDir("version 2")
+	ns["/ipns/example.net"] = path.FromString(v2.String())
+	if body := get("/ipns/example.net/sub/file.txt"); body != "version 2" {
+		t.Fatalf("expected the new version of the name to be served, got %q", body)
+	}
+	if body := get(v1.String() + "/sub/file.txt"); body != "version 1" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
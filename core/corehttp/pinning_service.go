@@ -0,0 +1,732 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-pinning-service-http-client/openapi"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	path "github.com/ipfs/interface-go-ipfs-core/path"
+	core "github.com/ipfs/kubo/core"
+	coreapi "github.com/ipfs/kubo/core/coreapi"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PinningServicePath is the path at which the Pinning Service API is mounted.
+const PinningServicePath = "/pins"
+
+// pinningServiceRequestsKey is the datastore namespace under which the pin
+// requests received by the Pinning Service API are kept.
+var pinningServiceRequestsKey = ds.NewKey("/local/pinning-service/requests")
+
+const (
+	// Limits from the Pinning Service API spec.
+	pinningServiceDefaultLimit = 10
+	pinningServiceMaxLimit     = 1000
+	pinningServiceMaxCids      = 10
+	pinningServiceMaxDelegates = 20
+	pinningServiceMaxNameSize  = 255
+
+	// pinningServiceWorkers is the number of pin requests fetched at once.
+	pinningServiceWorkers = 4
+)
+
+// PinningServiceOption serves the IPFS Pinning Service API
+// (https://ipfs.github.io/pinning-services-api-spec/) under
+// PinningServicePath, backed by the node's own pinner. Clients authenticate
+// with one of the bearer tokens in Pinning.Service.AccessTokens.
+//
+// The pin requests are kept in the repo, and the pins they create are regular
+// recursive pins. Deleting a request only removes the pin if it was created
+// for that request, and no other request is using it.
+//
+// The same option can be used for several listeners, they share a single
+// queue of pin requests.
+func PinningServiceOption() ServeOption {
+	var (
+		once sync.Once
+		svc  *pinningService
+		err  error
+	)
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		once.Do(func() {
+			svc, err = newPinningService(n)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		mux.Handle(PinningServicePath, svc)
+		mux.Handle(PinningServicePath+"/", svc)
+		return mux, nil
+	}
+}
+
+// pinRequest is a pin request as kept in the datastore.
+type pinRequest struct {
+	ID      string
+	Status  openapi.Status
+	Created time.Time
+	Pin     openapi.Pin
+	Info    map[string]string `json:",omitempty"`
+
+	// Owned is set when the pin was created for this request, and has to be
+	// removed with it.
+	Owned bool `json:",omitempty"`
+
+	// Release is the cid of an owned pin this request replaced, to be
+	// released once this request is processed.
+	Release string `json:",omitempty"`
+}
+
+func (r *pinRequest) cid() cid.Cid {
+	c, _ := cid.Decode(r.Pin.Cid)
+	return c
+}
+
+// active returns true unless pinning has failed.
+func (r *pinRequest) active() bool {
+	return r.Status != openapi.FAILED
+}
+
+type pinningService struct {
+	node   *core.IpfsNode
+	api    coreiface.CoreAPI
+	ds     ds.Datastore
+	tokens []string
+
+	// mu protects the pin requests in ds, and cancels.
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+
+	workers chan struct{}
+}
+
+func newPinningService(n *core.IpfsNode) (*pinningService, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Pinning.Service.AccessTokens) == 0 {
+		return nil, errors.New("the pinning service requires at least one token in Pinning.Service.AccessTokens")
+	}
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pinningService{
+		node:    n,
+		api:     api,
+		ds:      namespace.Wrap(n.Repo.Datastore(), pinningServiceRequestsKey),
+		tokens:  cfg.Pinning.Service.AccessTokens,
+		cancels: make(map[string]context.CancelFunc),
+		workers: make(chan struct{}, pinningServiceWorkers),
+	}
+
+	// Resume the requests interrupted by a restart.
+	reqs, err := s.requests(n.Context())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reqs {
+		if r.Status == openapi.QUEUED || r.Status == openapi.PINNING {
+			go s.process(r.ID)
+		}
+	}
+	return s, nil
+}
+
+func (s *pinningService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writePinningFailure(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid or missing access token")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, PinningServicePath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		s.add(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		s.get(w, r, id)
+	case id != "" && r.Method == http.MethodPost:
+		s.replace(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		s.remove(w, r, id)
+	default:
+		writePinningFailure(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method+" is not supported on "+r.URL.Path)
+	}
+}
+
+func (s *pinningService) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == "" {
+		return false
+	}
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *pinningService) list(w http.ResponseWriter, r *http.Request) {
+	f, err := parsePinFilter(r)
+	if err != nil {
+		writePinningFailure(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	reqs, err := s.requests(r.Context())
+	if err != nil {
+		writePinningFailure(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err.Error())
+		return
+	}
+
+	// Most recent first, as the spec pages backwards with "before".
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Created.After(reqs[j].Created)
+	})
+
+	results := make([]openapi.PinStatus, 0, f.limit)
+	count := 0
+	for _, req := range reqs {
+		if !f.match(req) {
+			continue
+		}
+		count++
+		if len(results) < f.limit {
+			results = append(results, s.status(req))
+		}
+	}
+
+	writePinningJSON(w, http.StatusOK, openapi.NewPinResults(int32(count), results))
+}
+
+func (s *pinningService) add(w http.ResponseWriter, r *http.Request) {
+	p, err := decodePin(r)
+	if err != nil {
+		writePinningFailure(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	req, err := s.create(r.Context(), p, "")
+	if err != nil {
+		writePinningFailure(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err.Error())
+		return
+	}
+	writePinningJSON(w, http.StatusAccepted, s.status(req))
+}
+
+func (s *pinningService) get(w http.ResponseWriter, r *http.Request, id string) {
+	req, err := s.request(r.Context(), id)
+	if err != nil {
+		writePinningError(w, err)
+		return
+	}
+	writePinningJSON(w, http.StatusOK, s.status(req))
+}
+
+// replace removes a pin request and creates a new one in its place. The old
+// pin is only released once the new one is processed, so that the blocks
+// both pins have in common are not fetched again.
+func (s *pinningService) replace(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := decodePin(r)
+	if err != nil {
+		writePinningFailure(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	s.mu.Lock()
+	old, err := s.request(ctx, id)
+	if err == nil {
+		err = s.deleteLocked(ctx, old)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writePinningError(w, err)
+		return
+	}
+
+	var release string
+	if old.Owned {
+		release = old.Pin.Cid
+	}
+	req, err := s.create(ctx, p, release)
+	if err != nil {
+		writePinningFailure(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err.Error())
+		return
+	}
+	writePinningJSON(w, http.StatusAccepted, s.status(req))
+}
+
+func (s *pinningService) remove(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	s.mu.Lock()
+	req, err := s.request(ctx, id)
+	if err == nil {
+		err = s.deleteLocked(ctx, req)
+	}
+	if err == nil && req.Status == openapi.PINNED && req.Owned {
+		err = s.releaseLocked(ctx, req.cid())
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writePinningError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// create stores a new queued pin request, and starts processing it.
+func (s *pinningService) create(ctx context.Context, p openapi.Pin, release string) (*pinRequest, error) {
+	req := &pinRequest{
+		ID:      uuid.New().String(),
+		Status:  openapi.QUEUED,
+		Created: time.Now().UTC(),
+		Pin:     p,
+		Release: release,
+	}
+
+	s.mu.Lock()
+	err := s.put(ctx, req)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	go s.process(req.ID)
+	return req, nil
+}
+
+// process pins the content of a pin request, waiting for a free worker.
+func (s *pinningService) process(id string) {
+	ctx, cancel := context.WithCancel(s.node.Context())
+	defer cancel()
+
+	select {
+	case s.workers <- struct{}{}:
+		defer func() { <-s.workers }()
+	case <-ctx.Done():
+		return
+	}
+
+	s.mu.Lock()
+	req, err := s.request(ctx, id)
+	if err != nil {
+		// Removed while queued.
+		s.mu.Unlock()
+		return
+	}
+	c := req.cid()
+	owned := req.Owned
+	req.Status = openapi.PINNING
+	if err := s.put(ctx, req); err != nil {
+		log.Errorf("pinning service: updating request %s: %s", id, err)
+	}
+	s.cancels[id] = cancel
+	s.mu.Unlock()
+
+	s.connectOrigins(ctx, req.Pin.GetOrigins())
+	created, pinErr := s.pin(ctx, c)
+	owned = owned || created
+
+	// ctx is canceled when the request is removed meanwhile.
+	bg := s.node.Context()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cancels, id)
+
+	req, err = s.request(bg, id)
+	if err != nil {
+		// Removed while pinning, the pin may have been created meanwhile.
+		if pinErr == nil && owned {
+			if err := s.releaseLocked(bg, c); err != nil {
+				log.Errorf("pinning service: releasing %s: %s", c, err)
+			}
+		}
+		return
+	}
+
+	if pinErr != nil {
+		req.Status = openapi.FAILED
+		req.Owned = false
+		req.Info = map[string]string{"status_details": pinErr.Error()}
+	} else {
+		req.Status = openapi.PINNED
+		req.Owned = req.Owned || owned
+	}
+	release := req.Release
+	req.Release = ""
+	if err := s.put(bg, req); err != nil {
+		log.Errorf("pinning service: updating request %s: %s", id, err)
+	}
+
+	// After updating req, which may take over the released pin.
+	if rc, err := cid.Decode(release); err == nil {
+		if err := s.releaseLocked(bg, rc); err != nil {
+			log.Errorf("pinning service: releasing %s: %s", rc, err)
+		}
+	}
+}
+
+// pin pins c recursively, and returns whether the pin was created rather than
+// made by the local user already. The pin lock is held from the check to the
+// pin, so that a pin the check missed is not taken over by the request.
+func (s *pinningService) pin(ctx context.Context, c cid.Cid) (bool, error) {
+	defer s.node.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	_, pinned, err := s.node.Pinning.IsPinnedWithType(ctx, c, pin.Recursive)
+	if err != nil {
+		return false, fmt.Errorf("checking pin of %s: %w", c, err)
+	}
+	nd, err := s.api.ResolveNode(ctx, path.IpfsPath(c))
+	if err != nil {
+		return false, err
+	}
+	if err := s.node.Pinning.Pin(ctx, nd, true); err != nil {
+		return false, err
+	}
+	if err := s.node.Provider.Provide(c); err != nil {
+		return false, err
+	}
+	return !pinned, s.node.Pinning.Flush(ctx)
+}
+
+// connectOrigins connects to the peers given as origins of a pin, to speed
+// up fetching it.
+func (s *pinningService) connectOrigins(ctx context.Context, origins []string) {
+	if !s.node.IsOnline {
+		return
+	}
+	for _, o := range origins {
+		addr, err := ma.NewMultiaddr(o)
+		if err != nil {
+			continue
+		}
+		pi, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			continue
+		}
+		go func() {
+			if err := s.api.Swarm().Connect(ctx, *pi); err != nil {
+				log.Debugf("pinning service: connecting to origin %s: %s", pi.ID, err)
+			}
+		}()
+	}
+}
+
+// deleteLocked removes a pin request, stopping its processing. The pin it
+// was to release once processed is released right away.
+func (s *pinningService) deleteLocked(ctx context.Context, req *pinRequest) error {
+	if cancel, ok := s.cancels[req.ID]; ok {
+		cancel()
+	}
+	if err := s.ds.Delete(ctx, ds.NewKey(req.ID)); err != nil {
+		return err
+	}
+	if rc, err := cid.Decode(req.Release); err == nil {
+		return s.releaseLocked(ctx, rc)
+	}
+	return nil
+}
+
+// releaseLocked removes the pin on c, unless another request uses it. The
+// pin is then handed over to that request.
+func (s *pinningService) releaseLocked(ctx context.Context, c cid.Cid) error {
+	reqs, err := s.requests(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range reqs {
+		if r.active() && r.cid().Equals(c) {
+			r.Owned = true
+			return s.put(ctx, r)
+		}
+	}
+
+	err = s.api.Pin().Rm(ctx, path.IpfsPath(c))
+	if errors.Is(err, pin.ErrNotPinned) {
+		return nil
+	}
+	return err
+}
+
+func (s *pinningService) request(ctx context.Context, id string) (*pinRequest, error) {
+	b, err := s.ds.Get(ctx, ds.NewKey(id))
+	if err != nil {
+		return nil, err
+	}
+	req := new(pinRequest)
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *pinningService) requests(ctx context.Context) ([]*pinRequest, error) {
+	res, err := s.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var reqs []*pinRequest
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		req := new(pinRequest)
+		if err := json.Unmarshal(r.Value, req); err != nil {
+			log.Errorf("pinning service: skipping invalid request %s: %s", r.Key, err)
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+func (s *pinningService) put(ctx context.Context, req *pinRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	key := ds.NewKey(req.ID)
+	if err := s.ds.Put(ctx, key, b); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, key)
+}
+
+// status converts a pin request to its API representation.
+func (s *pinningService) status(req *pinRequest) openapi.PinStatus {
+	st := openapi.NewPinStatus(req.ID, req.Status, req.Created, req.Pin, s.delegates())
+	if len(req.Info) > 0 {
+		st.SetInfo(req.Info)
+	}
+	return *st
+}
+
+// delegates returns the addresses clients can connect to to transfer data to
+// the node.
+func (s *pinningService) delegates() []string {
+	delegates := []string{}
+	if s.node.PeerHost == nil {
+		return delegates
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+		ID:    s.node.Identity,
+		Addrs: s.node.PeerHost.Addrs(),
+	})
+	if err != nil {
+		return delegates
+	}
+	for _, a := range addrs {
+		if len(delegates) == pinningServiceMaxDelegates {
+			break
+		}
+		delegates = append(delegates, a.String())
+	}
+	return delegates
+}
+
+// pinFilter holds the query parameters of GET /pins.
+type pinFilter struct {
+	cids          []cid.Cid
+	name          string
+	nameMatch     string
+	statuses      map[openapi.Status]bool
+	before, after *time.Time
+	limit         int
+	meta          map[string]string
+}
+
+func parsePinFilter(r *http.Request) (*pinFilter, error) {
+	q := r.URL.Query()
+	f := &pinFilter{
+		name:      q.Get("name"),
+		nameMatch: q.Get("match"),
+		statuses:  map[openapi.Status]bool{openapi.PINNED: true},
+		limit:     pinningServiceDefaultLimit,
+	}
+
+	if v := q.Get("cid"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cid %q: %w", s, err)
+			}
+			f.cids = append(f.cids, c)
+		}
+		if len(f.cids) > pinningServiceMaxCids {
+			return nil, fmt.Errorf("at most %d cids can be given", pinningServiceMaxCids)
+		}
+	}
+
+	switch f.nameMatch {
+	case "":
+		f.nameMatch = "exact"
+	case "exact", "iexact", "partial", "ipartial":
+	default:
+		return nil, fmt.Errorf("invalid match %q, must be one of {exact, iexact, partial, ipartial}", f.nameMatch)
+	}
+
+	if v := q.Get("status"); v != "" {
+		f.statuses = make(map[openapi.Status]bool)
+		for _, s := range strings.Split(v, ",") {
+			switch st := openapi.Status(s); st {
+			case openapi.QUEUED, openapi.PINNING, openapi.PINNED, openapi.FAILED:
+				f.statuses[st] = true
+			default:
+				return nil, fmt.Errorf("invalid status %q", s)
+			}
+		}
+	}
+
+	for name, t := range map[string]**time.Time{"before": &f.before, "after": &f.after} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*t = &parsed
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > pinningServiceMaxLimit {
+			return nil, fmt.Errorf("invalid limit %q, must be between 1 and %d", v, pinningServiceMaxLimit)
+		}
+		f.limit = limit
+	}
+
+	if v := q.Get("meta"); v != "" {
+		if err := json.Unmarshal([]byte(v), &f.meta); err != nil {
+			return nil, fmt.Errorf("invalid meta: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+func (f *pinFilter) match(req *pinRequest) bool {
+	if !f.statuses[req.Status] {
+		return false
+	}
+	if f.before != nil && !req.Created.Before(*f.before) {
+		return false
+	}
+	if f.after != nil && !req.Created.After(*f.after) {
+		return false
+	}
+	if len(f.cids) > 0 {
+		c := req.cid()
+		found := false
+		for _, fc := range f.cids {
+			if fc.Equals(c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.name != "" {
+		name := req.Pin.GetName()
+		switch f.nameMatch {
+		case "exact":
+			if name != f.name {
+				return false
+			}
+		case "iexact":
+			if !strings.EqualFold(name, f.name) {
+				return false
+			}
+		case "partial":
+			if !strings.Contains(name, f.name) {
+				return false
+			}
+		case "ipartial":
+			if !strings.Contains(strings.ToLower(name), strings.ToLower(f.name)) {
+				return false
+			}
+		}
+	}
+	meta := req.Pin.GetMeta()
+	for k, v := range f.meta {
+		if mv, ok := meta[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// decodePin reads and validates the Pin object in the body of r.
+func decodePin(r *http.Request) (openapi.Pin, error) {
+	var p openapi.Pin
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return p, fmt.Errorf("invalid pin object: %w", err)
+	}
+	c, err := cid.Decode(p.Cid)
+	if err != nil {
+		return p, fmt.Errorf("invalid cid %q: %w", p.Cid, err)
+	}
+	p.Cid = c.String()
+	if len(p.GetName()) > pinningServiceMaxNameSize {
+		return p, fmt.Errorf("name cannot be longer than %d", pinningServiceMaxNameSize)
+	}
+	return p, nil
+}
+
+func writePinningJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("pinning service: writing response: %s", err)
+	}
+}
+
+func writePinningFailure(w http.ResponseWriter, status int, reason, details string) {
+	fe := openapi.NewFailureError(reason)
+	if details != "" {
+		fe.SetDetails(details)
+	}
+	writePinningJSON(w, status, openapi.NewFailure(*fe))
+}
+
+func writePinningError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ds.ErrNotFound) {
+		writePinningFailure(w, http.StatusNotFound, "NOT_FOUND", "the specified pin request does not exist")
+		return
+	}
+	writePinningFailure(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", err.Error())
+}
@@ -0,0 +1,241 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	files "github.com/ipfs/go-ipfs-files"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/coreapi"
+	repo "github.com/ipfs/kubo/repo"
+)
+
+const testPinningServiceToken = "secret"
+
+func newPinningServiceTestServer(t *testing.T) (*httptest.Server, iface.CoreAPI) {
+	ts, n := newPinningServiceTestNode(t)
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, api
+}
+
+func newPinningServiceTestNode(t *testing.T) (*httptest.Server, *core.IpfsNode) {
+	c := config.Config{
+		Identity: config.Identity{
+			PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+		},
+	}
+	c.Pinning.Service.AccessTokens = []string{testPinningServiceToken}
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+
+	dh.Handler, err = makeHandler(n, ts.Listener, PinningServiceOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, n
+}
+
+func addTestFile(t *testing.T, api iface.CoreAPI, data string) cid.Cid {
+	p, err := api.Unixfs().Add(context.Background(), files.NewBytesFile([]byte(data)), options.Unixfs.Pin(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Cid()
+}
+
+func waitForPinStatus(t *testing.T, client *pinclient.Client, id string, want pinclient.Status) pinclient.PinStatusGetter {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		ps, err := client.GetStatusByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps.GetStatus() == want {
+			return ps
+		}
+		if ps.GetStatus() == pinclient.StatusFailed {
+			t.Fatalf("pin request %s failed: %v", id, ps.GetInfo())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pin request %s did not reach status %s", id, want)
+	return nil
+}
+
+func isRecursivelyPinned(t *testing.T, api iface.CoreAPI, c cid.Cid) bool {
+	_, pinned, err := api.Pin().IsPinned(context.Background(), ipath.IpfsPath(c), options.Pin.IsPinned.Recursive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pinned
+}
+
+func TestPinningService(t *testing.T) {
+	ctx := context.Background()
+	ts, api := newPinningServiceTestServer(t)
+	client := pinclient.NewClient(ts.URL, testPinningServiceToken)
+
+	first := addTestFile(t, api, "first")
+	second := addTestFile(t, api, "second")
+
+	ps, err := client.Add(ctx, first, pinclient.PinOpts.WithName("site"), pinclient.PinOpts.AddMeta(map[string]string{"app": "test"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.GetPin().GetName() != "site" {
+		t.Fatalf("expected the pin name to be kept, got %q", ps.GetPin().GetName())
+	}
+	waitForPinStatus(t, client, ps.GetRequestId(), pinclient.StatusPinned)
+	if !isRecursivelyPinned(t, api, first) {
+		t.Fatal("expected the pin request to pin the content")
+	}
+
+	for _, tc := range []struct {
+		opts  []pinclient.LsOption
+		count int
+	}{
+		{nil, 1},
+		{[]pinclient.LsOption{pinclient.PinOpts.FilterName("site")}, 1},
+		{[]pinclient.LsOption{pinclient.PinOpts.FilterName("other")}, 0},
+		{[]pinclient.LsOption{pinclient.PinOpts.FilterCIDs(first)}, 1},
+		{[]pinclient.LsOption{pinclient.PinOpts.FilterCIDs(second)}, 0},
+		{[]pinclient.LsOption{pinclient.PinOpts.FilterStatus(pinclient.StatusQueued)}, 0},
+	} {
+		res, err := client.LsSync(ctx, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != tc.count {
+			t.Errorf("expected %d results, got %d", tc.count, len(res))
+		}
+	}
+
+	// Replacing moves the pin over to the new content.
+	replaced, err := client.Replace(ctx, ps.GetRequestId(), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.GetRequestId() == ps.GetRequestId() {
+		t.Fatal("expected a new request id for the replacement")
+	}
+	waitForPinStatus(t, client, replaced.GetRequestId(), pinclient.StatusPinned)
+	if !isRecursivelyPinned(t, api, second) {
+		t.Fatal("expected the replacement to be pinned")
+	}
+	if isRecursivelyPinned(t, api, first) {
+		t.Fatal("expected the replaced pin to be removed")
+	}
+	if _, err := client.GetStatusByID(ctx, ps.GetRequestId()); err == nil || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Fatalf("expected the replaced request to be gone, got %v", err)
+	}
+
+	if err := client.DeleteByID(ctx, replaced.GetRequestId()); err != nil {
+		t.Fatal(err)
+	}
+	if isRecursivelyPinned(t, api, second) {
+		t.Fatal("expected deleting the request to remove the pin")
+	}
+}
+
+func TestPinningServiceKeepsExistingPins(t *testing.T) {
+	ctx := context.Background()
+	ts, api := newPinningServiceTestServer(t)
+	client := pinclient.NewClient(ts.URL, testPinningServiceToken)
+
+	c := addTestFile(t, api, "pinned locally")
+	if err := api.Pin().Add(ctx, ipath.IpfsPath(c)); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := client.Add(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForPinStatus(t, client, ps.GetRequestId(), pinclient.StatusPinned)
+
+	if err := client.DeleteByID(ctx, ps.GetRequestId()); err != nil {
+		t.Fatal(err)
+	}
+	if !isRecursivelyPinned(t, api, c) {
+		t.Fatal("expected the pin made outside of the pinning service to be kept")
+	}
+}
+
+// failingPinChecker is a pinner which cannot tell what is pinned.
+type failingPinChecker struct {
+	pin.Pinner
+}
+
+func (failingPinChecker) IsPinnedWithType(ctx context.Context, c cid.Cid, mode pin.Mode) (string, bool, error) {
+	return "", false, errors.New("pins unavailable")
+}
+
+func TestPinningServiceFailsWhenPinsAreUnknown(t *testing.T) {
+	ctx := context.Background()
+	ts, n := newPinningServiceTestNode(t)
+	client := pinclient.NewClient(ts.URL, testPinningServiceToken)
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := addTestFile(t, api, "pinned locally")
+	if err := api.Pin().Add(ctx, ipath.IpfsPath(c)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not knowing whether the content is pinned already, the request must
+	// not claim the pin, or deleting the request would remove it.
+	pinner := n.Pinning
+	n.Pinning = failingPinChecker{pinner}
+	ps, err := client.Add(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForPinStatus(t, client, ps.GetRequestId(), pinclient.StatusFailed)
+	n.Pinning = pinner
+
+	if err := client.DeleteByID(ctx, ps.GetRequestId()); err != nil {
+		t.Fatal(err)
+	}
+	if !isRecursivelyPinned(t, api, c) {
+		t.Fatal("expected the pin made outside of the pinning service to be kept")
+	}
+}
+
+func TestPinningServiceUnauthorized(t *testing.T) {
+	ts, _ := newPinningServiceTestServer(t)
+	client := pinclient.NewClient(ts.URL, "wrong")
+
+	_, err := client.LsSync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "UNAUTHORIZED") {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	core "github.com/ipfs/kubo/core"
+	coreapi "github.com/ipfs/kubo/core/coreapi"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mbase "github.com/multiformats/go-multibase"
+)
+
+// PubSubPath is the path prefix of the pubsub bridge. Topics are appended to
+// it multibase encoded, like in the RPC API.
+const PubSubPath = "/pubsub/"
+
+// pubsubKeepAlive is the interval of the comments sent to subscribers, so
+// idle streams are not closed by proxies.
+const pubsubKeepAlive = 30 * time.Second
+
+// PubSubOption bridges HTTP clients to the pubsub topics allowed in
+// Gateway.PubSub: GET requests subscribe to a topic with Server-Sent Events,
+// and POST requests publish their body to it.
+func PubSubOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.Gateway.PubSub.Enabled() {
+			return nil, errors.New("the pubsub bridge requires topics in Gateway.PubSub.SubscribeTopics or Gateway.PubSub.PublishTopics")
+		}
+		if n.PubSub == nil {
+			return nil, errors.New("the pubsub bridge requires pubsub to be enabled with Pubsub.Enabled")
+		}
+
+		api, err := coreapi.NewCoreAPI(n)
+		if err != nil {
+			return nil, err
+		}
+
+		headers := make(map[string][]string, len(cfg.Gateway.HTTPHeaders))
+		for h, v := range cfg.Gateway.HTTPHeaders {
+			headers[http.CanonicalHeaderKey(h)] = v
+		}
+		AddAccessControlHeaders(headers)
+		headers["Access-Control-Allow-Methods"] = []string{http.MethodGet, http.MethodPost, http.MethodOptions}
+
+		// The bridge is subject to the rate limits of the gateway, with its
+		// own buckets.
+		limiter, err := newGatewayLimiter(cfg.Gateway.RateLimits)
+		if err != nil {
+			return nil, fmt.Errorf("Gateway.RateLimits: %w", err)
+		}
+
+		mux.Handle(PubSubPath, &pubsubHandler{
+			api:             api.PubSub(),
+			limiter:         limiter,
+			headers:         headers,
+			subscribeTopics: cfg.Gateway.PubSub.SubscribeTopics,
+			publishTopics:   cfg.Gateway.PubSub.PublishTopics,
+		})
+		return mux, nil
+	}
+}
+
+type pubsubHandler struct {
+	api             coreiface.PubSubAPI
+	limiter         *gatewayLimiter
+	headers         map[string][]string
+	subscribeTopics []string
+	publishTopics   []string
+}
+
+// pubsubEvent is the data of the events sent to subscribers, encoded like
+// the messages of 'ipfs pubsub sub'.
+type pubsubEvent struct {
+	From     string   `json:"from,omitempty"`
+	Data     string   `json:"data,omitempty"`
+	Seqno    string   `json:"seqno,omitempty"`
+	TopicIDs []string `json:"topicIDs,omitempty"`
+}
+
+func (h *pubsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for k, v := range h.headers {
+		w.Header()[k] = v
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	// Subscriptions hold a slot of MaxConcurrentRequests while they last.
+	release, ok := h.limiter.admit(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	topic, err := decodePubSubTopic(strings.TrimPrefix(r.URL.Path, PubSubPath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !topicAllowed(h.subscribeTopics, topic) {
+			http.Error(w, "subscribing to this topic is not allowed", http.StatusForbidden)
+			return
+		}
+		if !h.limiter.admitExpensive(w, r) {
+			return
+		}
+		h.subscribe(w, r, topic)
+	case http.MethodPost:
+		if !topicAllowed(h.publishTopics, topic) {
+			http.Error(w, "publishing to this topic is not allowed", http.StatusForbidden)
+			return
+		}
+		h.publish(w, r, topic)
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodePubSubTopic decodes a topic from a URL. Like the RPC API, it must
+// be base64url multibase encoded, as topics are binary.
+func decodePubSubTopic(s string) (string, error) {
+	if s == "" || strings.Contains(s, "/") {
+		return "", errors.New("the path must be " + PubSubPath + "{topic}")
+	}
+	encoding, data, err := mbase.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("the topic must be multibase encoded: %w", err)
+	}
+	if encoding != mbase.Base64url {
+		return "", errors.New("the topic must be base64url encoded")
+	}
+	return string(data), nil
+}
+
+func topicAllowed(allowed []string, topic string) bool {
+	for _, t := range allowed {
+		if t == "*" || t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *pubsubHandler) publish(w http.ResponseWriter, r *http.Request, topic string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, pubsub.DefaultMaxMessageSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > pubsub.DefaultMaxMessageSize {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := h.api.Publish(r.Context(), topic, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *pubsubHandler) subscribe(w http.ResponseWriter, r *http.Request, topic string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sub, err := h.api.Subscribe(ctx, topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disables the buffering of nginx
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	msgs := make(chan coreiface.PubSubMessage)
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Debugw("pubsub subscription failed", "error", err)
+				}
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	encoder, _ := mbase.EncoderByName("base64url")
+	keepAlive := time.NewTicker(pubsubKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			ev := pubsubEvent{
+				From:  msg.From().String(),
+				Data:  encoder.Encode(msg.Data()),
+				Seqno: encoder.Encode(msg.Seq()),
+			}
+			for _, t := range msg.Topics() {
+				ev.TopicIDs = append(ev.TopicIDs, encoder.Encode([]byte(t)))
+			}
+			data, err := json.Marshal(&ev)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", ev.Seqno, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,150 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	coremock "github.com/ipfs/kubo/core/mock"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	mbase "github.com/multiformats/go-multibase"
+)
+
+func newPubSubTestServer(t *testing.T, limits config.GatewayRateLimits) *httptest.Server {
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{
+		Online:    true,
+		Host:      coremock.MockHostOption(mocknet.New()),
+		ExtraOpts: map[string]bool{"pubsub": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.PubSub.SubscribeTopics = []string{"*"}
+	cfg.Gateway.PubSub.PublishTopics = []string{"chat"}
+	cfg.Gateway.RateLimits = limits
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, PubSubOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestPubSubBridge(t *testing.T) {
+	ts := newPubSubTestServer(t, config.GatewayRateLimits{})
+	encode := func(s string) string {
+		enc, err := mbase.Encode(mbase.Base64url, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	post := func(topic, body string) int {
+		t.Helper()
+		res, err := http.Post(ts.URL+PubSubPath+topic, "application/octet-stream", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post(encode("other"), "hello"); code != http.StatusForbidden {
+		t.Errorf("expected 403 publishing to a topic not allowed, got %d", code)
+	}
+	if code := post("chat", "hello"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a topic not multibase encoded, got %d", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+PubSubPath+encode("chat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	// The subscription is made before the headers are sent.
+	if code := post(encode("chat"), "hello"); code != http.StatusNoContent {
+		t.Fatalf("expected 204 publishing, got %d", code)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev pubsubEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if _, data, err := mbase.Decode(ev.Data); err != nil || string(data) != "hello" {
+			t.Fatalf("unexpected event data %s", ev.Data)
+		}
+		if len(ev.TopicIDs) != 1 || ev.TopicIDs[0] != encode("chat") {
+			t.Fatalf("unexpected event topics %v", ev.TopicIDs)
+		}
+		return
+	}
+	t.Fatalf("no event received: %v", scanner.Err())
+}
+
+func TestPubSubBridgeRateLimits(t *testing.T) {
+	ts := newPubSubTestServer(t, rateLimits(t, `{"RequestsPerSecond": 1, "MaxConcurrentRequests": 1}`))
+	topic, err := mbase.Encode(mbase.Base64url, []byte("chat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+PubSubPath+topic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the subscription to be accepted, got %d", res.StatusCode)
+	}
+
+	// The open stream holds the only slot, and the rate is exhausted.
+	res2, err := http.Post(ts.URL+PubSubPath+topic, "application/octet-stream", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", res2.StatusCode)
+	}
+}
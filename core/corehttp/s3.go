@@ -0,0 +1,944 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-ipfs-files"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-mfs"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	core "github.com/ipfs/kubo/core"
+	coreapi "github.com/ipfs/kubo/core/coreapi"
+)
+
+const (
+	// s3UploadsDir is the MFS directory where the parts of multipart
+	// uploads are kept until they are completed. Bucket names cannot start
+	// with a dot, so it is never listed as a bucket.
+	s3UploadsDir = "/.s3-uploads"
+
+	s3MaxKeys       = 1000
+	s3MaxPartNumber = 10000
+
+	s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// s3ModTime is the modification time of all objects and buckets, as MFS does
+// not keep any.
+var s3ModTime = time.Unix(0, 0).UTC()
+
+// S3Option serves an S3-compatible API, where the buckets are the top-level
+// directories of MFS and the objects are the files below them, with their
+// CID as ETag. Requests are authenticated with AWS Signature Version 4,
+// using the keys in S3.Credentials. Buckets are addressed in the path.
+//
+// Objects are added like 'ipfs add' does, and are pinned recursively.
+// Deleting or replacing an object removes its pin, which objects with the
+// same content share, so they are then only kept by MFS.
+func S3Option() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.S3.Credentials) == 0 {
+			return nil, errors.New("the S3 API requires at least one key in S3.Credentials")
+		}
+		if n.FilesRoot == nil {
+			return nil, errors.New("the S3 API requires MFS")
+		}
+		api, err := coreapi.NewCoreAPI(n)
+		if err != nil {
+			return nil, err
+		}
+
+		mux.Handle("/", &s3Server{
+			api:         api,
+			root:        n.FilesRoot,
+			gcLocker:    n.Blockstore,
+			credentials: cfg.S3.Credentials,
+		})
+		return mux, nil
+	}
+}
+
+type s3Server struct {
+	api         coreiface.CoreAPI
+	root        *mfs.Root
+	gcLocker    bstore.GCLocker // holds GC off until added parts are in MFS
+	credentials map[string]string
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth, serr := parseSigV4(r)
+	if serr == nil {
+		secret, ok := s.credentials[auth.accessKey]
+		if !ok {
+			serr = s3Errorf(s3ErrInvalidAccessKeyID)
+		} else {
+			serr = auth.verify(r, secret, time.Now())
+		}
+	}
+	if serr != nil {
+		writeS3Error(w, r, serr)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	if bucket != "" {
+		if serr := checkBucketName(bucket); serr != nil {
+			writeS3Error(w, r, serr)
+			return
+		}
+	}
+	if key != "" {
+		if serr := checkObjectKey(key); serr != nil {
+			writeS3Error(w, r, serr)
+			return
+		}
+	}
+
+	var body *payloadReader
+	if r.Body != nil {
+		body = newPayloadReader(r.Body, auth.payloadHash)
+	}
+
+	ctx := r.Context()
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		serr = s.listBuckets(ctx, w)
+
+	case key == "" && r.Method == http.MethodGet && query.Has("location"):
+		serr = s.getBucketLocation(w, bucket)
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		serr = s.listObjects(ctx, w, bucket, query)
+	case key == "" && r.Method == http.MethodHead:
+		_, serr = s.lookupBucket(bucket)
+	case key == "" && r.Method == http.MethodPut:
+		serr = s.createBucket(w, bucket)
+	case key == "" && r.Method == http.MethodDelete:
+		serr = s.deleteBucket(ctx, w, bucket)
+
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		serr = s.getObject(w, r, bucket, key)
+	case key != "" && r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		serr = s3ErrorWithMessage(s3ErrNotImplemented, "CopyObject is not supported")
+	case key != "" && r.Method == http.MethodPut && query.Has("uploadId"):
+		serr = s.uploadPart(ctx, w, body, bucket, key, query)
+	case key != "" && r.Method == http.MethodPut:
+		serr = s.putObject(ctx, w, body, bucket, key)
+	case key != "" && r.Method == http.MethodDelete && query.Has("uploadId"):
+		serr = s.abortMultipartUpload(w, bucket, key, query.Get("uploadId"))
+	case key != "" && r.Method == http.MethodDelete:
+		serr = s.deleteObject(ctx, w, bucket, key)
+	case key != "" && r.Method == http.MethodPost && query.Has("uploads"):
+		serr = s.createMultipartUpload(ctx, w, bucket, key)
+	case key != "" && r.Method == http.MethodPost && query.Has("uploadId"):
+		serr = s.completeMultipartUpload(ctx, w, r, body, bucket, key, query.Get("uploadId"))
+
+	default:
+		serr = s3ErrorWithMessage(s3ErrNotImplemented, r.Method+" "+r.URL.RequestURI()+" is not supported")
+	}
+	if serr != nil {
+		writeS3Error(w, r, serr)
+	}
+}
+
+// checkBucketName accepts the bucket names allowed by S3. They are also
+// valid MFS names.
+func checkBucketName(bucket string) *s3Error {
+	if len(bucket) < 3 || len(bucket) > 63 || bucket[0] == '.' || bucket[0] == '-' {
+		return s3Errorf(s3ErrInvalidBucketName)
+	}
+	for _, c := range bucket {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '.' || c == '-') {
+			return s3Errorf(s3ErrInvalidBucketName)
+		}
+	}
+	return nil
+}
+
+// checkObjectKey accepts the keys that map to MFS paths: '/' separated
+// names that are not empty, '.' or '..'. A trailing '/' is allowed for
+// directory markers.
+func checkObjectKey(key string) *s3Error {
+	for _, name := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if name == "" || name == "." || name == ".." {
+			return s3ErrorWithMessage(s3ErrInvalidArgument, "object keys must be valid MFS paths")
+		}
+	}
+	return nil
+}
+
+func (s *s3Server) lookupBucket(bucket string) (*mfs.Directory, *s3Error) {
+	fsn, err := mfs.Lookup(s.root, "/"+bucket)
+	if err == os.ErrNotExist {
+		return nil, s3Errorf(s3ErrNoSuchBucket)
+	}
+	if err != nil {
+		return nil, s3InternalError(err)
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, s3Errorf(s3ErrNoSuchBucket)
+	}
+	return dir, nil
+}
+
+func (s *s3Server) lookupObject(bucket, key string) (*mfs.File, *s3Error) {
+	if _, serr := s.lookupBucket(bucket); serr != nil {
+		return nil, serr
+	}
+	fsn, err := mfs.Lookup(s.root, "/"+bucket+"/"+key)
+	if err != nil {
+		return nil, s3Errorf(s3ErrNoSuchKey)
+	}
+	f, ok := fsn.(*mfs.File)
+	if !ok {
+		return nil, s3Errorf(s3ErrNoSuchKey)
+	}
+	return f, nil
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+func (s *s3Server) listBuckets(ctx context.Context, w http.ResponseWriter) *s3Error {
+	listing, err := s.root.GetDirectory().List(ctx)
+	if err != nil {
+		return s3InternalError(err)
+	}
+	var res struct {
+		XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+		Xmlns   string     `xml:"xmlns,attr"`
+		Buckets []s3Bucket `xml:"Buckets>Bucket"`
+	}
+	res.Xmlns = s3XMLNamespace
+	for _, l := range listing {
+		if l.Type == int(mfs.TDir) && checkBucketName(l.Name) == nil {
+			res.Buckets = append(res.Buckets, s3Bucket{Name: l.Name, CreationDate: s3ModTime.Format(time.RFC3339)})
+		}
+	}
+	return writeS3Response(w, http.StatusOK, res)
+}
+
+func (s *s3Server) getBucketLocation(w http.ResponseWriter, bucket string) *s3Error {
+	if _, serr := s.lookupBucket(bucket); serr != nil {
+		return serr
+	}
+	var res struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		Xmlns   string   `xml:"xmlns,attr"`
+	}
+	res.Xmlns = s3XMLNamespace
+	return writeS3Response(w, http.StatusOK, res)
+}
+
+func (s *s3Server) createBucket(w http.ResponseWriter, bucket string) *s3Error {
+	err := mfs.Mkdir(s.root, "/"+bucket, mfs.MkdirOpts{Flush: true})
+	if err == os.ErrExist {
+		return s3Errorf(s3ErrBucketAlreadyOwnedByYou)
+	}
+	if err != nil {
+		return s3InternalError(err)
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *s3Server) deleteBucket(ctx context.Context, w http.ResponseWriter, bucket string) *s3Error {
+	dir, serr := s.lookupBucket(bucket)
+	if serr != nil {
+		return serr
+	}
+	names, err := dir.ListNames(ctx)
+	if err != nil {
+		return s3InternalError(err)
+	}
+	if len(names) > 0 {
+		return s3Errorf(s3ErrBucketNotEmpty)
+	}
+	root := s.root.GetDirectory()
+	if err := root.Unlink(bucket); err != nil {
+		return s3InternalError(err)
+	}
+	if err := root.Flush(); err != nil {
+		return s3InternalError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// getObject serves GetObject and HeadObject, with range requests and
+// conditional requests on the ETag.
+func (s *s3Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) *s3Error {
+	f, serr := s.lookupObject(bucket, key)
+	if serr != nil {
+		return serr
+	}
+	nd, err := f.GetNode()
+	if err != nil {
+		return s3InternalError(err)
+	}
+	fd, err := f.Open(mfs.Flags{Read: true})
+	if err != nil {
+		return s3InternalError(err)
+	}
+	defer fd.Close()
+
+	w.Header().Set("ETag", `"`+nd.Cid().String()+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, "", s3ModTime, fd)
+	return nil
+}
+
+func (s *s3Server) putObject(ctx context.Context, w http.ResponseWriter, body *payloadReader, bucket, key string) *s3Error {
+	if _, serr := s.lookupBucket(bucket); serr != nil {
+		return serr
+	}
+
+	// Keys ending with '/' are directory markers.
+	if strings.HasSuffix(key, "/") {
+		err := mfs.Mkdir(s.root, "/"+bucket+"/"+key, mfs.MkdirOpts{Mkparents: true, Flush: true})
+		if err != nil {
+			return s3InternalError(err)
+		}
+		w.Header().Set("ETag", `""`)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	c, serr := s.addAndLink(ctx, body, "/"+bucket+"/"+key, true)
+	if serr != nil {
+		return serr
+	}
+	w.Header().Set("ETag", `"`+c.String()+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// addAndLink adds body with add and puts it at mfsPath with link. Objects
+// are pinned, and the object they replace is unpinned. Unpinned files, like
+// the parts of multipart uploads, are only kept by MFS, so GC is held off
+// until they are linked.
+func (s *s3Server) addAndLink(ctx context.Context, body *payloadReader, mfsPath string, pin bool) (cid.Cid, *s3Error) {
+	if !pin {
+		defer s.gcLocker.PinLock(ctx).Unlock(ctx)
+	}
+
+	c, serr := s.add(ctx, body, pin)
+	if serr != nil {
+		return cid.Undef, serr
+	}
+	old, serr := s.link(ctx, mfsPath, c)
+	if serr != nil {
+		if pin {
+			_ = s.unpin(ctx, c)
+		}
+		return cid.Undef, serr
+	}
+	if pin && old.Defined() && !old.Equals(c) {
+		if serr := s.unpin(ctx, old); serr != nil {
+			return cid.Undef, serr
+		}
+	}
+	return c, nil
+}
+
+// add imports r as a UnixFS file, with the same defaults as 'ipfs add'.
+// Bodies failing their checksum are unpinned.
+func (s *s3Server) add(ctx context.Context, body *payloadReader, pin bool) (cid.Cid, *s3Error) {
+	if body == nil {
+		body = newPayloadReader(strings.NewReader(""), s3UnsignedPayload)
+	}
+	p, err := s.api.Unixfs().Add(ctx, files.NewReaderFile(body), options.Unixfs.Pin(pin))
+	if err != nil {
+		return cid.Undef, s3InternalError(err)
+	}
+	if serr := body.check(); serr != nil {
+		if pin {
+			_ = s.unpin(ctx, p.Cid())
+		}
+		return cid.Undef, serr
+	}
+	return p.Cid(), nil
+}
+
+// unpin removes the recursive pin on c, if any.
+func (s *s3Server) unpin(ctx context.Context, c cid.Cid) *s3Error {
+	err := s.api.Pin().Rm(ctx, ipath.IpfsPath(c))
+	if err != nil && !errors.Is(err, pin.ErrNotPinned) {
+		return s3InternalError(err)
+	}
+	return nil
+}
+
+// link puts the UnixFS file c at mfsPath, creating the parent directories
+// and replacing any file already there. It returns the CID of the replaced
+// file, if any.
+func (s *s3Server) link(ctx context.Context, mfsPath string, c cid.Cid) (cid.Cid, *s3Error) {
+	nd, err := s.api.Dag().Get(ctx, c)
+	if err != nil {
+		return cid.Undef, s3InternalError(err)
+	}
+	dirname, name := gopath.Split(mfsPath)
+	if err := mfs.Mkdir(s.root, dirname, mfs.MkdirOpts{Mkparents: true}); err != nil {
+		return cid.Undef, s3ErrorWithMessage(s3ErrInvalidArgument, fmt.Sprintf("cannot create %s: %s", dirname, err))
+	}
+	fsn, err := mfs.Lookup(s.root, dirname)
+	if err != nil {
+		return cid.Undef, s3InternalError(err)
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return cid.Undef, s3ErrorWithMessage(s3ErrInvalidArgument, dirname+" is not a directory")
+	}
+	var replaced cid.Cid
+	if old, err := dir.Child(name); err == nil {
+		if _, ok := old.(*mfs.Directory); ok {
+			return cid.Undef, s3ErrorWithMessage(s3ErrInvalidArgument, mfsPath+" is a directory")
+		}
+		oldNd, err := old.GetNode()
+		if err != nil {
+			return cid.Undef, s3InternalError(err)
+		}
+		replaced = oldNd.Cid()
+		if err := dir.Unlink(name); err != nil {
+			return cid.Undef, s3InternalError(err)
+		}
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		return cid.Undef, s3InternalError(err)
+	}
+	if err := dir.Flush(); err != nil {
+		return cid.Undef, s3InternalError(err)
+	}
+	return replaced, nil
+}
+
+// deleteObject removes the object from MFS and unpins it, along with the
+// directories left empty, as S3 has no directories. Deleting a missing
+// object succeeds.
+func (s *s3Server) deleteObject(ctx context.Context, w http.ResponseWriter, bucket, key string) *s3Error {
+	if _, serr := s.lookupBucket(bucket); serr != nil {
+		return serr
+	}
+
+	// Directories are only removed through their marker, when empty.
+	mfsPath := "/" + bucket + "/" + strings.TrimSuffix(key, "/")
+	for removeDir := strings.HasSuffix(key, "/"); mfsPath != "/"+bucket; removeDir = true {
+		dirname, name := gopath.Split(mfsPath)
+		fsn, err := mfs.Lookup(s.root, dirname)
+		if err != nil {
+			break
+		}
+		dir, ok := fsn.(*mfs.Directory)
+		if !ok {
+			break
+		}
+		child, err := dir.Child(name)
+		if err != nil {
+			break
+		}
+		var deleted cid.Cid
+		if sub, ok := child.(*mfs.Directory); ok {
+			names, err := sub.ListNames(ctx)
+			if err != nil || len(names) > 0 || !removeDir {
+				break
+			}
+		} else {
+			nd, err := child.GetNode()
+			if err != nil {
+				return s3InternalError(err)
+			}
+			deleted = nd.Cid()
+		}
+		if err := dir.Unlink(name); err != nil {
+			return s3InternalError(err)
+		}
+		if err := dir.Flush(); err != nil {
+			return s3InternalError(err)
+		}
+		if deleted.Defined() {
+			if serr := s.unpin(ctx, deleted); serr != nil {
+				return serr
+			}
+		}
+		mfsPath = gopath.Clean(dirname)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+// listObjects serves ListObjectsV2. Keys are listed in lexicographic order
+// by walking the MFS directories, skipping those that cannot hold keys with
+// the prefix, or after the continuation token.
+func (s *s3Server) listObjects(ctx context.Context, w http.ResponseWriter, bucket string, query map[string][]string) *s3Error {
+	dir, serr := s.lookupBucket(bucket)
+	if serr != nil {
+		return serr
+	}
+	get := func(k string) string {
+		if v := query[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delimiter := get("prefix"), get("delimiter")
+	maxKeys := s3MaxKeys
+	if v := get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return s3ErrorWithMessage(s3ErrInvalidArgument, "invalid max-keys")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := get("start-after")
+	if token := get("continuation-token"); token != "" {
+		t, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return s3ErrorWithMessage(s3ErrInvalidArgument, "invalid continuation-token")
+		}
+		after = string(t)
+	}
+
+	var res struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		Contents              []s3Object
+		CommonPrefixes        []s3CommonPrefix
+	}
+	res.Xmlns = s3XMLNamespace
+	res.Name = bucket
+	res.Prefix = prefix
+	res.Delimiter = delimiter
+	res.MaxKeys = maxKeys
+	res.ContinuationToken = get("continuation-token")
+	res.StartAfter = get("start-after")
+
+	// Each key is listed either as itself, or as the common prefix that
+	// groups it with others. Groups come in increasing order, which is what
+	// the continuation token is compared with.
+	errStop := errors.New("stop")
+	last := ""
+	visit := func(key string, l mfs.NodeListing, isPrefix bool) error {
+		group := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				group = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if group <= after || group == last {
+			return nil
+		}
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			return errStop
+		}
+		if isPrefix || group != key {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3CommonPrefix{Prefix: group})
+		} else {
+			res.Contents = append(res.Contents, s3Object{
+				Key:          key,
+				LastModified: s3ModTime.Format(time.RFC3339),
+				ETag:         `"` + l.Hash + `"`,
+				Size:         l.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		res.KeyCount++
+		last = group
+		return nil
+	}
+	err := s3Walk(ctx, dir, "", prefix, after, delimiter, visit)
+	if err != nil && err != errStop {
+		return s3InternalError(err)
+	}
+	if res.IsTruncated {
+		res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	return writeS3Response(w, http.StatusOK, res)
+}
+
+// s3Walk calls visit with the keys below dir, which is at dirKey, in
+// lexicographic order. Directories are only entered when they can hold keys
+// with the prefix and after the key after, and are listed as common
+// prefixes instead when the delimiter is '/'. Empty directories are listed
+// as directory markers.
+func s3Walk(ctx context.Context, dir *mfs.Directory, dirKey, prefix, after, delimiter string, visit func(key string, l mfs.NodeListing, isPrefix bool) error) error {
+	listing, err := dir.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(listing) == 0 && dirKey != "" && strings.HasPrefix(dirKey, prefix) {
+		return visit(dirKey, mfs.NodeListing{}, false)
+	}
+
+	// Sort as keys: the keys below a directory start with its name and '/'.
+	sortKey := func(l mfs.NodeListing) string {
+		if l.Type == int(mfs.TDir) {
+			return l.Name + "/"
+		}
+		return l.Name
+	}
+	sort.Slice(listing, func(i, j int) bool { return sortKey(listing[i]) < sortKey(listing[j]) })
+
+	for _, l := range listing {
+		key := dirKey + sortKey(l)
+		if l.Type != int(mfs.TDir) {
+			if strings.HasPrefix(key, prefix) {
+				if err := visit(key, l, false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			continue
+		}
+		if key < after && !strings.HasPrefix(after, key) {
+			continue
+		}
+		// A directory grouped in a common prefix is listed without
+		// entering it.
+		if delimiter == "/" && strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			if err := visit(key, l, true); err != nil {
+				return err
+			}
+			continue
+		}
+		child, err := dir.Child(l.Name)
+		if err != nil {
+			return err
+		}
+		sub, ok := child.(*mfs.Directory)
+		if !ok {
+			continue
+		}
+		if err := s3Walk(ctx, sub, key, prefix, after, delimiter, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMultipartUpload starts a multipart upload, whose parts are kept in
+// MFS under s3UploadsDir until it is completed or aborted.
+func (s *s3Server) createMultipartUpload(ctx context.Context, w http.ResponseWriter, bucket, key string) *s3Error {
+	if _, serr := s.lookupBucket(bucket); serr != nil {
+		return serr
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return s3InternalError(err)
+	}
+	uploadID := hex.EncodeToString(id[:])
+
+	// The key of the upload is kept with its parts.
+	_, serr := s.addAndLink(ctx, newPayloadReader(strings.NewReader(bucket+"/"+key), s3UnsignedPayload), s3UploadsDir+"/"+uploadID+"/key", false)
+	if serr != nil {
+		return serr
+	}
+
+	var res struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}
+	res.Xmlns = s3XMLNamespace
+	res.Bucket, res.Key, res.UploadId = bucket, key, uploadID
+	return writeS3Response(w, http.StatusOK, res)
+}
+
+// lookupUpload returns the directory of the multipart upload uploadID of
+// key in bucket.
+func (s *s3Server) lookupUpload(bucket, key, uploadID string) (*mfs.Directory, *s3Error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return nil, s3Errorf(s3ErrNoSuchUpload)
+	}
+	fsn, err := mfs.Lookup(s.root, s3UploadsDir+"/"+uploadID)
+	if err != nil {
+		return nil, s3Errorf(s3ErrNoSuchUpload)
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, s3Errorf(s3ErrNoSuchUpload)
+	}
+	data, err := s.readFile(dir, "key")
+	if err != nil || string(data) != bucket+"/"+key {
+		return nil, s3Errorf(s3ErrNoSuchUpload)
+	}
+	return dir, nil
+}
+
+func (s *s3Server) readFile(dir *mfs.Directory, name string) ([]byte, error) {
+	fsn, err := dir.Child(name)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fsn.(*mfs.File)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a file", name)
+	}
+	fd, err := f.Open(mfs.Flags{Read: true})
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return io.ReadAll(fd)
+}
+
+func (s *s3Server) uploadPart(ctx context.Context, w http.ResponseWriter, body *payloadReader, bucket, key string, query map[string][]string) *s3Error {
+	uploadID := ""
+	if v := query["uploadId"]; len(v) > 0 {
+		uploadID = v[0]
+	}
+	if _, serr := s.lookupUpload(bucket, key, uploadID); serr != nil {
+		return serr
+	}
+	var partNumber int
+	if v := query["partNumber"]; len(v) > 0 {
+		partNumber, _ = strconv.Atoi(v[0])
+	}
+	if partNumber < 1 || partNumber > s3MaxPartNumber {
+		return s3ErrorWithMessage(s3ErrInvalidArgument, fmt.Sprintf("part number must be between 1 and %d", s3MaxPartNumber))
+	}
+
+	// Parts are only kept by MFS, the completed object is pinned.
+	c, serr := s.addAndLink(ctx, body, fmt.Sprintf("%s/%s/%d", s3UploadsDir, uploadID, partNumber), false)
+	if serr != nil {
+		return serr
+	}
+	w.Header().Set("ETag", `"`+c.String()+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeMultipartUpload adds the object made of the listed parts, as a
+// single file chunked like 'ipfs add' would.
+func (s *s3Server) completeMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, body *payloadReader, bucket, key, uploadID string) *s3Error {
+	dir, serr := s.lookupUpload(bucket, key, uploadID)
+	if serr != nil {
+		return serr
+	}
+
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if body == nil {
+		return s3Errorf(s3ErrMalformedXML)
+	}
+	if err := xml.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&req); err != nil {
+		return s3Errorf(s3ErrMalformedXML)
+	}
+	if serr := body.check(); serr != nil {
+		return serr
+	}
+	if len(req.Parts) == 0 {
+		return s3Errorf(s3ErrMalformedXML)
+	}
+
+	readers := make([]io.Reader, 0, len(req.Parts))
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return s3Errorf(s3ErrInvalidPartOrder)
+		}
+		fsn, err := dir.Child(strconv.Itoa(part.PartNumber))
+		if err != nil {
+			return s3Errorf(s3ErrInvalidPart)
+		}
+		nd, err := fsn.GetNode()
+		if err != nil {
+			return s3InternalError(err)
+		}
+		if strings.Trim(part.ETag, `"`) != nd.Cid().String() {
+			return s3Errorf(s3ErrInvalidPart)
+		}
+		f, err := s.api.Unixfs().Get(ctx, ipath.IpfsPath(nd.Cid()))
+		if err != nil {
+			return s3InternalError(err)
+		}
+		defer f.Close()
+		fr, ok := f.(files.File)
+		if !ok {
+			return s3Errorf(s3ErrInvalidPart)
+		}
+		readers = append(readers, fr)
+	}
+
+	c, serr := s.addAndLink(ctx, newPayloadReader(io.MultiReader(readers...), s3UnsignedPayload), "/"+bucket+"/"+key, true)
+	if serr != nil {
+		return serr
+	}
+	if serr := s.removeUpload(uploadID); serr != nil {
+		return serr
+	}
+
+	var res struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}
+	res.Xmlns = s3XMLNamespace
+	res.Location = "http://" + r.Host + "/" + bucket + "/" + key
+	res.Bucket, res.Key, res.ETag = bucket, key, `"`+c.String()+`"`
+	return writeS3Response(w, http.StatusOK, res)
+}
+
+func (s *s3Server) abortMultipartUpload(w http.ResponseWriter, bucket, key, uploadID string) *s3Error {
+	if _, serr := s.lookupUpload(bucket, key, uploadID); serr != nil {
+		return serr
+	}
+	if serr := s.removeUpload(uploadID); serr != nil {
+		return serr
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *s3Server) removeUpload(uploadID string) *s3Error {
+	fsn, err := mfs.Lookup(s.root, s3UploadsDir)
+	if err != nil {
+		return s3InternalError(err)
+	}
+	uploads, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return s3InternalError(errors.New(s3UploadsDir + " is not a directory"))
+	}
+	if err := uploads.Unlink(uploadID); err != nil {
+		return s3InternalError(err)
+	}
+	if err := uploads.Flush(); err != nil {
+		return s3InternalError(err)
+	}
+	return nil
+}
+
+func writeS3Response(w http.ResponseWriter, status int, v interface{}) *s3Error {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return s3InternalError(err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+	return nil
+}
+
+// s3Error is an error returned to S3 clients:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
+type s3Error struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	s3ErrAccessDenied            = s3Error{"AccessDenied", "Access Denied", http.StatusForbidden}
+	s3ErrAuthorizationMalformed  = s3Error{"AuthorizationHeaderMalformed", "The authorization is malformed", http.StatusBadRequest}
+	s3ErrBucketAlreadyOwnedByYou = s3Error{"BucketAlreadyOwnedByYou", "The bucket already exists", http.StatusConflict}
+	s3ErrBucketNotEmpty          = s3Error{"BucketNotEmpty", "The bucket is not empty", http.StatusConflict}
+	s3ErrContentSHA256Mismatch   = s3Error{"XAmzContentSHA256Mismatch", "The payload does not match its X-Amz-Content-Sha256", http.StatusBadRequest}
+	s3ErrInternalError           = s3Error{"InternalError", "Internal error", http.StatusInternalServerError}
+	s3ErrInvalidAccessKeyID      = s3Error{"InvalidAccessKeyId", "The access key ID does not exist", http.StatusForbidden}
+	s3ErrInvalidArgument         = s3Error{"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	s3ErrInvalidBucketName       = s3Error{"InvalidBucketName", "The bucket name is not valid", http.StatusBadRequest}
+	s3ErrInvalidPart             = s3Error{"InvalidPart", "A part could not be found, or its ETag does not match", http.StatusBadRequest}
+	s3ErrInvalidPartOrder        = s3Error{"InvalidPartOrder", "The parts are not in ascending order", http.StatusBadRequest}
+	s3ErrMalformedXML            = s3Error{"MalformedXML", "The XML is not well-formed", http.StatusBadRequest}
+	s3ErrNoSuchBucket            = s3Error{"NoSuchBucket", "The bucket does not exist", http.StatusNotFound}
+	s3ErrNoSuchKey               = s3Error{"NoSuchKey", "The key does not exist", http.StatusNotFound}
+	s3ErrNoSuchUpload            = s3Error{"NoSuchUpload", "The multipart upload does not exist", http.StatusNotFound}
+	s3ErrNotImplemented          = s3Error{"NotImplemented", "Not implemented", http.StatusNotImplemented}
+	s3ErrRequestTimeTooSkewed    = s3Error{"RequestTimeTooSkewed", "The request time is too far from the server time", http.StatusForbidden}
+	s3ErrSignatureDoesNotMatch   = s3Error{"SignatureDoesNotMatch", "The request signature does not match", http.StatusForbidden}
+)
+
+func s3Errorf(e s3Error) *s3Error {
+	return &e
+}
+
+func s3ErrorWithMessage(e s3Error, msg string) *s3Error {
+	e.Message = msg
+	return &e
+}
+
+func s3InternalError(err error) *s3Error {
+	log.Errorw("s3 request failed", "error", err)
+	return s3ErrorWithMessage(s3ErrInternalError, err.Error())
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, e *s3Error) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.Status)
+		return
+	}
+	res := struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: e.Code, Message: e.Message, Resource: r.URL.Path}
+	_ = writeS3Response(w, e.Status, res)
+}
@@ -0,0 +1,271 @@
+package corehttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as used by S3:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4MaxSkew    = 15 * time.Minute
+	sigV4MaxExpires = 7 * 24 * time.Hour
+
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload = "STREAMING-"
+)
+
+// sigV4Auth is the signature of a request, from its Authorization header or
+// its query parameters (presigned URLs).
+type sigV4Auth struct {
+	accessKey     string
+	date          string // yyyymmdd
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+
+	amzDate     time.Time
+	payloadHash string
+	presigned   bool
+}
+
+// parseSigV4 reads the signature of r.
+func parseSigV4(r *http.Request) (*sigV4Auth, *s3Error) {
+	var (
+		a          sigV4Auth
+		credential string
+		signed     string
+		amzDate    string
+	)
+	query := r.URL.Query()
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+			return nil, s3ErrorWithMessage(s3ErrAccessDenied, "only "+sigV4Algorithm+" signatures are supported")
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signed = v
+			case "Signature":
+				a.signature = v
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		if amzDate == "" {
+			amzDate = r.Header.Get("Date")
+		}
+		a.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if a.payloadHash == "" {
+			return nil, s3ErrorWithMessage(s3ErrAccessDenied, "missing X-Amz-Content-Sha256 header")
+		}
+	} else if query.Get("X-Amz-Algorithm") != "" {
+		if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+			return nil, s3ErrorWithMessage(s3ErrAccessDenied, "only "+sigV4Algorithm+" signatures are supported")
+		}
+		credential = query.Get("X-Amz-Credential")
+		signed = query.Get("X-Amz-SignedHeaders")
+		a.signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		a.payloadHash = s3UnsignedPayload
+		a.presigned = true
+	} else {
+		return nil, s3ErrorWithMessage(s3ErrAccessDenied, "anonymous requests are not allowed")
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || signed == "" || a.signature == "" {
+		return nil, s3ErrorWithMessage(s3ErrAuthorizationMalformed, "malformed credential or signature")
+	}
+	a.accessKey, a.date, a.region, a.service = parts[0], parts[1], parts[2], parts[3]
+	a.signedHeaders = strings.Split(signed, ";")
+
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil {
+		if t, err = http.ParseTime(amzDate); err != nil {
+			return nil, s3ErrorWithMessage(s3ErrAccessDenied, "missing or invalid request date")
+		}
+	}
+	a.amzDate = t.UTC()
+	if a.amzDate.Format("20060102") != a.date {
+		return nil, s3ErrorWithMessage(s3ErrAuthorizationMalformed, "the credential date does not match the request date")
+	}
+	if strings.HasPrefix(a.payloadHash, s3StreamingPayload) {
+		return nil, s3ErrorWithMessage(s3ErrNotImplemented, "streaming (aws-chunked) uploads are not supported")
+	}
+	return &a, nil
+}
+
+// verify checks that the request was signed with secret, and is still
+// valid at now.
+func (a *sigV4Auth) verify(r *http.Request, secret string, now time.Time) *s3Error {
+	if a.presigned {
+		expires, err := strconv.Atoi(r.URL.Query().Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > sigV4MaxExpires {
+			return s3ErrorWithMessage(s3ErrAuthorizationMalformed, "invalid X-Amz-Expires")
+		}
+		if now.After(a.amzDate.Add(time.Duration(expires) * time.Second)) {
+			return s3ErrorWithMessage(s3ErrAccessDenied, "request has expired")
+		}
+	} else if d := now.Sub(a.amzDate); d > sigV4MaxSkew || d < -sigV4MaxSkew {
+		return s3Errorf(s3ErrRequestTimeTooSkewed)
+	}
+
+	canonical := sigV4CanonicalRequest(r, a.signedHeaders, a.payloadHash, a.presigned)
+	scope := strings.Join([]string{a.date, a.region, a.service, "aws4_request"}, "/")
+	expected := sigV4Signature(secret, a.date, a.region, a.service, sigV4StringToSign(a.amzDate, scope, canonical))
+	if !hmac.Equal([]byte(expected), []byte(a.signature)) {
+		return s3Errorf(s3ErrSignatureDoesNotMatch)
+	}
+	return nil
+}
+
+// sigV4CanonicalRequest builds the canonical form of r that is signed.
+func sigV4CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string, presigned bool) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(sigV4Encode(r.URL.Path, false))
+	b.WriteByte('\n')
+
+	// Query parameters sorted by name, then value
+	var params [][2]string
+	for _, kv := range strings.Split(r.URL.RawQuery, "&") {
+		if kv == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		if uk, err := url.PathUnescape(k); err == nil {
+			k = uk
+		}
+		if uv, err := url.PathUnescape(v); err == nil {
+			v = uv
+		}
+		if presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		params = append(params, [2]string{sigV4Encode(k, true), sigV4Encode(v, true)})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	for i, p := range params {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(p[0] + "=" + p[1])
+	}
+	b.WriteByte('\n')
+
+	for _, h := range signedHeaders {
+		var value string
+		switch h {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			values := r.Header.Values(h)
+			for i := range values {
+				values[i] = strings.Join(strings.Fields(values[i]), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(h + ":" + value + "\n")
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+func sigV4StringToSign(t time.Time, scope, canonicalRequest string) string {
+	h := sha256.Sum256([]byte(canonicalRequest))
+	return sigV4Algorithm + "\n" + t.Format(sigV4TimeFormat) + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+}
+
+func sigV4Signature(secret, date, region, service, stringToSign string) string {
+	key := sigV4HMAC([]byte("AWS4"+secret), date)
+	key = sigV4HMAC(key, region)
+	key = sigV4HMAC(key, service)
+	key = sigV4HMAC(key, "aws4_request")
+	return hex.EncodeToString(sigV4HMAC(key, stringToSign))
+}
+
+func sigV4HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sigV4Encode URI-encodes s the way AWS does: every byte but the unreserved
+// characters, and '/' unless encodeSlash is set.
+func sigV4Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// payloadReader checks the body of a request against the SHA-256 it was
+// signed with, once it has been read entirely.
+type payloadReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+}
+
+func newPayloadReader(r io.Reader, payloadHash string) *payloadReader {
+	pr := &payloadReader{r: r}
+	if payloadHash != s3UnsignedPayload {
+		pr.h = sha256.New()
+		pr.r = io.TeeReader(r, pr.h)
+		pr.expected = payloadHash
+	}
+	return pr
+}
+
+func (pr *payloadReader) Read(p []byte) (int, error) {
+	return pr.r.Read(p)
+}
+
+// check returns an error when the payload read does not match its signed
+// hash.
+func (pr *payloadReader) check() *s3Error {
+	if pr.h != nil && hex.EncodeToString(pr.h.Sum(nil)) != strings.ToLower(pr.expected) {
+		return s3Errorf(s3ErrContentSHA256Mismatch)
+	}
+	return nil
+}
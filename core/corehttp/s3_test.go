@@ -0,0 +1,269 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	repo "github.com/ipfs/kubo/repo"
+)
+
+const (
+	testS3AccessKey = "AKIDEXAMPLE"
+	testS3Secret    = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// Vectors from the AWS Signature Version 4 test suite.
+func TestSigV4Signature(t *testing.T) {
+	for _, tc := range []struct {
+		name, url, signature string
+	}{
+		{"get-vanilla", "/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com"+tc.url, nil)
+		r.Header.Set("X-Amz-Date", "20150830T123600Z")
+		date, err := time.Parse(sigV4TimeFormat, "20150830T123600Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		emptyHash := sha256.Sum256(nil)
+		canonical := sigV4CanonicalRequest(r, []string{"host", "x-amz-date"}, hex.EncodeToString(emptyHash[:]), false)
+		sts := sigV4StringToSign(date, "20150830/us-east-1/service/aws4_request", canonical)
+		if sig := sigV4Signature(testS3Secret, "20150830", "us-east-1", "service", sts); sig != tc.signature {
+			t.Errorf("%s: expected signature %s, got %s", tc.name, tc.signature, sig)
+		}
+	}
+}
+
+func newS3TestServer(t *testing.T) (*httptest.Server, *core.IpfsNode) {
+	c := config.Config{
+		Identity: config.Identity{
+			PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+		},
+	}
+	c.S3.Credentials = map[string]string{testS3AccessKey: testS3Secret}
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+
+	dh.Handler, err = makeHandler(n, ts.Listener, S3Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, n
+}
+
+// doS3 sends a request signed with the test credentials.
+func doS3(t *testing.T, ts *httptest.Server, method, url, body, secret string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256([]byte(body))
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Host = req.URL.Host
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	scope := date + "/us-east-1/s3/aws4_request"
+	canonical := sigV4CanonicalRequest(req, signed, req.Header.Get("X-Amz-Content-Sha256"), false)
+	sig := sigV4Signature(secret, date, "us-east-1", "s3", sigV4StringToSign(now, scope, canonical))
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+testS3AccessKey+"/"+scope+", SignedHeaders="+strings.Join(signed, ";")+", Signature="+sig)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+func TestS3(t *testing.T) {
+	ts, n := newS3TestServer(t)
+
+	expectStatus := func(res *http.Response, body string, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s %s: expected %d, got %d: %s", res.Request.Method, res.Request.URL, status, res.StatusCode, body)
+		}
+	}
+
+	res, body := doS3(t, ts, http.MethodPut, "/bucket", "", "wrong")
+	expectStatus(res, body, http.StatusForbidden)
+	if !strings.Contains(body, "SignatureDoesNotMatch") {
+		t.Errorf("unexpected error %s", body)
+	}
+
+	res, body = doS3(t, ts, http.MethodPut, "/bucket/key", "data", testS3Secret)
+	expectStatus(res, body, http.StatusNotFound)
+	res, body = doS3(t, ts, http.MethodPut, "/bucket", "", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+
+	isPinned := func(etag string) bool {
+		t.Helper()
+		c, err := cid.Decode(strings.Trim(etag, `"`))
+		if err != nil {
+			t.Fatalf("ETag is not a CID: %s", etag)
+		}
+		_, pinned, err := n.Pinning.IsPinnedWithType(context.Background(), c, pin.Recursive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pinned
+	}
+
+	for _, key := range []string{"a/b.txt", "a/c/d.txt", "a-e.txt", "f.txt"} {
+		res, body = doS3(t, ts, http.MethodPut, "/bucket/"+key, "content of "+key, testS3Secret)
+		expectStatus(res, body, http.StatusOK)
+		if !isPinned(res.Header.Get("ETag")) {
+			t.Errorf("%s is not pinned", key)
+		}
+	}
+
+	// Overwrite
+	res, body = doS3(t, ts, http.MethodPut, "/bucket/f.txt", "old content", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	old := res.Header.Get("ETag")
+	res, body = doS3(t, ts, http.MethodPut, "/bucket/f.txt", "content of f.txt", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	if isPinned(old) || !isPinned(res.Header.Get("ETag")) {
+		t.Error("replaced object is still pinned, or its replacement is not")
+	}
+
+	res, body = doS3(t, ts, http.MethodGet, "/bucket/a/c/d.txt", "", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	if body != "content of a/c/d.txt" {
+		t.Errorf("unexpected content %q", body)
+	}
+	res, body = doS3(t, ts, http.MethodHead, "/bucket/a/missing.txt", "", testS3Secret)
+	expectStatus(res, body, http.StatusNotFound)
+
+	type listResult struct {
+		Keys                  []string `xml:"Contents>Key"`
+		Prefixes              []string `xml:"CommonPrefixes>Prefix"`
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+	list := func(query string) listResult {
+		t.Helper()
+		res, body := doS3(t, ts, http.MethodGet, "/bucket?list-type=2&"+query, "", testS3Secret)
+		expectStatus(res, body, http.StatusOK)
+		var lr listResult
+		if err := xml.Unmarshal([]byte(body), &lr); err != nil {
+			t.Fatal(err)
+		}
+		return lr
+	}
+	if lr := list(""); strings.Join(lr.Keys, ",") != "a-e.txt,a/b.txt,a/c/d.txt,f.txt" {
+		t.Errorf("unexpected keys %v", lr.Keys)
+	}
+	if lr := list("delimiter=%2F"); strings.Join(lr.Keys, ",") != "a-e.txt,f.txt" || strings.Join(lr.Prefixes, ",") != "a/" {
+		t.Errorf("unexpected keys %v and prefixes %v", lr.Keys, lr.Prefixes)
+	}
+	if lr := list("prefix=a%2F&delimiter=%2F"); strings.Join(lr.Keys, ",") != "a/b.txt" || strings.Join(lr.Prefixes, ",") != "a/c/" {
+		t.Errorf("unexpected keys %v and prefixes %v", lr.Keys, lr.Prefixes)
+	}
+	var paged []string
+	token := ""
+	for i := 0; i < 5; i++ {
+		lr := list("max-keys=3&continuation-token=" + token)
+		paged = append(paged, lr.Keys...)
+		if !lr.IsTruncated {
+			break
+		}
+		token = lr.NextContinuationToken
+	}
+	if strings.Join(paged, ",") != "a-e.txt,a/b.txt,a/c/d.txt,f.txt" {
+		t.Errorf("unexpected paged keys %v", paged)
+	}
+
+	// Multipart upload
+	res, body = doS3(t, ts, http.MethodPost, "/bucket/big.bin?uploads", "", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal([]byte(body), &initiated); err != nil {
+		t.Fatal(err)
+	}
+	var etags []string
+	for i, part := range []string{"first part, ", "second part"} {
+		res, body = doS3(t, ts, http.MethodPut, "/bucket/big.bin?partNumber="+string(rune('1'+i))+"&uploadId="+initiated.UploadID, part, testS3Secret)
+		expectStatus(res, body, http.StatusOK)
+		etags = append(etags, res.Header.Get("ETag"))
+		if isPinned(res.Header.Get("ETag")) {
+			t.Errorf("part %d is pinned", i+1)
+		}
+	}
+	complete := "<CompleteMultipartUpload>" +
+		"<Part><PartNumber>1</PartNumber><ETag>" + etags[0] + "</ETag></Part>" +
+		"<Part><PartNumber>2</PartNumber><ETag>" + etags[1] + "</ETag></Part>" +
+		"</CompleteMultipartUpload>"
+	res, body = doS3(t, ts, http.MethodPost, "/bucket/big.bin?uploadId="+initiated.UploadID, complete, testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	res, body = doS3(t, ts, http.MethodGet, "/bucket/big.bin", "", testS3Secret)
+	expectStatus(res, body, http.StatusOK)
+	if body != "first part, second part" {
+		t.Errorf("unexpected multipart content %q", body)
+	}
+	if !isPinned(res.Header.Get("ETag")) {
+		t.Error("completed multipart upload is not pinned")
+	}
+	res, body = doS3(t, ts, http.MethodPost, "/bucket/big.bin?uploadId="+initiated.UploadID, complete, testS3Secret)
+	expectStatus(res, body, http.StatusNotFound)
+
+	// Delete
+	res, body = doS3(t, ts, http.MethodDelete, "/bucket", "", testS3Secret)
+	expectStatus(res, body, http.StatusConflict)
+	for _, key := range []string{"a/b.txt", "a/c/d.txt", "a-e.txt", "f.txt", "big.bin"} {
+		res, body = doS3(t, ts, http.MethodDelete, "/bucket/"+key, "", testS3Secret)
+		expectStatus(res, body, http.StatusNoContent)
+	}
+	res, body = doS3(t, ts, http.MethodGet, "/bucket/f.txt", "", testS3Secret)
+	expectStatus(res, body, http.StatusNotFound)
+	if lr := list(""); len(lr.Keys) != 0 {
+		t.Errorf("unexpected keys left %v", lr.Keys)
+	}
+	res, body = doS3(t, ts, http.MethodDelete, "/bucket", "", testS3Secret)
+	expectStatus(res, body, http.StatusNoContent)
+
+	// Deleted objects are unpinned.
+	pins, err := n.Pinning.RecursiveKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 0 {
+		t.Errorf("unexpected pins %v", pins)
+	}
+}
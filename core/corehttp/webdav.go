@@ -0,0 +1,349 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	gopath "path"
+	"strings"
+	"time"
+
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	core "github.com/ipfs/kubo/core"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVOption serves the node's MFS (the 'ipfs files' tree) over WebDAV,
+// so it can be mounted by file managers without FUSE. Clients authenticate
+// with one of the tokens in WebDAV.AccessTokens, either as a bearer token or
+// as the password of basic auth.
+//
+// The ETags of files and directories are their CIDs.
+func WebDAVOption() ServeOption {
+	// Locks are shared by all the listeners.
+	ls := webdav.NewMemLS()
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.WebDAV.AccessTokens) == 0 {
+			return nil, errors.New("the WebDAV server requires at least one token in WebDAV.AccessTokens")
+		}
+		if n.FilesRoot == nil {
+			return nil, errors.New("the WebDAV server requires MFS")
+		}
+
+		mux.Handle("/", &webdavHandler{
+			tokens: cfg.WebDAV.AccessTokens,
+			handler: &webdav.Handler{
+				FileSystem: &mfsFileSystem{root: n.FilesRoot},
+				LockSystem: ls,
+				Logger: func(r *http.Request, err error) {
+					if err != nil {
+						log.Debugw("webdav request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+					}
+				},
+			},
+		})
+		return mux, nil
+	}
+}
+
+type webdavHandler struct {
+	tokens  []string
+	handler http.Handler
+}
+
+func (h *webdavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="IPFS MFS"`)
+		http.Error(w, "invalid or missing access token", http.StatusUnauthorized)
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// authorized accepts the tokens as bearer tokens, or as basic auth passwords
+// with any user name, as most WebDAV clients only support the latter.
+func (h *webdavHandler) authorized(r *http.Request) bool {
+	token := ""
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	} else if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return false
+	}
+	for _, t := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// mfsFileSystem is a webdav.FileSystem backed by MFS. Changes are flushed
+// to the MFS root as they are made, like the 'ipfs files' commands do by
+// default.
+type mfsFileSystem struct {
+	root *mfs.Root
+}
+
+func (fs *mfsFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return mfs.Mkdir(fs.root, gopath.Clean(name), mfs.MkdirOpts{Flush: true})
+}
+
+func (fs *mfsFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = gopath.Clean(name)
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	fsn, err := mfs.Lookup(fs.root, name)
+	switch {
+	case err == nil:
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, os.ErrExist
+		}
+	case err == os.ErrNotExist && flag&os.O_CREATE != 0:
+		if fsn, err = fs.createFile(name); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	switch fsn := fsn.(type) {
+	case *mfs.Directory:
+		if write {
+			return nil, fmt.Errorf("%s is a directory", name)
+		}
+		return &mfsDirectory{ctx: ctx, name: name, dir: fsn}, nil
+	case *mfs.File:
+		fd, err := fsn.Open(mfs.Flags{Read: true, Write: write, Sync: true})
+		if err != nil {
+			return nil, err
+		}
+		f := &mfsFile{name: name, file: fsn, fd: fd}
+		if write && flag&os.O_TRUNC != 0 {
+			err = fd.Truncate(0)
+		} else if write && flag&os.O_APPEND != 0 {
+			_, err = fd.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			fd.Close()
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unsupported node type at %s", name)
+	}
+}
+
+// createFile adds an empty file at name, like 'ipfs files write --create'.
+func (fs *mfsFileSystem) createFile(name string) (mfs.FSNode, error) {
+	dirname, fname := gopath.Split(name)
+	pdir, err := fs.lookupDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	nd := dag.NodeWithData(ft.FilePBData(nil, 0))
+	nd.SetCidBuilder(pdir.GetCidBuilder())
+	if err := pdir.AddChild(fname, nd); err != nil {
+		return nil, err
+	}
+	return pdir.Child(fname)
+}
+
+func (fs *mfsFileSystem) lookupDir(name string) (*mfs.Directory, error) {
+	fsn, err := mfs.Lookup(fs.root, name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", name)
+	}
+	return dir, nil
+}
+
+func (fs *mfsFileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = gopath.Clean(name)
+	if name == "/" {
+		return errors.New("cannot remove the MFS root")
+	}
+	dirname, fname := gopath.Split(name)
+	pdir, err := fs.lookupDir(dirname)
+	if err != nil {
+		return err
+	}
+	if _, err := pdir.Child(fname); err != nil {
+		return err
+	}
+	if err := pdir.Unlink(fname); err != nil {
+		return err
+	}
+	return pdir.Flush()
+}
+
+func (fs *mfsFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = gopath.Clean(oldName), gopath.Clean(newName)
+	if oldName == "/" || newName == "/" {
+		return errors.New("cannot move the MFS root")
+	}
+	if err := mfs.Mv(fs.root, oldName, newName); err != nil {
+		return err
+	}
+	_, err := mfs.FlushPath(ctx, fs.root, "/")
+	return err
+}
+
+func (fs *mfsFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = gopath.Clean(name)
+	fsn, err := mfs.Lookup(fs.root, name)
+	if err != nil {
+		return nil, err
+	}
+	return statMFSNode(gopath.Base(name), fsn)
+}
+
+func statMFSNode(name string, fsn mfs.FSNode) (*mfsFileInfo, error) {
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	fi := &mfsFileInfo{name: name, cid: nd.Cid().String()}
+	switch fsn := fsn.(type) {
+	case *mfs.Directory:
+		fi.dir = true
+	case *mfs.File:
+		if fi.size, err = fsn.Size(); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+// mfsFileInfo is the os.FileInfo of MFS files and directories. It also is a
+// webdav.ETager, their ETag being their CID.
+type mfsFileInfo struct {
+	name string
+	size int64
+	dir  bool
+	cid  string
+}
+
+func (fi *mfsFileInfo) Name() string     { return fi.name }
+func (fi *mfsFileInfo) Size() int64      { return fi.size }
+func (fi *mfsFileInfo) IsDir() bool      { return fi.dir }
+func (fi *mfsFileInfo) Sys() interface{} { return nil }
+
+// ModTime is unknown, MFS does not keep modification times.
+func (fi *mfsFileInfo) ModTime() time.Time { return time.Time{} }
+
+func (fi *mfsFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi *mfsFileInfo) ETag(ctx context.Context) (string, error) {
+	return `"` + fi.cid + `"`, nil
+}
+
+// mfsFile is an open MFS file.
+type mfsFile struct {
+	name string
+	file *mfs.File
+	fd   mfs.FileDescriptor
+}
+
+func (f *mfsFile) Read(p []byte) (int, error)                   { return f.fd.Read(p) }
+func (f *mfsFile) Write(p []byte) (int, error)                  { return f.fd.Write(p) }
+func (f *mfsFile) Seek(offset int64, whence int) (int64, error) { return f.fd.Seek(offset, whence) }
+
+// Close flushes the changes made to the file up to the MFS root.
+func (f *mfsFile) Close() error { return f.fd.Close() }
+
+func (f *mfsFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", f.name)
+}
+
+func (f *mfsFile) Stat() (os.FileInfo, error) {
+	if err := f.fd.Flush(); err != nil {
+		return nil, err
+	}
+	return statMFSNode(gopath.Base(f.name), f.file)
+}
+
+// mfsDirectory is an open MFS directory.
+type mfsDirectory struct {
+	ctx  context.Context
+	name string
+	dir  *mfs.Directory
+	// entries are the entries not returned by Readdir yet, listed on its
+	// first call.
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (d *mfsDirectory) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("%s is a directory", d.name)
+}
+
+func (d *mfsDirectory) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("%s is a directory", d.name)
+}
+
+func (d *mfsDirectory) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("%s is a directory", d.name)
+}
+
+func (d *mfsDirectory) Close() error { return nil }
+
+func (d *mfsDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		// Listings hold the CID and size of the entries, so they do not
+		// need to be loaded.
+		listing, err := d.dir.List(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range listing {
+			d.entries = append(d.entries, &mfsFileInfo{
+				name: l.Name,
+				size: l.Size,
+				dir:  l.Type == int(mfs.TDir),
+				cid:  l.Hash,
+			})
+		}
+		d.listed = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *mfsDirectory) Stat() (os.FileInfo, error) {
+	return statMFSNode(gopath.Base(d.name), d.dir)
+}
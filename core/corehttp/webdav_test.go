@@ -0,0 +1,167 @@
+package corehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-mfs"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	repo "github.com/ipfs/kubo/repo"
+)
+
+const testWebDAVToken = "secret"
+
+func newWebDAVTestServer(t *testing.T) (*httptest.Server, *core.IpfsNode) {
+	c := config.Config{
+		Identity: config.Identity{
+			PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+		},
+	}
+	c.WebDAV.AccessTokens = []string{testWebDAVToken}
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+
+	dh.Handler, err = makeHandler(n, ts.Listener, WebDAVOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, n
+}
+
+func doWebDAV(t *testing.T, ts *httptest.Server, method, path, body string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("user", testWebDAVToken)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+func TestWebDAV(t *testing.T) {
+	ts, n := newWebDAVTestServer(t)
+
+	expectStatus := func(res *http.Response, body string, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s %s: expected %d, got %d: %s", res.Request.Method, res.Request.URL.Path, status, res.StatusCode, body)
+		}
+	}
+
+	res, body := doWebDAV(t, ts, "MKCOL", "/docs", "", nil)
+	expectStatus(res, body, http.StatusCreated)
+	res, body = doWebDAV(t, ts, "MKCOL", "/missing/docs", "", nil)
+	expectStatus(res, body, http.StatusConflict)
+
+	res, body = doWebDAV(t, ts, http.MethodPut, "/docs/hello.txt", "hello world", nil)
+	expectStatus(res, body, http.StatusCreated)
+
+	// The file is in MFS, and its ETag is its CID.
+	fsn, err := mfs.Lookup(n.FilesRoot, "/docs/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + nd.Cid().String() + `"`
+	if got := res.Header.Get("ETag"); got != etag {
+		t.Errorf("expected ETag %s, got %s", etag, got)
+	}
+
+	res, body = doWebDAV(t, ts, http.MethodGet, "/docs/hello.txt", "", nil)
+	expectStatus(res, body, http.StatusOK)
+	if body != "hello world" || res.Header.Get("ETag") != etag {
+		t.Errorf("unexpected GET response %q, ETag %s", body, res.Header.Get("ETag"))
+	}
+
+	res, body = doWebDAV(t, ts, "PROPFIND", "/docs/", "", map[string]string{"Depth": "1"})
+	expectStatus(res, body, http.StatusMultiStatus)
+	if !strings.Contains(body, "/docs/hello.txt") || !strings.Contains(body, etag) {
+		t.Errorf("unexpected PROPFIND response %s", body)
+	}
+
+	res, body = doWebDAV(t, ts, "COPY", "/docs/hello.txt", "", map[string]string{"Destination": ts.URL + "/copy.txt"})
+	expectStatus(res, body, http.StatusCreated)
+	res, body = doWebDAV(t, ts, "MOVE", "/docs/hello.txt", "", map[string]string{"Destination": ts.URL + "/docs/moved.txt"})
+	expectStatus(res, body, http.StatusCreated)
+	res, body = doWebDAV(t, ts, http.MethodGet, "/docs/moved.txt", "", nil)
+	expectStatus(res, body, http.StatusOK)
+	res, body = doWebDAV(t, ts, http.MethodGet, "/copy.txt", "", nil)
+	expectStatus(res, body, http.StatusOK)
+	if body != "hello world" {
+		t.Errorf("unexpected copy content %q", body)
+	}
+
+	res, body = doWebDAV(t, ts, http.MethodDelete, "/docs", "", nil)
+	expectStatus(res, body, http.StatusNoContent)
+	if _, err := mfs.Lookup(n.FilesRoot, "/docs"); err == nil {
+		t.Error("/docs was not removed from MFS")
+	}
+
+	// Locked files can only be changed with the lock token.
+	lockBody := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	res, body = doWebDAV(t, ts, "LOCK", "/copy.txt", lockBody, nil)
+	expectStatus(res, body, http.StatusOK)
+	lockToken := res.Header.Get("Lock-Token")
+	res, body = doWebDAV(t, ts, http.MethodPut, "/copy.txt", "changed", nil)
+	expectStatus(res, body, http.StatusLocked)
+	res, body = doWebDAV(t, ts, http.MethodPut, "/copy.txt", "changed", map[string]string{"If": "(" + lockToken + ")"})
+	expectStatus(res, body, http.StatusCreated)
+
+	// Requests need a token.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/copy.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("user", "wrong")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a wrong token, got %d", res.StatusCode)
+	}
+	req.Header.Del("Authorization")
+	req.Header.Set("Authorization", "Bearer "+testWebDAVToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with a bearer token, got %d", res.StatusCode)
+	}
+}
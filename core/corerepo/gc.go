@@ -21,12 +21,13 @@ var log = logging.Logger("corerepo")
 var ErrMaxStorageExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
 
 type GC struct {
-	Node       *core.IpfsNode
-	Repo       repo.Repo
-	StorageMax uint64
-	StorageGC  uint64
-	SlackGB    uint64
-	Storage    uint64
+	Node        *core.IpfsNode
+	Repo        repo.Repo
+	StorageMax  uint64
+	StorageGC   uint64
+	SlackGB     uint64
+	Storage     uint64
+	Incremental bool
 }
 
 func NewGC(n *core.IpfsNode) (*GC, error) {
@@ -66,11 +67,12 @@ func NewGC(n *core.IpfsNode) (*GC, error) {
 	}
 
 	return &GC{
-		Node:       n,
-		Repo:       r,
-		StorageMax: storageMax,
-		StorageGC:  storageGC,
-		SlackGB:    slackGB,
+		Node:        n,
+		Repo:        r,
+		StorageMax:  storageMax,
+		StorageGC:   storageGC,
+		SlackGB:     slackGB,
+		Incremental: cfg.Datastore.GCIncremental.WithDefault(false),
 	}, nil
 }
 
@@ -93,10 +95,28 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	return CollectResult(ctx, rmed, nil)
 }
 
+// IncrementalGarbageCollect runs an incremental garbage collection and logs
+// the progress of each phase.
+func IncrementalGarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+	rmed := IncrementalGarbageCollectAsync(n, ctx)
+
+	return CollectResultWithProgress(ctx, rmed, nil, func(p *gc.Progress) {
+		if p.Done {
+			log.Infof("GC %s phase done: %d marked, %d written, %d candidates, %d removed", p.Phase, p.Marked, p.Written, p.Candidates, p.Removed)
+		}
+	})
+}
+
 // CollectResult collects the output of a garbage collection run and calls the
 // given callback for each object removed.  It also collects all errors into a
 // MultiError which is returned after the gc is completed.
 func CollectResult(ctx context.Context, gcOut <-chan gc.Result, cb func(cid.Cid)) error {
+	return CollectResultWithProgress(ctx, gcOut, cb, nil)
+}
+
+// CollectResultWithProgress is like CollectResult, but additionally calls
+// progressCb for every progress report of an incremental garbage collection.
+func CollectResultWithProgress(ctx context.Context, gcOut <-chan gc.Result, cb func(cid.Cid), progressCb func(*gc.Progress)) error {
 	var errors []error
 loop:
 	for {
@@ -107,6 +127,10 @@ loop:
 			}
 			if res.Error != nil {
 				errors = append(errors, res.Error)
+			} else if res.Progress != nil {
+				if progressCb != nil {
+					progressCb(res.Progress)
+				}
 			} else if res.KeyRemoved.Defined() && cb != nil {
 				cb(res.KeyRemoved)
 			}
@@ -159,6 +183,30 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result
 	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
 }
 
+// DryRunGarbageCollectAsync reports what a garbage collection would remove
+// without removing anything. See gc.DryRun.
+func DryRunGarbageCollectAsync(n *core.IpfsNode, ctx context.Context, topRoots int) <-chan gc.Result {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: err}
+		close(out)
+		return out
+	}
+
+	return gc.DryRun(ctx, n.Blockstore, n.Pinning, roots, topRoots)
+}
+
+// IncrementalGarbageCollectAsync starts an incremental garbage collection,
+// which only holds the GC lock while sweeping. See gc.IncrementalGC.
+func IncrementalGarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	roots := func() ([]cid.Cid, error) {
+		return BestEffortRoots(n.FilesRoot)
+	}
+
+	return gc.IncrementalGC(ctx, n.Blockstore, n.GCBarrier, n.Repo.Datastore(), n.Pinning, roots, gc.IncrementalOptions{})
+}
+
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 	cfg, err := node.Repo.Config()
 	if err != nil {
@@ -218,7 +266,11 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 		// Do GC here
 		log.Info("Watermark exceeded. Starting repo GC...")
 
-		if err := GarbageCollect(gc.Node, ctx); err != nil {
+		collect := GarbageCollect
+		if gc.Incremental {
+			collect = IncrementalGarbageCollect
+		}
+		if err := collect(gc.Node, ctx); err != nil {
 			return err
 		}
 		log.Infof("Repo GC done. See `ipfs repo stat` to see how much space got freed.\n")
@@ -0,0 +1,147 @@
+package coreunix
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfsnode/data"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	path "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// ZipAPI is the part of the CoreAPI needed to write ZIP archives.
+type ZipAPI interface {
+	Unixfs() coreiface.UnixfsAPI
+	Block() coreiface.BlockAPI
+}
+
+// WriteZip writes the UnixFS file or directory c as a ZIP archive to w, with
+// a top-level entry named name.
+//
+// The archive is streamed: sizes are written after the content of each file,
+// and ZIP64 records are used when the archive outgrows the 4GiB limits of
+// ZIP. Names are stored as UTF-8, and modification times are taken from the
+// UnixFS metadata where present.
+func WriteZip(ctx context.Context, api ZipAPI, c cid.Cid, name string, w io.Writer) error {
+	zw := &zipWriter{
+		ctx: ctx,
+		api: api,
+		zw:  zip.NewWriter(w),
+		now: time.Now().Truncate(time.Second),
+	}
+	if err := zw.writeNode(c, name); err != nil {
+		return err
+	}
+	return zw.zw.Close()
+}
+
+type zipWriter struct {
+	ctx context.Context
+	api ZipAPI
+	zw  *zip.Writer
+	// now is the modification time of the entries without one
+	now time.Time
+}
+
+func (w *zipWriter) writeNode(c cid.Cid, name string) error {
+	p := path.IpfsPath(c)
+	nd, err := w.api.Unixfs().Get(w.ctx, p)
+	if err != nil {
+		return err
+	}
+	defer nd.Close()
+
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: w.mtime(p),
+	}
+
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		hdr.Method = zip.Store
+		hdr.SetMode(os.ModeSymlink | 0o777)
+		fw, err := w.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, nd.Target)
+		return err
+
+	case files.File:
+		hdr.Method = zip.Deflate
+		hdr.SetMode(0o644)
+		fw, err := w.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, nd)
+		return err
+
+	case files.Directory:
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		hdr.SetMode(os.ModeDir | 0o755)
+		if _, err := w.zw.CreateHeader(hdr); err != nil {
+			return err
+		}
+
+		entries, err := w.api.Unixfs().Ls(w.ctx, p, options.Unixfs.ResolveChildren(false))
+		if err != nil {
+			return err
+		}
+		for e := range entries {
+			if e.Err != nil {
+				return e.Err
+			}
+			if e.Name == "" || e.Name == "." || e.Name == ".." || strings.Contains(e.Name, "/") {
+				return fmt.Errorf("invalid file name %q in %s", e.Name, name)
+			}
+			if err := w.writeNode(e.Cid, name+"/"+e.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported file type %T for %s", nd, name)
+	}
+}
+
+// mtime returns the modification time from the UnixFS metadata of p, or the
+// time the archive was started when there is none.
+func (w *zipWriter) mtime(p path.Resolved) time.Time {
+	if p.Cid().Prefix().Codec != cid.DagProtobuf {
+		return w.now
+	}
+	r, err := w.api.Block().Get(w.ctx, p)
+	if err != nil {
+		return w.now
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return w.now
+	}
+	pn, err := dag.DecodeProtobuf(raw)
+	if err != nil {
+		return w.now
+	}
+	fsData, err := data.DecodeUnixFSData(pn.Data())
+	if err != nil || !fsData.FieldMtime().Exists() {
+		return w.now
+	}
+	mtime := fsData.FieldMtime().Must()
+	var nsecs int64
+	if fns := mtime.FieldFractionalNanoseconds(); fns.Exists() {
+		nsecs = fns.Must().Int()
+	}
+	return time.Unix(mtime.FieldSeconds().Int(), nsecs)
+}
@@ -26,6 +26,8 @@ import (
 	"go.uber.org/fx"
 
 	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/remotepin"
 	"github.com/ipfs/kubo/repo"
 )
 
@@ -42,8 +44,9 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 	return bsvc
 }
 
-// Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+// Pinning creates new pinner which tells GC which blocks should be kept. The
+// pinner keeps the metadata of pins, like their expiry, in sync.
+func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, *pinmeta.Pinner, error) {
 	rootDS := repo.Datastore()
 
 	syncFn := func(ctx context.Context) error {
@@ -58,10 +61,17 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 
 	pinning, err := dspinner.New(ctx, rootDS, syncDs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return pinning, nil
+	metaPinning := pinmeta.NewPinner(pinning, pinmeta.NewStore(rootDS), syncDs)
+	return metaPinning, metaPinning, nil
+}
+
+// RemotePinQueue creates the queue of requests to remote pinning services,
+// persisted in the repo datastore.
+func RemotePinQueue(repo repo.Repo) *remotepin.Queue {
+	return remotepin.NewQueue(repo.Datastore(), repo.Config)
 }
 
 var (
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"path/filepath"
+
+	"github.com/ipfs/go-bitswap"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/denylist"
+	"github.com/ipfs/kubo/repo"
+)
+
+// DenylistCtor loads the denylist files, and reloads them while the node
+// runs.
+func DenylistCtor(cfg config.Denylist) interface{} {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.Repo) (*denylist.Denylist, error) {
+		files := make([]string, len(cfg.Files))
+		for i, f := range cfg.Files {
+			if pr, ok := r.(interface{ Path() string }); ok && !filepath.IsAbs(f) {
+				f = filepath.Join(pr.Path(), f)
+			}
+			files[i] = f
+		}
+
+		d, err := denylist.New(files...)
+		if err != nil {
+			return nil, err
+		}
+		go d.Run(helpers.LifecycleCtx(mctx, lc), cfg.ReloadInterval.WithDefault(config.DefaultDenylistReloadInterval))
+		return d, nil
+	}
+}
+
+// DenylistBitswapOptions makes bitswap refuse to send blocked blocks to other
+// peers.
+func DenylistBitswapOptions(d *denylist.Denylist) bitswapOptionsOut {
+	return bitswapOptionsOut{BitswapOpts: []bitswap.Option{
+		bitswap.WithPeerBlockRequestFilter(func(_ peer.ID, c cid.Cid) bool {
+			return d.CheckCid(denylist.SourceBitswap, c) == nil
+		}),
+	}}
+}
@@ -183,6 +183,7 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
+		fx.Provide(GCWriteBarrier),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
 	)
@@ -312,9 +313,22 @@ var Core = fx.Options(
 	fx.Provide(Dag),
 	fx.Provide(FetcherConfig),
 	fx.Provide(Pinning),
+	fx.Provide(RemotePinQueue),
 	fx.Provide(Files),
 )
 
+// ContentBlocking groups the units refusing to serve the content listed in
+// denylists
+func ContentBlocking(cfg *config.Config) fx.Option {
+	if len(cfg.Denylist.Files) == 0 {
+		return fx.Options()
+	}
+	return fx.Options(
+		fx.Provide(DenylistCtor(cfg.Denylist)),
+		maybeProvide(DenylistBitswapOptions, cfg.Denylist.Bitswap.WithDefault(false)),
+	)
+}
+
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	if bcfg.Online {
 		return Online(bcfg, cfg)
@@ -359,5 +373,6 @@ func IPFS(ctx context.Context, bcfg *BuildCfg) fx.Option {
 		Networked(bcfg, cfg),
 
 		Core,
+		ContentBlocking(cfg),
 	)
 }
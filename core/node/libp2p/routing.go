@@ -108,7 +108,7 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 
 			return processInitialRoutingOut{
 				Router: Router{
-					Routing:  expClient,
+					Routing:  irouting.InstrumentProvides("accelerated-dht", expClient),
 					Priority: 1000,
 				},
 				DHT:           dr,
@@ -117,10 +117,17 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 			}, nil
 		}
 
+		// Routers created from Routing.Routers are instrumented by name
+		// when parsed.
+		router := in.Router
+		if dr != nil {
+			router = irouting.InstrumentProvides("dht", dr)
+		}
+
 		return processInitialRoutingOut{
 			Router: Router{
 				Priority: 1000,
-				Routing:  in.Router,
+				Routing:  router,
 			},
 			DHT:           dr,
 			DHTClient:     dr,
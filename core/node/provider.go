@@ -3,18 +3,26 @@ package node
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-fetcher"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	provider "github.com/ipfs/go-ipfs-provider"
 	"github.com/ipfs/go-ipfs-provider/batched"
 	q "github.com/ipfs/go-ipfs-provider/queue"
 	"github.com/ipfs/go-ipfs-provider/simple"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/reprovider"
 	irouting "github.com/ipfs/kubo/routing"
 )
 
@@ -32,10 +40,11 @@ func SimpleProvider(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *q.Queue, rt
 	return simple.NewProvider(helpers.LifecycleCtx(mctx, lc), queue, rt)
 }
 
-// SimpleReprovider creates new reprovider
+// SimpleReprovider creates new reprovider, resuming its cycles after restarts
 func SimpleReprovider(reproviderInterval time.Duration) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt irouting.ProvideManyRouter, keyProvider simple.KeyChanFunc) (provider.Reprovider, error) {
-		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, rt, keyProvider), nil
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt irouting.ProvideManyRouter, keyProvider simple.KeyChanFunc, repo repo.Repo) (provider.Reprovider, *reprovider.Reprovider, error) {
+		rp := reprovider.New(helpers.LifecycleCtx(mctx, lc), reproviderInterval, rt, keyProvider, repo.Datastore())
+		return rp, rp, nil
 	}
 }
 
@@ -148,7 +157,11 @@ func SimpleProviders(reprovideStrategy string, reprovideInterval string) fx.Opti
 	case "pinned":
 		keyProvider = fx.Provide(pinnedProviderStrategy(false))
 	default:
-		return fx.Error(fmt.Errorf("unknown reprovider strategy '%s'", reprovideStrategy))
+		strategies, err := parseProviderStrategies(reprovideStrategy)
+		if err != nil {
+			return fx.Error(err)
+		}
+		keyProvider = fx.Provide(compositeProviderStrategy(strategies))
 	}
 
 	return fx.Options(
@@ -169,3 +182,117 @@ func pinnedProviderStrategy(onlyRoots bool) interface{} {
 		return simple.NewPinnedProvider(onlyRoots, in.Pinner, in.IPLDFetcher)
 	}
 }
+
+// parseProviderStrategies parses strategies combining "roots", "pinned" and
+// "mfs" with "+", like "pinned+mfs".
+func parseProviderStrategies(reprovideStrategy string) ([]string, error) {
+	strategies := strings.Split(reprovideStrategy, "+")
+	seen := make(map[string]bool, len(strategies))
+	for _, s := range strategies {
+		switch s {
+		case "roots", "pinned", "mfs":
+		default:
+			return nil, fmt.Errorf("unknown reprovider strategy '%s'", reprovideStrategy)
+		}
+		if seen[s] {
+			return nil, fmt.Errorf("reprovider strategy '%s' lists '%s' twice", reprovideStrategy, s)
+		}
+		seen[s] = true
+	}
+	return strategies, nil
+}
+
+func compositeProviderStrategy(strategies []string) interface{} {
+	type input struct {
+		fx.In
+		Pinner      pin.Pinner
+		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+		FilesRoot   *mfs.Root
+		Blockstore  blockstore.Blockstore
+	}
+	return func(in input) simple.KeyChanFunc {
+		return newCompositeProvider(strategies, in.Pinner, in.IPLDFetcher, in.FilesRoot, in.Blockstore)
+	}
+}
+
+// newCompositeProvider streams the keys of each of the strategies, in order,
+// skipping the keys already streamed by the previous ones.
+func newCompositeProvider(strategies []string, pinning pin.Pinner, fetchConfig fetcher.Factory, root *mfs.Root, bs blockstore.Blockstore) simple.KeyChanFunc {
+	providers := make([]simple.KeyChanFunc, 0, len(strategies))
+	for _, s := range strategies {
+		switch s {
+		case "roots":
+			providers = append(providers, simple.NewPinnedProvider(true, pinning, fetchConfig))
+		case "pinned":
+			providers = append(providers, simple.NewPinnedProvider(false, pinning, fetchConfig))
+		case "mfs":
+			providers = append(providers, newMFSProvider(root, bs))
+		}
+	}
+	if len(providers) == 1 {
+		return providers[0]
+	}
+
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			set := cid.NewSet()
+			for i, p := range providers {
+				keys, err := p(ctx)
+				if err != nil {
+					logger.Errorf("reprovide %s: %s", strategies[i], err)
+					continue
+				}
+				for c := range keys {
+					if !set.Visit(c) {
+						continue
+					}
+					select {
+					case outCh <- c:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+		return outCh, nil
+	}
+}
+
+// newMFSProvider streams the keys of the MFS tree (the 'ipfs files' root).
+// Content copied into MFS is only fetched when read, so only the blocks
+// stored locally are provided, and the tree is walked without fetching.
+func newMFSProvider(root *mfs.Root, bs blockstore.Blockstore) simple.KeyChanFunc {
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		nd, err := root.GetDirectory().GetNode()
+		if err != nil {
+			return nil, err
+		}
+
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			set := cid.NewSet()
+			visit := func(c cid.Cid) bool {
+				if !set.Visit(c) {
+					return false
+				}
+				if has, err := bs.Has(ctx, c); err != nil || !has {
+					return false
+				}
+				select {
+				case outCh <- c:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+			if err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(dag), nd.Cid(), visit); err != nil {
+				logger.Errorf("reprovide mfs: %s", err)
+			}
+		}()
+		return outCh, nil
+	}
+}
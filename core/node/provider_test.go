@@ -0,0 +1,144 @@
+package node
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestProviderStrategies(t *testing.T) {
+	ctx := context.Background()
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	dag := merkledag.NewDAGService(bserv)
+
+	add := func(nd ipld.Node) {
+		t.Helper()
+		if err := dag.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pinned content
+	pinnedLeaf := merkledag.NewRawNode([]byte("pinned leaf"))
+	sharedLeaf := merkledag.NewRawNode([]byte("shared leaf"))
+	pinnedRoot := merkledag.NodeWithData([]byte("pinned root"))
+	for _, l := range []*merkledag.RawNode{pinnedLeaf, sharedLeaf} {
+		add(l)
+		if err := pinnedRoot.AddNodeLink(l.Cid().String(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(pinnedRoot)
+	add(merkledag.NewRawNode([]byte("unpinned")))
+
+	pinner, err := dspinner.New(ctx, ds, dag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, pinnedRoot, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// MFS content, with a file copied in MFS but not stored locally
+	root, err := mfs.NewRoot(ctx, dag, ft.EmptyDirNode(), func(context.Context, cid.Cid) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir(root, "/dir", mfs.MkdirOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	mfsLeaf := merkledag.NewRawNode([]byte("mfs leaf"))
+	missingLeaf := merkledag.NewRawNode([]byte("not stored"))
+	for p, nd := range map[string]*merkledag.RawNode{
+		"/dir/file":    mfsLeaf,
+		"/dir/shared":  sharedLeaf,
+		"/dir/missing": missingLeaf,
+	} {
+		if err := mfs.PutNode(root, p, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mfs.FlushPath(ctx, root, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.DeleteBlock(ctx, missingLeaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	mfsRoot, err := root.GetDirectory().GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirNode, err := mfs.Lookup(root, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfsDir, err := dirNode.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchConfig := FetcherConfig(bserv).IPLDFetcher
+
+	mfsKeys := []cid.Cid{mfsRoot.Cid(), mfsDir.Cid(), mfsLeaf.Cid(), sharedLeaf.Cid()}
+	for strategy, expected := range map[string][]cid.Cid{
+		"roots":        {pinnedRoot.Cid()},
+		"pinned":       {pinnedRoot.Cid(), pinnedLeaf.Cid(), sharedLeaf.Cid()},
+		"mfs":          mfsKeys,
+		"roots+mfs":    append([]cid.Cid{pinnedRoot.Cid()}, mfsKeys...),
+		"pinned+mfs":   append([]cid.Cid{pinnedRoot.Cid(), pinnedLeaf.Cid()}, mfsKeys...),
+		"mfs+pinned":   append([]cid.Cid{pinnedRoot.Cid(), pinnedLeaf.Cid()}, mfsKeys...),
+		"roots+pinned": {pinnedRoot.Cid(), pinnedLeaf.Cid(), sharedLeaf.Cid()},
+	} {
+		strategies, err := parseProviderStrategies(strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := newCompositeProvider(strategies, pinner, fetchConfig, root, bs)(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for c := range keys {
+			got = append(got, c.String())
+		}
+		var want []string
+		for _, c := range expected {
+			want = append(want, c.String())
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %v, got %v", strategy, want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected %v, got %v", strategy, want, got)
+				break
+			}
+		}
+	}
+
+	for _, strategy := range []string{"all+mfs", "mfs+mfs", "files", "pinned+"} {
+		if _, err := parseProviderStrategies(strategy); err == nil {
+			t.Errorf("expected strategy %q to be refused", strategy)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/ipfs/go-filestore"
 	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/gc"
 	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/thirdparty/verifbs"
 )
@@ -25,9 +26,15 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
+// GCWriteBarrier provides the barrier recording blocks written during an
+// incremental garbage collection
+func GCWriteBarrier() *gc.WriteBarrier {
+	return gc.NewWriteBarrier()
+}
+
 // BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, wb *gc.WriteBarrier) (bs BaseBlocks, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, wb *gc.WriteBarrier) (bs BaseBlocks, err error) {
 		// hash security
 		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
@@ -39,6 +46,8 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 			}
 		}
 
+		// record writes above the cache, which drops puts of blocks it already has
+		bs = wb.Blockstore(bs)
 		bs = blockstore.NewIdStore(bs)
 
 		if hashOnRead { // TODO: review: this is how it was done originally, is there a reason we can't just pass this directly?
@@ -59,12 +68,13 @@ func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockst
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks, wb *gc.WriteBarrier) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
-	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
+	// blocks added with --nocopy bypass bb, so record them here as well
+	gcbs = blockstore.NewGCBlockstore(wb.Blockstore(fstore), gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
 
 	bs = gcbs
@@ -0,0 +1,309 @@
+// Package denylist blocks content listed in denylist files, which are
+// reloaded when they change.
+//
+// Each line of a denylist file is one of:
+//
+//	/ipfs/<cid>            blocks a CID, and every path below it
+//	/ipfs/<cid>/<path>     blocks a path below a CID, and every path below it
+//	/ipns/<name>[/<path>]  blocks an IPNS name or DNSLink, or a path below it
+//	//<sha256>             blocks a double-hashed entry
+//
+// Double-hashed entries are the hex encoded SHA-256 hash of
+// "<cid>/<path>", where <cid> is the CIDv1 in base32 (or the IPNS name) and
+// <path> has no leading slash. The entry for a whole CID hashes "<cid>/".
+// They allow sharing denylists without listing the content they block.
+//
+// Empty lines, and lines starting with '#', are ignored.
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	gopath "path"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var log = logging.Logger("denylist")
+
+// ErrBlocked is returned for content blocked by a denylist.
+var ErrBlocked = errors.New("content is blocked by the denylist")
+
+// Sources of the blocked requests, as reported in the metrics.
+const (
+	SourceGateway  = "gateway"
+	SourceBitswap  = "bitswap"
+	SourceCommands = "commands"
+)
+
+var blockedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ipfs",
+	Subsystem: "denylist",
+	Name:      "blocked_requests_total",
+	Help:      "Number of requests refused because of the denylist, by source.",
+}, []string{"source"})
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+type entries struct {
+	cids   map[string][]string // by multihash, blocked paths ("" for all)
+	names  map[string][]string // by IPNS name, blocked paths ("" for all)
+	hashes map[string]struct{}
+}
+
+// Denylist is a set of blocked CIDs, paths and IPNS names, read from files.
+// A nil Denylist blocks nothing.
+type Denylist struct {
+	files []string
+
+	mu      sync.RWMutex
+	stats   []fileStat
+	entries entries
+}
+
+// New reads a Denylist from files.
+func New(files ...string) (*Denylist, error) {
+	d := &Denylist{files: files}
+	if _, err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reads the files again if any of them changed, and returns whether
+// they did. The denylist is left as it was when a file cannot be read.
+func (d *Denylist) Reload() (bool, error) {
+	stats := make([]fileStat, len(d.files))
+	for i, f := range d.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		stats[i] = fileStat{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	d.mu.RLock()
+	changed := len(d.stats) != len(stats)
+	for i := 0; !changed && i < len(stats); i++ {
+		changed = d.stats[i] != stats[i]
+	}
+	d.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	e := entries{
+		cids:   make(map[string][]string),
+		names:  make(map[string][]string),
+		hashes: make(map[string]struct{}),
+	}
+	for _, f := range d.files {
+		if err := e.readFile(f); err != nil {
+			return false, err
+		}
+	}
+
+	d.mu.Lock()
+	d.stats = stats
+	d.entries = e
+	d.mu.Unlock()
+	return true, nil
+}
+
+// Run reloads the denylist every interval until ctx is done.
+func (d *Denylist) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := d.Reload()
+			if err != nil {
+				log.Errorf("reloading denylist: %s", err)
+			} else if reloaded {
+				log.Info("denylist reloaded")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *entries) readFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		if err := e.add(s.Text()); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+	}
+	return s.Err()
+}
+
+func (e *entries) add(line string) error {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return nil
+
+	case strings.HasPrefix(line, "//"):
+		h := strings.ToLower(line[2:])
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid double-hashed entry %q", line)
+		}
+		e.hashes[h] = struct{}{}
+		return nil
+	}
+
+	ns, root, rest := splitPath(line)
+	switch ns {
+	case "ipfs":
+		c, err := cid.Decode(root)
+		if err != nil {
+			return fmt.Errorf("invalid CID in %q: %w", line, err)
+		}
+		k := string(c.Hash())
+		e.cids[k] = append(e.cids[k], rest)
+	case "ipns":
+		if root == "" {
+			return fmt.Errorf("missing name in %q", line)
+		}
+		e.names[root] = append(e.names[root], rest)
+	default:
+		return fmt.Errorf("invalid entry %q", line)
+	}
+	return nil
+}
+
+// splitPath splits "/<ns>/<root>/<rest>" into its parts, with rest cleaned
+// and without leading slash.
+func splitPath(p string) (ns, root, rest string) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 {
+		return "", "", ""
+	}
+	ns, root = parts[0], parts[1]
+	if len(parts) == 3 {
+		rest = strings.TrimPrefix(gopath.Clean("/"+parts[2]), "/")
+	}
+	return ns, root, rest
+}
+
+// hashEntry returns the double-hashed entry of a path below root.
+func hashEntry(root, rest string) string {
+	h := sha256.Sum256([]byte(root + "/" + rest))
+	return hex.EncodeToString(h[:])
+}
+
+// blocks returns whether one of the blocked paths covers rest, or one of the
+// double-hashed entries covers a path below root.
+func (e *entries) blocks(root string, paths []string, rest string) bool {
+	for _, p := range paths {
+		if p == "" || rest == p || strings.HasPrefix(rest, p+"/") {
+			return true
+		}
+	}
+	if len(e.hashes) == 0 {
+		return false
+	}
+	if _, ok := e.hashes[hashEntry(root, "")]; ok {
+		return true
+	}
+	for i := 0; i < len(rest); i++ {
+		if rest[i] != '/' {
+			continue
+		}
+		if _, ok := e.hashes[hashEntry(root, rest[:i])]; ok {
+			return true
+		}
+	}
+	if rest != "" {
+		if _, ok := e.hashes[hashEntry(root, rest)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPathBlocked returns whether a content path, like "/ipfs/<cid>/a/b" or
+// "/ipns/<name>/a", is blocked.
+func (d *Denylist) IsPathBlocked(p string) bool {
+	if d == nil {
+		return false
+	}
+	ns, root, rest := splitPath(p)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	switch ns {
+	case "ipfs":
+		c, err := cid.Decode(root)
+		if err != nil {
+			return false
+		}
+		return d.entries.blocks(cidV1(c), d.entries.cids[string(c.Hash())], rest)
+	case "ipns":
+		return d.entries.blocks(root, d.entries.names[root], rest)
+	}
+	return false
+}
+
+// IsCidBlocked returns whether a whole CID is blocked.
+func (d *Denylist) IsCidBlocked(c cid.Cid) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, p := range d.entries.cids[string(c.Hash())] {
+		if p == "" {
+			return true
+		}
+	}
+	_, ok := d.entries.hashes[hashEntry(cidV1(c), "")]
+	return ok
+}
+
+// CheckPath returns ErrBlocked if the content path p is blocked, and counts
+// the request as blocked for source.
+func (d *Denylist) CheckPath(source, p string) error {
+	if d.IsPathBlocked(p) {
+		blockedRequests.WithLabelValues(source).Inc()
+		return ErrBlocked
+	}
+	return nil
+}
+
+// CheckCid returns ErrBlocked if c is blocked, and counts the request as
+// blocked for source.
+func (d *Denylist) CheckCid(source string, c cid.Cid) error {
+	if d.IsCidBlocked(c) {
+		blockedRequests.WithLabelValues(source).Inc()
+		return ErrBlocked
+	}
+	return nil
+}
+
+func cidV1(c cid.Cid) string {
+	return cid.NewCidV1(c.Type(), c.Hash()).String()
+}
@@ -0,0 +1,116 @@
+package denylist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func writeDenylist(t *testing.T, name, content string) {
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	blocked := dag.NodeWithData([]byte("blocked")).Cid()
+	partly := dag.NodeWithData([]byte("partly")).Cid()
+	hashed := dag.NodeWithData([]byte("hashed")).Cid()
+	other := dag.NodeWithData([]byte("other")).Cid()
+
+	f := filepath.Join(t.TempDir(), "denylist")
+	writeDenylist(t, f, `# blocked on legal request
+/ipfs/`+blocked.String()+`
+/ipfs/`+partly.String()+`/secret/dir
+/ipns/example.com/private
+
+//`+hashEntry(cidV1(hashed), "")+`
+//`+hashEntry("hashed.example.com", "a/b")+`
+`)
+
+	d, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path    string
+		blocked bool
+	}{
+		{"/ipfs/" + blocked.String(), true},
+		{"/ipfs/" + blocked.String() + "/any/path", true},
+		{"/ipfs/" + cid.NewCidV1(cid.DagProtobuf, blocked.Hash()).String(), true},
+		{"/ipfs/" + partly.String(), false},
+		{"/ipfs/" + partly.String() + "/secret", false},
+		{"/ipfs/" + partly.String() + "/secret/dir", true},
+		{"/ipfs/" + partly.String() + "/secret/dir/file", true},
+		{"/ipfs/" + partly.String() + "/secret/dir2", false},
+		{"/ipfs/" + partly.String() + "/secret/../secret/dir", true},
+		{"/ipfs/" + hashed.String() + "/file", true},
+		{"/ipfs/" + other.String(), false},
+		{"/ipns/example.com", false},
+		{"/ipns/example.com/private/file", true},
+		{"/ipns/hashed.example.com/a", false},
+		{"/ipns/hashed.example.com/a/b/c", true},
+	} {
+		if got := d.IsPathBlocked(tc.path); got != tc.blocked {
+			t.Errorf("%s: expected blocked=%v, got %v", tc.path, tc.blocked, got)
+		}
+	}
+
+	if !d.IsCidBlocked(blocked) || !d.IsCidBlocked(hashed) {
+		t.Error("expected the blocked CIDs to be blocked")
+	}
+	if d.IsCidBlocked(partly) || d.IsCidBlocked(other) {
+		t.Error("expected CIDs with blocked paths only to be allowed")
+	}
+	if err := d.CheckCid(SourceGateway, blocked); err != ErrBlocked {
+		t.Errorf("expected ErrBlocked, got %v", err)
+	}
+
+	var nilList *Denylist
+	if nilList.IsCidBlocked(blocked) || nilList.CheckPath(SourceGateway, "/ipfs/"+blocked.String()) != nil {
+		t.Error("expected a nil denylist to block nothing")
+	}
+}
+
+func TestDenylistReload(t *testing.T) {
+	c := dag.NodeWithData([]byte("c")).Cid()
+	f := filepath.Join(t.TempDir(), "denylist")
+	writeDenylist(t, f, "")
+
+	d, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IsCidBlocked(c) {
+		t.Fatal("expected an empty denylist to block nothing")
+	}
+
+	writeDenylist(t, f, "/ipfs/"+c.String()+"\n")
+	// make sure the change is noticed on file systems with coarse mtimes
+	if err := os.Chtimes(f, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := d.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the denylist to be reloaded (err: %v)", err)
+	}
+	if !d.IsCidBlocked(c) {
+		t.Fatal("expected the reloaded entry to be blocked")
+	}
+
+	writeDenylist(t, f, "not an entry\n")
+	if err := os.Chtimes(f, time.Now(), time.Now().Add(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Reload(); err == nil {
+		t.Fatal("expected an invalid entry to fail the reload")
+	}
+	if !d.IsCidBlocked(c) {
+		t.Fatal("expected a failed reload to keep the previous entries")
+	}
+}
@@ -0,0 +1,106 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// ErrCollectionInProgress is returned when an incremental collection is
+// started while another one is still running.
+var ErrCollectionInProgress = errors.New("an incremental garbage collection is already in progress")
+
+// WriteBarrier records the keys of blocks written to the blockstore while an
+// incremental collection is running. Blocks written after the marking began
+// are unknown to the marker, so the sweep consults the barrier to keep them.
+//
+// The barrier is installed by wrapping the blockstores that receive writes
+// with Blockstore. While no collection is running it only costs a mutex
+// acquisition per write.
+type WriteBarrier struct {
+	lk      sync.Mutex
+	written *cid.Set // nil while no collection is running
+}
+
+// NewWriteBarrier creates an idle write barrier.
+func NewWriteBarrier() *WriteBarrier {
+	return &WriteBarrier{}
+}
+
+// Blockstore wraps bs so that writes to it are recorded by the barrier.
+func (wb *WriteBarrier) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	return &barrierBlockstore{Blockstore: bs, wb: wb}
+}
+
+// start begins recording writes.
+func (wb *WriteBarrier) start() error {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	if wb.written != nil {
+		return ErrCollectionInProgress
+	}
+	wb.written = cid.NewSet()
+	return nil
+}
+
+// stop ends recording writes and forgets the recorded keys.
+func (wb *WriteBarrier) stop() {
+	wb.lk.Lock()
+	wb.written = nil
+	wb.lk.Unlock()
+}
+
+// has reports whether a block with the given raw CIDv1 was written since the
+// barrier was started.
+func (wb *WriteBarrier) has(k cid.Cid) bool {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	return wb.written != nil && wb.written.Has(k)
+}
+
+// len returns the number of blocks written since the barrier was started.
+func (wb *WriteBarrier) len() int {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	if wb.written == nil {
+		return 0
+	}
+	return wb.written.Len()
+}
+
+func (wb *WriteBarrier) record(cids ...cid.Cid) {
+	wb.lk.Lock()
+	defer wb.lk.Unlock()
+	if wb.written == nil {
+		return
+	}
+	// The blockstore reports raw blocks, record them the same way.
+	for _, c := range cids {
+		wb.written.Add(cid.NewCidV1(cid.Raw, c.Hash()))
+	}
+}
+
+type barrierBlockstore struct {
+	bstore.Blockstore
+	wb *WriteBarrier
+}
+
+func (bs *barrierBlockstore) Put(ctx context.Context, b blocks.Block) error {
+	// Record before writing so a concurrent sweep never sees the block
+	// without also seeing it in the barrier.
+	bs.wb.record(b.Cid())
+	return bs.Blockstore.Put(ctx, b)
+}
+
+func (bs *barrierBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	cids := make([]cid.Cid, len(blks))
+	for i, b := range blks {
+		cids[i] = b.Cid()
+	}
+	bs.wb.record(cids...)
+	return bs.Blockstore.PutMany(ctx, blks)
+}
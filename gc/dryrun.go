@@ -0,0 +1,216 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+)
+
+// DefaultTopRoots is the number of roots listed in a Report when DryRun is
+// given no limit.
+const DefaultTopRoots = 10
+
+// Report summarizes what a garbage collection would remove, and what keeps
+// the rest of the repo alive. It is sent as the last Result of a dry run.
+type Report struct {
+	Removed Totals            // blocks that would be removed
+	Kept    Totals            // blocks reachable from the roots
+	Codecs  map[string]Totals // removed blocks, by codec
+	Roots   []RootUsage       // roots keeping the most data, largest first
+}
+
+// Totals counts blocks and their size in bytes.
+type Totals struct {
+	Blocks uint64
+	Size   uint64
+}
+
+func (t *Totals) add(size int) {
+	t.Blocks++
+	t.Size += uint64(size)
+}
+
+// RootUsage describes the blocks kept by a single root. Blocks reachable
+// from several roots are attributed to the first root walked that reaches
+// them: recursive pins first, then the best-effort roots, direct pins and
+// internal pins.
+type RootUsage struct {
+	Cid  cid.Cid
+	Kind RootKind
+	Totals
+}
+
+// DryRun computes what GC would remove without removing anything. Every
+// block that would be removed is sent with its Size and Codec set, followed
+// by a Report as the last Result.
+//
+// The blockstore only keeps multihashes, so codecs are inferred from block
+// contents: blocks which decode as dag-pb or dag-cbor are reported as such,
+// JSON objects and arrays as dag-json, and everything else as raw.
+//
+// DryRun does not take the GC lock, so the report is a best-effort snapshot
+// when the repo is written to concurrently. At most topRoots roots are listed
+// in the report, DefaultTopRoots when topRoots is zero, and all of them when
+// it is negative.
+func DryRun(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner, bestEffortRoots []cid.Cid, topRoots int) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	if topRoots == 0 {
+		topRoots = DefaultTopRoots
+	}
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		sendErr := func(err error) {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		dr := &dryRun{bs: bs}
+		gcs := cid.NewSet()
+		if err := colorInto(ctx, pn, ds, bestEffortRoots, output, gcs, dr.descendants); err != nil {
+			sendErr(err)
+			return
+		}
+
+		// The blockstore reports raw blocks. We need to remove the codecs from the CIDs.
+		marked, err := toRawCids(gcs)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		report := &Report{Codecs: make(map[string]Totals)}
+		for _, r := range dr.roots {
+			report.Kept.Blocks += r.Blocks
+			report.Kept.Size += r.Size
+		}
+
+	loop:
+		for ctx.Err() == nil { // select may not notice that we're "done".
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					break loop
+				}
+				// NOTE: assumes that all CIDs returned by the keychan are _raw_ CIDv1 CIDs.
+				if marked.Has(k) {
+					continue
+				}
+				blk, err := bs.Get(ctx, k)
+				if err != nil {
+					// The block may have been removed since it was listed.
+					log.Debugf("dry run: could not read %s: %s", k, err)
+					continue
+				}
+				codec := multicodec.Code(guessCodec(blk.RawData())).String()
+				size := len(blk.RawData())
+
+				report.Removed.add(size)
+				t := report.Codecs[codec]
+				t.add(size)
+				report.Codecs[codec] = t
+
+				select {
+				case output <- Result{KeyRemoved: k, Size: uint64(size), Codec: codec}:
+				case <-ctx.Done():
+					break loop
+				}
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		sort.SliceStable(dr.roots, func(i, j int) bool {
+			return dr.roots[i].Size > dr.roots[j].Size
+		})
+		if topRoots > 0 && len(dr.roots) > topRoots {
+			dr.roots = dr.roots[:topRoots]
+		}
+		report.Roots = dr.roots
+
+		select {
+		case output <- Result{Report: report}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return output
+}
+
+// dryRun marks like descendAll, while attributing each marked block to the
+// root that reached it first.
+type dryRun struct {
+	bs    bstore.Blockstore
+	roots []RootUsage
+}
+
+func (dr *dryRun) descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid, kind RootKind) error {
+	for _, r := range roots {
+		usage := RootUsage{Cid: r, Kind: kind}
+		visit := func(k cid.Cid) bool {
+			if !set.Visit(toCidV1(k)) {
+				return false
+			}
+			size, err := dr.bs.GetSize(ctx, k)
+			if err != nil {
+				// Missing blocks are reported by the walk itself.
+				log.Debugf("dry run: could not get size of %s: %s", k, err)
+				return true
+			}
+			usage.add(size)
+			return true
+		}
+
+		if kind == RootDirect {
+			visit(r)
+		} else if err := walkDescendants(ctx, getLinks, []cid.Cid{r}, visit, dag.Concurrent()); err != nil {
+			return err
+		}
+		dr.roots = append(dr.roots, usage)
+	}
+	return nil
+}
+
+// guessCodec infers the codec of a block from its contents.
+func guessCodec(data []byte) uint64 {
+	if _, err := dag.DecodeProtobuf(data); err == nil {
+		return cid.DagProtobuf
+	}
+	if err := dagcbor.Decode(basicnode.Prototype.Any.NewBuilder(), bytes.NewReader(data)); err == nil {
+		return cid.DagCBOR
+	}
+	if len(data) > 0 && (data[0] == '{' || data[0] == '[') && json.Valid(data) {
+		return cid.DagJSON
+	}
+	return cid.Raw
+}
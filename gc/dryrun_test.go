@@ -0,0 +1,70 @@
+package gc
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	small := r.add("small", r.add("small child"))
+	large := r.add("large", r.add("large child 1"), r.add("large child 2"))
+	direct := r.add("direct", r.add("direct child"))
+	garbage := r.add("garbage")
+	for _, nd := range []*dag.ProtoNode{small, large} {
+		if err := r.pinner.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.pinner.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var report *Report
+	removed := cid.NewSet()
+	for res := range DryRun(ctx, r.bs, r.pinner, nil, 2) {
+		switch {
+		case res.Error != nil:
+			t.Fatal(res.Error)
+		case res.Report != nil:
+			report = res.Report
+		default:
+			if res.Codec != "dag-pb" || res.Size == 0 {
+				t.Errorf("unexpected codec %q and size %d for %s", res.Codec, res.Size, res.KeyRemoved)
+			}
+			removed.Add(res.KeyRemoved)
+		}
+	}
+
+	// The child of the direct pin is garbage too.
+	if removed.Len() != 2 || !removed.Has(cid.NewCidV1(cid.Raw, garbage.Cid().Hash())) {
+		t.Fatalf("unexpected removals: %v", removed.Keys())
+	}
+	has, err := r.bs.Has(ctx, garbage.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Fatal("dry run removed a block")
+	}
+
+	if report == nil {
+		t.Fatal("no report")
+	}
+	if report.Removed.Blocks != 2 || report.Codecs["dag-pb"].Blocks != 2 {
+		t.Errorf("unexpected removal totals: %+v", report)
+	}
+	if report.Kept.Blocks != 6 {
+		t.Errorf("expected 6 kept blocks, got %d", report.Kept.Blocks)
+	}
+	if len(report.Roots) != 2 ||
+		!report.Roots[0].Cid.Equals(large.Cid()) || report.Roots[0].Blocks != 3 || report.Roots[0].Kind != RootRecursive ||
+		!report.Roots[1].Cid.Equals(small.Cid()) || report.Roots[1].Blocks != 2 {
+		t.Errorf("unexpected roots: %+v", report.Roots)
+	}
+}
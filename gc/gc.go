@@ -22,10 +22,18 @@ import (
 var log = logging.Logger("gc")
 
 // Result represents an incremental output from a garbage collection
-// run.  It contains either an error, or the cid of a removed object.
+// run.  It contains either an error, the cid of a removed object, (for
+// incremental collections) a progress report or (for dry runs) the final
+// report.
 type Result struct {
 	KeyRemoved cid.Cid
 	Error      error
+	Progress   *Progress
+	Report     *Report
+
+	// Size and Codec describe KeyRemoved, they are only set by dry runs.
+	Size  uint64
+	Codec string
 }
 
 // converts a set of CIDs with different codecs to a set of CIDs with the raw codec.
@@ -45,6 +53,9 @@ func toRawCids(set *cid.Set) (*cid.Set, error) {
 // - all directly pinned blocks
 // - all blocks utilized internally by the pinner
 //
+// Only the pins listed by pn are kept, so a pinner which hides some of its
+// pins (such as expired ones) leaves their blocks to be collected.
+//
 // The routine then iterates over every block in the blockstore and
 // deletes any block that is not found in the marked set.
 func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
@@ -155,6 +166,14 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 // adds them to the given cid.Set, using the provided dag.GetLinks function
 // to walk the tree.
 func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid) error {
+	return walkDescendants(ctx, getLinks, roots, func(k cid.Cid) bool {
+		return set.Visit(toCidV1(k))
+	}, dag.Concurrent())
+}
+
+// walkDescendants walks the DAGs below the given roots, calling visit for
+// every node reached. Subtrees are skipped when visit returns false.
+func walkDescendants(ctx context.Context, getLinks dag.GetLinks, roots []cid.Cid, visit func(cid.Cid) bool, options ...dag.WalkOption) error {
 	verifyGetLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
 		err := verifcid.ValidateCid(c)
 		if err != nil {
@@ -177,9 +196,7 @@ func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots
 
 	for _, c := range roots {
 		// Walk recursively walks the dag and adds the keys to the given set
-		err := dag.Walk(ctx, verifyGetLinks, c, func(k cid.Cid) bool {
-			return set.Visit(toCidV1(k))
-		}, dag.Concurrent())
+		err := dag.Walk(ctx, verifyGetLinks, c, visit, options...)
 
 		if err != nil {
 			err = verboseCidError(err)
@@ -203,8 +220,43 @@ func toCidV1(c cid.Cid) cid.Cid {
 func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, output chan<- Result) (*cid.Set, error) {
 	// KeySet currently implemented in memory, in the future, may be bloom filter or
 	// disk backed to conserve memory.
-	errors := false
 	gcs := cid.NewSet()
+	if err := colorInto(ctx, pn, ng, bestEffortRoots, output, gcs, descendAll); err != nil {
+		return nil, err
+	}
+	return gcs, nil
+}
+
+// RootKind tells why a root is kept by the garbage collector.
+type RootKind string
+
+const (
+	RootRecursive  RootKind = "recursive"   // recursively pinned
+	RootDirect     RootKind = "direct"      // directly pinned, children are not kept
+	RootBestEffort RootKind = "best-effort" // best-effort roots, like the MFS root
+	RootInternal   RootKind = "internal"    // used internally by the pinner
+)
+
+// descendFunc adds everything reachable from roots to set. Roots of kind
+// RootDirect are added without walking their children. descendAll is the
+// default implementation.
+type descendFunc func(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid, kind RootKind) error
+
+func descendAll(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid, kind RootKind) error {
+	if kind == RootDirect {
+		for _, k := range roots {
+			set.Add(toCidV1(k))
+		}
+		return nil
+	}
+	return Descendants(ctx, getLinks, set, roots)
+}
+
+// colorInto marks everything pinned by pn, and everything reachable from
+// bestEffortRoots, in gcs. Nodes already present in gcs are not walked again,
+// which lets callers re-color an existing set cheaply.
+func colorInto(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, output chan<- Result, gcs *cid.Set, descend descendFunc) error {
+	errors := false
 	getLinks := func(ctx context.Context, cid cid.Cid) ([]*ipld.Link, error) {
 		links, err := ipld.GetLinks(ctx, ng, cid)
 		if err != nil {
@@ -219,15 +271,15 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 	}
 	rkeys, err := pn.RecursiveKeys(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	err = Descendants(ctx, getLinks, gcs, rkeys)
+	err = descend(ctx, getLinks, gcs, rkeys, RootRecursive)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
@@ -243,43 +295,44 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		}
 		return links, nil
 	}
-	err = Descendants(ctx, bestEffortGetLinks, gcs, bestEffortRoots)
+	err = descend(ctx, bestEffortGetLinks, gcs, bestEffortRoots, RootBestEffort)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
 	dkeys, err := pn.DirectKeys(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for _, k := range dkeys {
-		gcs.Add(toCidV1(k))
+	err = descend(ctx, nil, gcs, dkeys, RootDirect)
+	if err != nil {
+		return err
 	}
 
 	ikeys, err := pn.InternalPins(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	err = Descendants(ctx, getLinks, gcs, ikeys)
+	err = descend(ctx, getLinks, gcs, ikeys, RootInternal)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
 	if errors {
-		return nil, ErrCannotFetchAllLinks
+		return ErrCannotFetchAllLinks
 	}
 
-	return gcs, nil
+	return nil
 }
 
 // ErrCannotFetchAllLinks is returned as the last Result in the GC output
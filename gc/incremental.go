@@ -0,0 +1,340 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// ErrNoWriteBarrier is returned when an incremental collection is requested
+// on a node whose blockstore has no write barrier installed.
+var ErrNoWriteBarrier = errors.New("incremental garbage collection requires a blockstore write barrier")
+
+// Phase identifies a stage of an incremental garbage collection.
+type Phase string
+
+const (
+	// PhaseMark is the unlocked marking of everything reachable from the
+	// pins and the best-effort roots.
+	PhaseMark Phase = "mark"
+	// PhaseScan is the unlocked enumeration of the blockstore for blocks
+	// that were not marked.
+	PhaseScan Phase = "scan"
+	// PhaseSweep is the final phase. It holds the GC lock while it marks
+	// whatever changed since PhaseMark and removes the remaining candidates.
+	PhaseSweep Phase = "sweep"
+)
+
+// Progress reports how far an incremental garbage collection has come.
+type Progress struct {
+	Phase      Phase
+	Marked     uint64 // blocks found reachable so far
+	Written    uint64 // blocks written since the collection started
+	Candidates uint64 // unmarked blocks found by the scan
+	Removed    uint64 // blocks removed by the sweep
+	Done       bool   // set on the last report of a phase
+}
+
+// IncrementalOptions tunes an incremental collection. The zero value uses
+// the defaults.
+type IncrementalOptions struct {
+	// SliceSize is the number of blocks marked or scanned between pauses.
+	SliceSize int
+	// SlicePause is how long the collector yields after every slice.
+	SlicePause time.Duration
+}
+
+const (
+	defaultSliceSize  = 10000
+	defaultSlicePause = 10 * time.Millisecond
+)
+
+// IncrementalGC performs a mark and sweep garbage collection without holding
+// the GC lock for the whole run. It selects the same blocks as GC, but in
+// three phases:
+//
+//   - mark: walks the pins and bestEffortRoots in slices of
+//     opts.SliceSize blocks, pausing opts.SlicePause after every slice.
+//   - scan: lists the blockstore and remembers every block that was not marked.
+//   - sweep: takes the GC lock, marks pins and roots that changed since the
+//     mark phase, then removes the candidates that are still unmarked.
+//
+// Blocks written while the collection runs are recorded by wb and never
+// removed. bestEffortRoots is called once before the mark phase and again
+// under the lock, so it should return the current roots each time.
+//
+// Progress is reported on the output channel after every slice and at the
+// end of each phase.
+func IncrementalGC(ctx context.Context, bs bstore.GCBlockstore, wb *WriteBarrier, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), opts IncrementalOptions) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	if opts.SliceSize <= 0 {
+		opts.SliceSize = defaultSliceSize
+	}
+	if opts.SlicePause <= 0 {
+		opts.SlicePause = defaultSlicePause
+	}
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		sendErr := func(err error) {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		if wb == nil {
+			sendErr(ErrNoWriteBarrier)
+			return
+		}
+		if err := wb.start(); err != nil {
+			sendErr(err)
+			return
+		}
+		defer wb.stop()
+
+		ic := &incrementalCollector{opts: opts, wb: wb, output: output}
+
+		// Phase 1: mark, unlocked and throttled.
+		roots, err := bestEffortRoots()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		gcs := cid.NewSet()
+		ic.phase = PhaseMark
+		if err := colorInto(ctx, pn, ds, roots, output, gcs, ic.descendants); err != nil {
+			sendErr(err)
+			return
+		}
+		if !ic.report(ctx, true) {
+			return
+		}
+
+		// Phase 2: scan, unlocked and throttled.
+		// The blockstore reports raw blocks. We need to remove the codecs from the CIDs.
+		marked, err := toRawCids(gcs)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		candidates, err := ic.scan(ctx, bs, marked)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if !ic.report(ctx, true) {
+			return
+		}
+
+		// Phase 3: sweep, under the GC lock.
+		unlocker := bs.GCLock(ctx)
+		ic.phase = PhaseSweep
+		ok := ic.sweep(ctx, bs, pn, ds, bestEffortRoots, gcs, candidates)
+		unlocker.Unlock(ctx)
+		if !ok {
+			return
+		}
+		if !ic.report(ctx, true) {
+			return
+		}
+		if ic.deleteErrors {
+			sendErr(ErrCannotDeleteSomeBlocks)
+			return
+		}
+
+		gds, ok := dstor.(dstore.GCDatastore)
+		if !ok {
+			return
+		}
+
+		err = gds.CollectGarbage(ctx)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	}()
+
+	return output
+}
+
+type incrementalCollector struct {
+	opts   IncrementalOptions
+	wb     *WriteBarrier
+	output chan<- Result
+
+	phase        Phase
+	marked       uint64
+	candidates   uint64
+	removed      uint64
+	deleteErrors bool
+}
+
+// report sends the current progress. It returns false when the context was
+// canceled.
+func (ic *incrementalCollector) report(ctx context.Context, done bool) bool {
+	p := &Progress{
+		Phase:      ic.phase,
+		Marked:     ic.marked,
+		Written:    uint64(ic.wb.len()),
+		Candidates: ic.candidates,
+		Removed:    ic.removed,
+		Done:       done,
+	}
+	select {
+	case ic.output <- Result{Progress: p}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// yield is called for every block processed in an unlocked phase. After
+// every slice it reports progress and pauses. It returns false when the
+// context was canceled.
+func (ic *incrementalCollector) yield(ctx context.Context, n uint64) bool {
+	if n%uint64(ic.opts.SliceSize) != 0 {
+		return ctx.Err() == nil
+	}
+	if !ic.report(ctx, false) {
+		return false
+	}
+	select {
+	case <-time.After(ic.opts.SlicePause):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// descendants is the throttled counterpart of descendAll. It walks
+// sequentially so that a slice bounds the amount of work done between pauses.
+func (ic *incrementalCollector) descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid, kind RootKind) error {
+	visit := func(k cid.Cid) bool {
+		if !set.Visit(toCidV1(k)) {
+			return false
+		}
+		ic.marked++
+		return ic.yield(ctx, ic.marked)
+	}
+	if kind == RootDirect {
+		for _, k := range roots {
+			visit(k)
+		}
+		return ctx.Err()
+	}
+	err := walkDescendants(ctx, getLinks, roots, visit)
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// scan lists the blockstore and returns the keys of all blocks that are
+// neither marked nor were written during the collection.
+func (ic *incrementalCollector) scan(ctx context.Context, bs bstore.Blockstore, marked *cid.Set) ([]cid.Cid, error) {
+	ic.phase = PhaseScan
+
+	keychan, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []cid.Cid
+	var scanned uint64
+	for {
+		select {
+		case k, ok := <-keychan:
+			if !ok {
+				return candidates, nil
+			}
+			// NOTE: assumes that all CIDs returned by the keychan are _raw_ CIDv1 CIDs.
+			if !marked.Has(k) && !ic.wb.has(k) {
+				candidates = append(candidates, k)
+				ic.candidates++
+			}
+			scanned++
+			if !ic.yield(ctx, scanned) {
+				return nil, ctx.Err()
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sweep must be called with the GC lock held. It marks whatever became
+// reachable since the mark phase and removes the candidates that are still
+// garbage. It returns false if the collection had to be aborted.
+func (ic *incrementalCollector) sweep(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots func() ([]cid.Cid, error), gcs *cid.Set, candidates []cid.Cid) bool {
+	sendErr := func(err error) {
+		select {
+		case ic.output <- Result{Error: err}:
+		case <-ctx.Done():
+		}
+	}
+
+	roots, err := bestEffortRoots()
+	if err != nil {
+		sendErr(err)
+		return false
+	}
+	// Everything marked in the first phase is skipped, so this only walks
+	// pins and roots that were added in the meantime.
+	before := gcs.Len()
+	if err := colorInto(ctx, pn, ng, roots, ic.output, gcs, descendAll); err != nil {
+		sendErr(err)
+		return false
+	}
+	ic.marked += uint64(gcs.Len() - before)
+
+	marked, err := toRawCids(gcs)
+	if err != nil {
+		sendErr(err)
+		return false
+	}
+
+	for _, k := range candidates {
+		if ctx.Err() != nil {
+			return false
+		}
+		if marked.Has(k) || ic.wb.has(k) {
+			continue
+		}
+		err := bs.DeleteBlock(ctx, k)
+		if err != nil {
+			ic.deleteErrors = true
+			select {
+			case ic.output <- Result{Error: &CannotDeleteBlockError{k, err}}:
+			case <-ctx.Done():
+				return false
+			}
+			// continue as error is non-fatal
+			continue
+		}
+		ic.removed++
+		select {
+		case ic.output <- Result{KeyRemoved: k}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
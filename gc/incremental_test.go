@@ -0,0 +1,108 @@
+package gc
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+type testRepo struct {
+	t      *testing.T
+	dstore ds.Batching
+	wb     *WriteBarrier
+	bs     bstore.GCBlockstore
+	dserv  ipld.DAGService
+	pinner pin.Pinner
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	wb := NewWriteBarrier()
+	bs := bstore.NewGCBlockstore(wb.Blockstore(bstore.NewBlockstore(dstore)), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	pinner, err := dspinner.New(context.Background(), dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, dstore: dstore, wb: wb, bs: bs, dserv: dserv, pinner: pinner}
+}
+
+func (r *testRepo) add(data string, children ...*dag.ProtoNode) *dag.ProtoNode {
+	nd := dag.NodeWithData([]byte(data))
+	for _, c := range children {
+		if err := nd.AddNodeLink(c.Cid().String(), c); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	if err := r.dserv.Add(context.Background(), nd); err != nil {
+		r.t.Fatal(err)
+	}
+	return nd
+}
+
+func TestIncrementalGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	bs, wb, dstore, pinner, add := r.bs, r.wb, r.dstore, r.pinner, r.add
+
+	child := add("child")
+	pinned := add("pinned", child)
+	if err := pinner.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	garbage := add("garbage")
+	pinnedLater := add("pinned later")
+
+	var writtenLater *dag.ProtoNode
+	out := IncrementalGC(ctx, bs, wb, dstore, pinner, func() ([]cid.Cid, error) {
+		return nil, nil
+	}, IncrementalOptions{SliceSize: 1})
+
+	removed := cid.NewSet()
+	for res := range out {
+		switch {
+		case res.Error != nil:
+			t.Fatal(res.Error)
+		case res.Progress != nil:
+			if res.Progress.Phase == PhaseMark && writtenLater == nil {
+				// Neither of these is known to the marker.
+				writtenLater = add("written later")
+				if err := pinner.Pin(ctx, pinnedLater, true); err != nil {
+					t.Fatal(err)
+				}
+				if err := pinner.Flush(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+		default:
+			removed.Add(res.KeyRemoved)
+		}
+	}
+
+	if writtenLater == nil {
+		t.Fatal("no progress was reported during the mark phase")
+	}
+	if removed.Len() != 1 || !removed.Has(cid.NewCidV1(cid.Raw, garbage.Cid().Hash())) {
+		t.Fatalf("expected only the garbage block to be removed, got %v", removed.Keys())
+	}
+	for _, nd := range []*dag.ProtoNode{child, pinned, pinnedLater, writtenLater} {
+		has, err := bs.Has(ctx, nd.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("block %s was removed", nd.Cid())
+		}
+	}
+}
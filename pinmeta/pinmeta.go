@@ -0,0 +1,227 @@
+// Package pinmeta keeps metadata about local pins, such as when they expire,
+// in the repo next to the pins themselves.
+package pinmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pinmeta")
+
+// Prefix is the datastore namespace under which pin metadata is kept.
+var Prefix = ds.NewKey("/local/pins/meta")
+
+// Metadata is the extra information kept about a local pin.
+type Metadata struct {
+	// Name is a free-form name for the pin. Names need not be unique.
+	Name string `json:",omitempty"`
+
+	// Labels are arbitrary key/value pairs attached to the pin.
+	Labels map[string]string `json:",omitempty"`
+
+	// Expires is when the pin expires. Expired pins are ignored by the
+	// garbage collector, and removed by the reaper.
+	Expires *time.Time `json:",omitempty"`
+}
+
+// IsZero returns true when there is nothing worth storing.
+func (m Metadata) IsZero() bool {
+	return m.Name == "" && len(m.Labels) == 0 && m.Expires == nil
+}
+
+// Merge returns m updated with the fields set in o. Labels are merged key by
+// key, with the values of o taking precedence.
+func (m Metadata) Merge(o Metadata) Metadata {
+	if o.Name != "" {
+		m.Name = o.Name
+	}
+	if o.Expires != nil {
+		m.Expires = o.Expires
+	}
+	if len(o.Labels) > 0 {
+		labels := make(map[string]string, len(m.Labels)+len(o.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		for k, v := range o.Labels {
+			labels[k] = v
+		}
+		m.Labels = labels
+	}
+	return m
+}
+
+// Expired returns true if the pin has expired at the given time.
+func (m Metadata) Expired(now time.Time) bool {
+	return m.Expires != nil && !now.Before(*m.Expires)
+}
+
+// Filter selects pins by their metadata. The zero Filter matches all pins.
+type Filter struct {
+	Name   string            // exact name of the pins, any name when empty
+	Labels map[string]string // labels all matching pins carry
+}
+
+// IsZero returns true if f matches all pins.
+func (f Filter) IsZero() bool {
+	return f.Name == "" && len(f.Labels) == 0
+}
+
+// Match returns true if a pin with the metadata m is selected by f.
+func (f Filter) Match(m Metadata) bool {
+	if f.Name != "" && f.Name != m.Name {
+		return false
+	}
+	for k, v := range f.Labels {
+		if mv, ok := m.Labels[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Entry is the metadata of a single pin.
+type Entry struct {
+	Cid cid.Cid
+	Metadata
+}
+
+// Store persists pin metadata in a datastore.
+type Store struct {
+	ds ds.Datastore
+
+	mu      sync.Mutex
+	expires map[cid.Cid]time.Time // expiry of pins, indexed on first use
+}
+
+// NewStore creates a Store keeping the metadata in d, under Prefix.
+func NewStore(d ds.Datastore) *Store {
+	return &Store{ds: namespace.Wrap(d, Prefix)}
+}
+
+func dsKey(c cid.Cid) ds.Key {
+	return ds.NewKey(c.String())
+}
+
+// Get returns the metadata of the pin c. It returns zero Metadata for pins
+// without any.
+func (s *Store) Get(ctx context.Context, c cid.Cid) (Metadata, error) {
+	var m Metadata
+	b, err := s.ds.Get(ctx, dsKey(c))
+	switch {
+	case err == ds.ErrNotFound:
+		return m, nil
+	case err != nil:
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("invalid metadata for pin %s: %w", c, err)
+	}
+	return m, nil
+}
+
+// Put stores the metadata of the pin c. Zero metadata is deleted.
+func (s *Store) Put(ctx context.Context, c cid.Cid, m Metadata) error {
+	if m.IsZero() {
+		return s.Delete(ctx, c)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, dsKey(c), b); err != nil {
+		return err
+	}
+	s.index(c, m.Expires)
+	return s.ds.Sync(ctx, dsKey(c))
+}
+
+// Delete removes the metadata of the pin c, if any.
+func (s *Store) Delete(ctx context.Context, c cid.Cid) error {
+	if err := s.ds.Delete(ctx, dsKey(c)); err != nil {
+		return err
+	}
+	s.index(c, nil)
+	return nil
+}
+
+// Expired returns the pins which have expired at now. The expiry of pins is
+// loaded once and then kept up to date by Put and Delete.
+func (s *Store) Expired(ctx context.Context, now time.Time) (*cid.Set, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expires == nil {
+		entries, err := s.Query(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.expires = make(map[cid.Cid]time.Time)
+		for _, e := range entries {
+			if e.Expires != nil {
+				s.expires[e.Cid] = *e.Expires
+			}
+		}
+	}
+
+	expired := cid.NewSet()
+	for c, t := range s.expires {
+		if !now.Before(t) {
+			expired.Add(c)
+		}
+	}
+	return expired, nil
+}
+
+// index records the expiry of the pin c, once the index is loaded.
+func (s *Store) index(c cid.Cid, expires *time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expires == nil {
+		return
+	}
+	if expires == nil {
+		delete(s.expires, c)
+	} else {
+		s.expires[c] = *expires
+	}
+}
+
+// Query returns the metadata of all pins that have some.
+func (s *Store) Query(ctx context.Context) ([]Entry, error) {
+	res, err := s.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []Entry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("skipping metadata with invalid key %q: %s", r.Key, err)
+			continue
+		}
+		e := Entry{Cid: c}
+		if err := json.Unmarshal(r.Value, &e.Metadata); err != nil {
+			log.Errorf("skipping invalid metadata for pin %s: %s", c, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
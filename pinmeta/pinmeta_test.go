@@ -0,0 +1,44 @@
+package pinmeta
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	m := Metadata{Name: "site", Labels: map[string]string{"owner": "alice", "project": "www"}}
+
+	for _, tc := range []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Name: "site"}, true},
+		{Filter{Name: "sit"}, false},
+		{Filter{Labels: map[string]string{"owner": "alice"}}, true},
+		{Filter{Labels: map[string]string{"owner": "alice", "project": "www"}}, true},
+		{Filter{Labels: map[string]string{"owner": "bob"}}, false},
+		{Filter{Labels: map[string]string{"team": ""}}, false},
+		{Filter{Name: "site", Labels: map[string]string{"project": "api"}}, false},
+	} {
+		if got := tc.filter.Match(m); got != tc.match {
+			t.Errorf("%+v: expected match %t, got %t", tc.filter, tc.match, got)
+		}
+	}
+
+	if (Filter{Name: "site"}).Match(Metadata{}) {
+		t.Error("expected a pin without metadata not to match a name")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	m := Metadata{Name: "site", Labels: map[string]string{"owner": "alice"}}
+	merged := m.Merge(Metadata{Labels: map[string]string{"owner": "bob", "project": "www"}})
+
+	if merged.Name != "site" {
+		t.Errorf("expected name to be kept, got %q", merged.Name)
+	}
+	if merged.Labels["owner"] != "bob" || merged.Labels["project"] != "www" {
+		t.Errorf("expected labels to be merged, got %v", merged.Labels)
+	}
+	if m.Labels["owner"] != "alice" {
+		t.Error("expected Merge not to modify the original labels")
+	}
+}
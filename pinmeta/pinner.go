@@ -0,0 +1,336 @@
+package pinmeta
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// Pinner wraps a pin.Pinner to keep the metadata of pins in sync with the
+// pins, and to treat expired pins as unpinned even before the reaper removes
+// them: they are hidden from DirectKeys and RecursiveKeys, which the garbage
+// collector keeps, and from IsPinned and CheckIfPinned.
+type Pinner struct {
+	pin.Pinner
+	store *Store
+	dserv ipld.DAGService // walks recursive pins for indirect pins
+
+	now func() time.Time
+}
+
+var _ pin.Pinner = (*Pinner)(nil)
+
+// NewPinner wraps p, keeping pin metadata in s. dserv is the DAG service of
+// p.
+func NewPinner(p pin.Pinner, s *Store, dserv ipld.DAGService) *Pinner {
+	return &Pinner{Pinner: p, store: s, dserv: dserv, now: time.Now}
+}
+
+// Store returns the metadata store of the pinner.
+func (p *Pinner) Store() *Store {
+	return p.store
+}
+
+// Pin pins node. Pinning something again makes an expiring pin permanent,
+// callers wanting to keep an expiry have to set it again afterwards. Names
+// and labels are kept.
+func (p *Pinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+
+	m, err := p.store.Get(ctx, node.Cid())
+	if err != nil {
+		return err
+	}
+	if m.Expires == nil {
+		return nil
+	}
+	m.Expires = nil
+	return p.store.Put(ctx, node.Cid(), m)
+}
+
+// PinWithMetadata pins node and merges m into its metadata. The expiry of m
+// only applies to new pins and to pins which expire already: pinning
+// something pinned permanently keeps it permanent.
+func (p *Pinner) PinWithMetadata(ctx context.Context, node ipld.Node, recursive bool, m Metadata) error {
+	old, err := p.store.Get(ctx, node.Cid())
+	if err != nil {
+		return err
+	}
+	if m.Expires != nil && old.Expires == nil {
+		for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+			_, pinned, err := p.Pinner.IsPinnedWithType(ctx, node.Cid(), mode)
+			if err != nil {
+				return err
+			}
+			if pinned {
+				m.Expires = nil
+				break
+			}
+		}
+	}
+
+	if err := p.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	old.Expires = nil
+	return p.store.Put(ctx, node.Cid(), old.Merge(m))
+}
+
+// Unpin removes the pin on c along with its metadata.
+func (p *Pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	return p.store.Delete(ctx, c)
+}
+
+// Update moves a recursive pin from one cid to another, the metadata moves
+// with it. The metadata of to is only replaced when from has some.
+func (p *Pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	m, err := p.store.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+
+	if m.IsZero() {
+		return nil
+	}
+	if err := p.store.Put(ctx, to, m); err != nil {
+		return err
+	}
+	if unpin {
+		return p.store.Delete(ctx, from)
+	}
+	return nil
+}
+
+// DirectKeys returns all direct pins that have not expired.
+func (p *Pinner) DirectKeys(ctx context.Context) ([]cid.Cid, error) {
+	keys, err := p.Pinner.DirectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.withoutExpired(ctx, keys)
+}
+
+// RecursiveKeys returns all recursive pins that have not expired.
+func (p *Pinner) RecursiveKeys(ctx context.Context) ([]cid.Cid, error) {
+	keys, err := p.Pinner.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.withoutExpired(ctx, keys)
+}
+
+func (p *Pinner) withoutExpired(ctx context.Context, keys []cid.Cid) ([]cid.Cid, error) {
+	expired, err := p.expired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return without(keys, expired), nil
+}
+
+func without(keys []cid.Cid, expired *cid.Set) []cid.Cid {
+	if expired.Len() == 0 {
+		return keys
+	}
+	live := keys[:0]
+	for _, k := range keys {
+		if !expired.Has(k) {
+			live = append(live, k)
+		}
+	}
+	return live
+}
+
+func (p *Pinner) expired(ctx context.Context) (*cid.Set, error) {
+	return p.store.Expired(ctx, p.now())
+}
+
+// IsPinned returns whether c is pinned, and how, ignoring expired pins.
+func (p *Pinner) IsPinned(ctx context.Context, c cid.Cid) (string, bool, error) {
+	return p.IsPinnedWithType(ctx, c, pin.Any)
+}
+
+// IsPinnedWithType returns whether c is pinned with mode, and how, ignoring
+// expired pins.
+func (p *Pinner) IsPinnedWithType(ctx context.Context, c cid.Cid, mode pin.Mode) (string, bool, error) {
+	expired, err := p.expired(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if expired.Len() == 0 {
+		return p.Pinner.IsPinnedWithType(ctx, c, mode)
+	}
+
+	switch mode {
+	case pin.Recursive, pin.Direct:
+		if expired.Has(c) {
+			return "", false, nil
+		}
+		return p.Pinner.IsPinnedWithType(ctx, c, mode)
+	case pin.Any:
+		if !expired.Has(c) {
+			for _, m := range []pin.Mode{pin.Recursive, pin.Direct} {
+				reason, pinned, err := p.Pinner.IsPinnedWithType(ctx, c, m)
+				if err != nil || pinned {
+					return reason, pinned, err
+				}
+			}
+		}
+	case pin.Indirect:
+	default:
+		return p.Pinner.IsPinnedWithType(ctx, c, mode)
+	}
+
+	toCheck := cid.NewSet()
+	toCheck.Add(c)
+	indirect, err := p.indirect(ctx, expired, toCheck)
+	if err != nil || len(indirect) == 0 {
+		return "", false, err
+	}
+	return indirect[0].Via.String(), true, nil
+}
+
+// CheckIfPinned returns the pinned state of cids, ignoring expired pins.
+func (p *Pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]pin.Pinned, error) {
+	expired, err := p.expired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expired.Len() == 0 {
+		return p.Pinner.CheckIfPinned(ctx, cids...)
+	}
+
+	pinned := make([]pin.Pinned, 0, len(cids))
+	toCheck := cid.NewSet()
+	for _, c := range cids {
+		mode := pin.NotPinned
+		if !expired.Has(c) {
+			for _, m := range []pin.Mode{pin.Recursive, pin.Direct} {
+				_, ok, err := p.Pinner.IsPinnedWithType(ctx, c, m)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					mode = m
+					break
+				}
+			}
+		}
+		if mode == pin.NotPinned {
+			toCheck.Add(c)
+		} else {
+			pinned = append(pinned, pin.Pinned{Key: c, Mode: mode})
+		}
+	}
+
+	indirect, err := p.indirect(ctx, expired, toCheck)
+	if err != nil {
+		return nil, err
+	}
+	pinned = append(pinned, indirect...)
+	for _, k := range toCheck.Keys() {
+		pinned = append(pinned, pin.Pinned{Key: k, Mode: pin.NotPinned})
+	}
+	return pinned, nil
+}
+
+// indirect returns the cids of toCheck pinned indirectly by the recursive
+// pins which have not expired, removing them from toCheck.
+func (p *Pinner) indirect(ctx context.Context, expired, toCheck *cid.Set) ([]pin.Pinned, error) {
+	if toCheck.Len() == 0 {
+		return nil, nil
+	}
+	roots, err := p.Pinner.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pinned []pin.Pinned
+	visited := cid.NewSet()
+	for _, rk := range without(roots, expired) {
+		if toCheck.Len() == 0 {
+			break
+		}
+		rk := rk
+		err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(p.dserv), rk, func(c cid.Cid) bool {
+			if toCheck.Len() == 0 || !visited.Visit(c) {
+				return false
+			}
+			// Recursive pins are not indirect pins of themselves.
+			if c != rk && toCheck.Has(c) {
+				pinned = append(pinned, pin.Pinned{Key: c, Mode: pin.Indirect, Via: rk})
+				toCheck.Remove(c)
+			}
+			return true
+		}, merkledag.Concurrent())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pinned, nil
+}
+
+// ReapExpired removes all expired pins and returns their cids. locker
+// protects the pins against a concurrent garbage collection.
+func (p *Pinner) ReapExpired(ctx context.Context, locker bstore.GCLocker) ([]cid.Cid, error) {
+	expired, err := p.expired(ctx)
+	if err != nil || expired.Len() == 0 {
+		return nil, err
+	}
+
+	defer locker.PinLock(ctx).Unlock(ctx)
+
+	var removed []cid.Cid
+	err = expired.ForEach(func(c cid.Cid) error {
+		// Unpin removes direct pins too when recursive is set.
+		err := p.Unpin(ctx, c, true)
+		if errors.Is(err, pin.ErrNotPinned) {
+			// The pin is gone already, only the metadata was left.
+			return p.store.Delete(ctx, c)
+		}
+		if err != nil {
+			return err
+		}
+		removed = append(removed, c)
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+	return removed, p.Flush(ctx)
+}
+
+// RunReaper calls ReapExpired every interval until ctx is done.
+func (p *Pinner) RunReaper(ctx context.Context, locker bstore.GCLocker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := p.ReapExpired(ctx, locker)
+			for _, c := range removed {
+				log.Infof("removed expired pin %s", c)
+			}
+			if err != nil {
+				log.Errorf("removing expired pins: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package pinmeta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func newTestPinner(t *testing.T) (*Pinner, *dag.ProtoNode, *dag.ProtoNode) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	p, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := dag.NodeWithData([]byte("a"))
+	b := dag.NodeWithData([]byte("b"))
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewPinner(p, NewStore(dstore), dserv), a, b
+}
+
+func TestExpiredPins(t *testing.T) {
+	ctx := context.Background()
+	p, a, b := newTestPinner(t)
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := p.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expires := now.Add(time.Hour)
+	if err := p.Store().Put(ctx, a.Cid(), Metadata{Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := p.RecursiveKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 pins before expiry, got %d", len(keys))
+	}
+
+	now = expires
+	keys, err = p.RecursiveKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].Equals(b.Cid()) {
+		t.Fatalf("expected only %s to be listed after expiry, got %v", b.Cid(), keys)
+	}
+
+	if _, pinned, err := p.IsPinned(ctx, a.Cid()); err != nil || pinned {
+		t.Fatalf("expected %s to be unpinned once expired (err: %v)", a.Cid(), err)
+	}
+	if mode, pinned, err := p.IsPinned(ctx, b.Cid()); err != nil || !pinned || mode != "recursive" {
+		t.Fatalf("expected %s to stay pinned, got %q (err: %v)", b.Cid(), mode, err)
+	}
+
+	removed, err := p.ReapExpired(ctx, bstore.NewGCLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !removed[0].Equals(a.Cid()) {
+		t.Fatalf("expected %s to be reaped, got %v", a.Cid(), removed)
+	}
+	if _, pinned, err := p.IsPinned(ctx, a.Cid()); err != nil || pinned {
+		t.Fatalf("expected %s to be unpinned (err: %v)", a.Cid(), err)
+	}
+	if m, err := p.Store().Get(ctx, a.Cid()); err != nil || !m.IsZero() {
+		t.Fatalf("expected metadata of %s to be removed (err: %v)", a.Cid(), err)
+	}
+}
+
+func TestPinClearsExpiry(t *testing.T) {
+	ctx := context.Background()
+	p, a, _ := newTestPinner(t)
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	if err := p.Store().Put(ctx, a.Cid(), Metadata{Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	m, err := p.Store().Get(ctx, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Expires != nil {
+		t.Fatalf("expected pinning again to make the pin permanent, expires %s", m.Expires)
+	}
+}
+
+func TestPinWithMetadataKeepsPermanentPins(t *testing.T) {
+	ctx := context.Background()
+	p, a, b := newTestPinner(t)
+
+	if err := p.Pin(ctx, a, false); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := p.PinWithMetadata(ctx, nd, true, Metadata{Name: "n", Expires: &expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := p.Store().Get(ctx, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Expires != nil || m.Name != "n" {
+		t.Fatalf("expected the permanent pin to stay permanent and be named, got %+v", m)
+	}
+	if m, err = p.Store().Get(ctx, b.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if m.Expires == nil || !m.Expires.Equal(expires) {
+		t.Fatalf("expected the new pin to expire, got %+v", m)
+	}
+
+	// Expiring pins take the new expiry.
+	later := expires.Add(time.Hour)
+	if err := p.PinWithMetadata(ctx, b, true, Metadata{Expires: &later}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = p.Store().Get(ctx, b.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if m.Expires == nil || !m.Expires.Equal(later) || m.Name != "n" {
+		t.Fatalf("expected the expiry of the pin to be updated, got %+v", m)
+	}
+}
+
+func TestUpdateKeepsMetadata(t *testing.T) {
+	ctx := context.Background()
+	p, a, b := newTestPinner(t)
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	meta := Metadata{Name: "site", Labels: map[string]string{"owner": "alice"}}
+	if err := p.Store().Put(ctx, a.Cid(), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Update(ctx, a.Cid(), b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := p.Store().Get(ctx, b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "site" || m.Labels["owner"] != "alice" {
+		t.Fatalf("expected metadata to move with the pin, got %+v", m)
+	}
+	if m, err := p.Store().Get(ctx, a.Cid()); err != nil || !m.IsZero() {
+		t.Fatalf("expected metadata of the old pin to be removed, got %+v (err: %v)", m, err)
+	}
+}
+
+func TestExpiredPinsDoNotPinChildren(t *testing.T) {
+	ctx := context.Background()
+	p, a, b := newTestPinner(t)
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	if err := a.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.dserv.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := p.CheckIfPinned(ctx, b.Cid()); err != nil || res[0].Mode != pin.Indirect {
+		t.Fatalf("expected %s to be pinned indirectly, got %v (err: %v)", b.Cid(), res, err)
+	}
+
+	// The expiry is indexed after the first lookup.
+	expires := now.Add(time.Hour)
+	if err := p.Store().Put(ctx, a.Cid(), Metadata{Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	now = expires
+
+	res, err := p.CheckIfPinned(ctx, a.Cid(), b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Mode != pin.NotPinned {
+			t.Fatalf("expected %s to be unpinned once %s expired, got %s", r.Key, a.Cid(), r.String())
+		}
+	}
+	if _, pinned, err := p.IsPinnedWithType(ctx, b.Cid(), pin.Indirect); err != nil || pinned {
+		t.Fatalf("expected %s not to be pinned indirectly (err: %v)", b.Cid(), err)
+	}
+}
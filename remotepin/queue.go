@@ -0,0 +1,521 @@
+// Package remotepin queues requests to remote pinning services in the repo,
+// so that they are not lost when a service is down or the node restarts.
+package remotepin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	config "github.com/ipfs/kubo/config"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var log = logging.Logger("remotepinning/queue")
+
+// Prefix is the datastore namespace under which queued jobs are kept.
+var Prefix = ds.NewKey("/local/pins/remote/queue")
+
+const (
+	// MaxAttempts is the number of times a job is tried before it is marked
+	// as failed, and only retried on request.
+	MaxAttempts = 10
+
+	// MinRetryDelay and MaxRetryDelay bound the delay before a service is
+	// tried again after a failed job.
+	MinRetryDelay = 5 * time.Second
+	MaxRetryDelay = 30 * time.Minute
+
+	// attemptTimeout bounds the time spent on a single attempt of a job.
+	attemptTimeout = 2 * time.Minute
+
+	// pollInterval is how often the queue looks for jobs that are ready to
+	// be tried again.
+	pollInterval = time.Second
+)
+
+// ErrJobNotFound is returned for operations on jobs which are not queued.
+var ErrJobNotFound = errors.New("job not found in the remote pin queue")
+
+// Op is the operation a job performs on a remote pinning service.
+type Op string
+
+const (
+	// OpAdd pins a cid on the service.
+	OpAdd Op = "add"
+	// OpRm removes the pins of a cid from the service.
+	OpRm Op = "rm"
+)
+
+// State is the state of a queued job.
+type State string
+
+const (
+	// StateQueued jobs are tried until they succeed, or fail MaxAttempts
+	// times.
+	StateQueued State = "queued"
+	// StateFailed jobs have failed MaxAttempts times and wait to be retried
+	// or canceled.
+	StateFailed State = "failed"
+	// StateDone jobs were accepted by the service. Only jobs with KeepDone
+	// set are kept in this state, until they are canceled.
+	StateDone State = "done"
+)
+
+// Job is a request to a remote pinning service. Jobs are removed from the
+// queue once the service has accepted them, unless KeepDone is set.
+type Job struct {
+	ID      string
+	Service string // name of the service in Pinning.RemoteServices
+	Op      Op
+	Cid     cid.Cid
+
+	// Name is the name of the pin to add. For OpRm, only the pins with this
+	// name are removed when set.
+	Name string `json:",omitempty"`
+
+	// Statuses are the statuses of the pins removed by OpRm, all of them
+	// when empty.
+	Statuses []pinclient.Status `json:",omitempty"`
+
+	// Force allows OpRm to remove more than one pin. Without it, the job
+	// fails when several pins match.
+	Force bool `json:",omitempty"`
+
+	// Origins are the multiaddrs the service can fetch the data from.
+	Origins []string `json:",omitempty"`
+
+	// Replace makes OpAdd replace the pin with the same Name, if any, instead
+	// of adding a new one.
+	Replace bool `json:",omitempty"`
+
+	// Key identifies jobs which supersede each other. Queueing a job removes
+	// the jobs with the same Service and Key from the queue.
+	Key string `json:",omitempty"`
+
+	// KeepDone keeps the job in the queue in StateDone once the service
+	// accepted it, so that whoever queued it can tell it succeeded rather
+	// than was canceled.
+	KeepDone bool `json:",omitempty"`
+
+	State       State
+	Created     time.Time
+	Attempts    int        `json:",omitempty"`
+	LastAttempt *time.Time `json:",omitempty"`
+	LastError   string     `json:",omitempty"`
+}
+
+// client is the part of pinclient.Client used by the queue.
+type client interface {
+	LsSync(ctx context.Context, opts ...pinclient.LsOption) ([]pinclient.PinStatusGetter, error)
+	Add(ctx context.Context, c cid.Cid, opts ...pinclient.AddOption) (pinclient.PinStatusGetter, error)
+	Replace(ctx context.Context, pinID string, c cid.Cid, opts ...pinclient.AddOption) (pinclient.PinStatusGetter, error)
+	DeleteByID(ctx context.Context, pinID string) error
+}
+
+// Queue is a persistent queue of jobs for remote pinning services. Jobs are
+// processed in order for each service, services independently of each
+// other, and services are tried again with an exponential backoff when one
+// of their jobs fails.
+type Queue struct {
+	ds     ds.Datastore
+	config func() (*config.Config, error)
+
+	newClient func(endpoint, key string) client
+
+	// mu protects the jobs in ds, backoffs and running.
+	mu       sync.Mutex
+	backoffs map[string]*serviceBackoff
+	running  map[string]bool // services whose jobs are being processed
+
+	workers sync.WaitGroup
+	wake    chan struct{}
+}
+
+type serviceBackoff struct {
+	backoff.BackOff
+	next time.Time
+}
+
+// NewQueue creates a Queue keeping its jobs in d, under Prefix. The remote
+// services are looked up in the config returned by cfg.
+func NewQueue(d ds.Datastore, cfg func() (*config.Config, error)) *Queue {
+	return &Queue{
+		ds:     namespace.Wrap(d, Prefix),
+		config: cfg,
+		newClient: func(endpoint, key string) client {
+			return pinclient.NewClient(endpoint, key)
+		},
+		backoffs: make(map[string]*serviceBackoff),
+		running:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds a job to the queue, and returns it with its ID set. Jobs
+// with the same Service and Key are removed from the queue.
+func (q *Queue) Enqueue(ctx context.Context, j Job) (Job, error) {
+	switch {
+	case j.Service == "":
+		return j, errors.New("remote pin job has no service")
+	case j.Op != OpAdd && j.Op != OpRm:
+		return j, fmt.Errorf("invalid remote pin job operation %q", j.Op)
+	case !j.Cid.Defined():
+		return j, errors.New("remote pin job has no cid")
+	}
+
+	j.ID = uuid.New().String()
+	j.State = StateQueued
+	j.Created = time.Now().UTC()
+	j.Attempts = 0
+	j.LastAttempt = nil
+	j.LastError = ""
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if j.Key != "" {
+		jobs, err := q.jobs(ctx)
+		if err != nil {
+			return j, err
+		}
+		for _, old := range jobs {
+			if old.Service == j.Service && old.Key == j.Key {
+				if err := q.ds.Delete(ctx, ds.NewKey(old.ID)); err != nil {
+					return j, err
+				}
+			}
+		}
+	}
+
+	if err := q.put(ctx, j); err != nil {
+		return j, err
+	}
+	q.notify()
+	return j, nil
+}
+
+// Jobs returns the queued jobs, oldest first.
+func (q *Queue) Jobs(ctx context.Context) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs(ctx)
+}
+
+// Retry resets the attempts of a job and of its service, so that it is tried
+// again right away.
+func (q *Queue) Retry(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, err := q.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	j.State = StateQueued
+	j.Attempts = 0
+	if err := q.put(ctx, j); err != nil {
+		return err
+	}
+	delete(q.backoffs, j.Service)
+	q.notify()
+	return nil
+}
+
+// Cancel removes a job from the queue.
+func (q *Queue) Cancel(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.get(ctx, id); err != nil {
+		return err
+	}
+	return q.ds.Delete(ctx, ds.NewKey(id))
+}
+
+// Run processes the queue until ctx is done.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.process(ctx)
+
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			q.workers.Wait()
+			return
+		}
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// process starts trying the queued jobs of every service which is neither
+// backing off nor busy with its previous jobs. Services are processed in the
+// background, so that a slow service does not hold up the others.
+func (q *Queue) process(ctx context.Context) {
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		log.Errorf("reading remote pin queue: %s", err)
+		return
+	}
+
+	byService := make(map[string][]Job)
+	for _, j := range jobs {
+		if j.State == StateQueued {
+			byService[j.Service] = append(byService[j.Service], j)
+		}
+	}
+
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for svc, jobs := range byService {
+		if b, ok := q.backoffs[svc]; q.running[svc] || (ok && now.Before(b.next)) {
+			continue
+		}
+
+		q.running[svc] = true
+		q.workers.Add(1)
+		go func(svc string, jobs []Job) {
+			defer q.workers.Done()
+			q.processService(ctx, svc, jobs)
+
+			q.mu.Lock()
+			delete(q.running, svc)
+			q.mu.Unlock()
+			// Pick up the jobs queued meanwhile.
+			q.notify()
+		}(svc, jobs)
+	}
+}
+
+// processService tries the jobs of a service in order, and stops at the
+// first failure, unless the job is at fault rather than the service.
+func (q *Queue) processService(ctx context.Context, svc string, jobs []Job) {
+	c, err := q.client(svc)
+	for _, j := range jobs {
+		jobErr := err
+		if jobErr == nil {
+			jobErr = q.do(ctx, c, j)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		q.finish(ctx, j, jobErr)
+		if jobErr != nil && !isPermanent(jobErr) {
+			return
+		}
+	}
+}
+
+// isPermanent returns whether a job failed for reasons trying again would
+// not fix.
+func isPermanent(err error) bool {
+	var perr *backoff.PermanentError
+	return errors.As(err, &perr)
+}
+
+func (q *Queue) client(svc string) (client, error) {
+	cfg, err := q.config()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := cfg.Pinning.RemoteServices[svc]
+	if !ok {
+		return nil, fmt.Errorf("remote pinning service %q not known", svc)
+	}
+	return q.newClient(s.API.Endpoint, s.API.Key), nil
+}
+
+// allStatuses are the statuses of the remote pins looked up by jobs.
+var allStatuses = []pinclient.Status{pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned, pinclient.StatusFailed}
+
+func (q *Queue) do(ctx context.Context, c client, j Job) error {
+	ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+
+	switch j.Op {
+	case OpAdd:
+		existing := ""
+		if j.Replace && j.Name != "" {
+			pins, err := c.LsSync(ctx, pinclient.PinOpts.FilterName(j.Name), pinclient.PinOpts.FilterStatus(allStatuses...))
+			if err != nil {
+				return fmt.Errorf("error while listing remote pins: %w", err)
+			}
+			for _, ps := range pins {
+				if ps.GetPin().GetCid().Equals(j.Cid) && ps.GetStatus() != pinclient.StatusFailed {
+					log.Debugf("%s: pin %q for %s exists, skipping", j.Service, j.Name, j.Cid)
+					return nil
+				}
+				existing = ps.GetRequestId()
+			}
+		}
+
+		opts := []pinclient.AddOption{}
+		if j.Name != "" {
+			opts = append(opts, pinclient.PinOpts.WithName(j.Name))
+		}
+		for _, o := range j.Origins {
+			if addr, err := ma.NewMultiaddr(o); err == nil {
+				opts = append(opts, pinclient.PinOpts.WithOrigins(addr))
+			}
+		}
+
+		var err error
+		if existing != "" {
+			log.Debugf("%s: replacing pin %q with %s", j.Service, j.Name, j.Cid)
+			_, err = c.Replace(ctx, existing, j.Cid, opts...)
+		} else {
+			log.Debugf("%s: adding pin for %s", j.Service, j.Cid)
+			_, err = c.Add(ctx, j.Cid, opts...)
+		}
+		return err
+
+	case OpRm:
+		statuses := j.Statuses
+		if len(statuses) == 0 {
+			statuses = allStatuses
+		}
+		lsOpts := []pinclient.LsOption{pinclient.PinOpts.FilterCIDs(j.Cid), pinclient.PinOpts.FilterStatus(statuses...)}
+		if j.Name != "" {
+			lsOpts = append(lsOpts, pinclient.PinOpts.FilterName(j.Name))
+		}
+		pins, err := c.LsSync(ctx, lsOpts...)
+		if err != nil {
+			return fmt.Errorf("error while listing remote pins: %w", err)
+		}
+		if len(pins) > 1 && !j.Force {
+			return backoff.Permanent(errors.New("multiple remote pins are matching this query, queue the removal with --force to confirm the bulk removal"))
+		}
+		for _, ps := range pins {
+			if err := c.DeleteByID(ctx, ps.GetRequestId()); err != nil {
+				return fmt.Errorf("removing pin identified by requestid=%q failed: %w", ps.GetRequestId(), err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid remote pin job operation %q", j.Op)
+}
+
+// finish records the outcome of an attempt. Jobs are removed once they
+// succeed, and their service backs off when they fail. Jobs failing
+// permanently are marked as failed right away, without backing off.
+func (q *Queue) finish(ctx context.Context, j Job, jobErr error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	permanent := isPermanent(jobErr)
+	switch {
+	case jobErr == nil:
+		delete(q.backoffs, j.Service)
+	case !permanent:
+		b, ok := q.backoffs[j.Service]
+		if !ok {
+			eb := backoff.NewExponentialBackOff()
+			eb.InitialInterval = MinRetryDelay
+			eb.MaxInterval = MaxRetryDelay
+			eb.MaxElapsedTime = 0
+			b = &serviceBackoff{BackOff: eb}
+			q.backoffs[j.Service] = b
+		}
+		b.next = time.Now().Add(b.NextBackOff())
+	}
+
+	// The job may have been canceled or superseded meanwhile.
+	if _, err := q.get(ctx, j.ID); err != nil {
+		return
+	}
+
+	var err error
+	switch {
+	case jobErr == nil && j.KeepDone:
+		j.State = StateDone
+		err = q.put(ctx, j)
+	case jobErr == nil:
+		err = q.ds.Delete(ctx, ds.NewKey(j.ID))
+	default:
+		now := time.Now().UTC()
+		j.Attempts++
+		j.LastAttempt = &now
+		j.LastError = jobErr.Error()
+		if j.Attempts >= MaxAttempts || permanent {
+			j.State = StateFailed
+		}
+		log.Errorf("%s: %s %s failed (attempt %d): %s", j.Service, j.Op, j.Cid, j.Attempts, jobErr)
+		err = q.put(ctx, j)
+	}
+	if err != nil {
+		log.Errorf("updating remote pin queue: %s", err)
+	}
+}
+
+func (q *Queue) get(ctx context.Context, id string) (Job, error) {
+	var j Job
+	b, err := q.ds.Get(ctx, ds.NewKey(id))
+	if err == ds.ErrNotFound {
+		return j, ErrJobNotFound
+	}
+	if err != nil {
+		return j, err
+	}
+	return j, json.Unmarshal(b, &j)
+}
+
+func (q *Queue) jobs(ctx context.Context) ([]Job, error) {
+	res, err := q.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var jobs []Job
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var j Job
+		if err := json.Unmarshal(r.Value, &j); err != nil {
+			log.Errorf("skipping invalid job %s: %s", r.Key, err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Created.Before(jobs[k].Created)
+	})
+	return jobs, nil
+}
+
+func (q *Queue) put(ctx context.Context, j Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	key := ds.NewKey(j.ID)
+	if err := q.ds.Put(ctx, key, b); err != nil {
+		return err
+	}
+	return q.ds.Sync(ctx, key)
+}
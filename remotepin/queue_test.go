@@ -0,0 +1,321 @@
+package remotepin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dag "github.com/ipfs/go-merkledag"
+	pinclient "github.com/ipfs/go-pinning-service-http-client"
+	config "github.com/ipfs/kubo/config"
+)
+
+type testClient struct {
+	mu      sync.Mutex
+	err     error
+	added   []cid.Cid
+	pins    []pinclient.PinStatusGetter // listed by LsSync
+	deleted []string
+
+	block chan struct{} // holds Add until closed, if set
+}
+
+// testPinStatus is a remote pin identified by its request ID.
+type testPinStatus struct {
+	pinclient.PinStatusGetter
+	id string
+}
+
+func (ps testPinStatus) GetRequestId() string {
+	return ps.id
+}
+
+func (c *testClient) LsSync(ctx context.Context, opts ...pinclient.LsOption) ([]pinclient.PinStatusGetter, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pins, nil
+}
+
+func (c *testClient) Add(ctx context.Context, k cid.Cid, opts ...pinclient.AddOption) (pinclient.PinStatusGetter, error) {
+	if c.block != nil {
+		<-c.block
+	}
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.added = append(c.added, k)
+	return nil, nil
+}
+
+func (c *testClient) Replace(ctx context.Context, pinID string, k cid.Cid, opts ...pinclient.AddOption) (pinclient.PinStatusGetter, error) {
+	return c.Add(ctx, k, opts...)
+}
+
+func (c *testClient) DeleteByID(ctx context.Context, pinID string) error {
+	if err := c.fail(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, pinID)
+	return nil
+}
+
+func (c *testClient) addedCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.added)
+}
+
+func (c *testClient) fail() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func newTestQueue() (*Queue, *testClient) {
+	cfg := &config.Config{}
+	cfg.Pinning.RemoteServices = map[string]config.RemotePinningService{
+		"svc": {API: config.RemotePinningServiceAPI{Endpoint: "http://127.0.0.1:1"}},
+	}
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), func() (*config.Config, error) {
+		return cfg, nil
+	})
+	c := &testClient{}
+	q.newClient = func(endpoint, key string) client {
+		return c
+	}
+	return q, c
+}
+
+// processAll tries the queued jobs and waits for the services to be done.
+func processAll(ctx context.Context, q *Queue) {
+	q.process(ctx)
+	q.workers.Wait()
+}
+
+func testCid(data string) cid.Cid {
+	return dag.NodeWithData([]byte(data)).Cid()
+}
+
+func TestQueueSupersedesByKey(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestQueue()
+
+	first, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: testCid("a"), Key: "mfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: testCid("b")}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: testCid("c"), Key: "mfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 queued jobs, got %d", len(jobs))
+	}
+	for _, j := range jobs {
+		if j.ID == first.ID {
+			t.Fatal("expected the first job to be superseded")
+		}
+	}
+	if jobs[1].ID != second.ID {
+		t.Fatal("expected jobs to be listed in order")
+	}
+}
+
+func TestQueueRetriesFailedJobs(t *testing.T) {
+	ctx := context.Background()
+	q, c := newTestQueue()
+	c.err = errors.New("service unavailable")
+
+	k := testCid("a")
+	j, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: k})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processAll(ctx, q)
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Attempts != 1 || jobs[0].LastError != "service unavailable" {
+		t.Fatalf("expected the failure to be recorded, got %+v", jobs)
+	}
+
+	// The service backs off, so nothing is tried until the job is retried.
+	c.err = nil
+	processAll(ctx, q)
+	if len(c.added) != 0 {
+		t.Fatal("expected the service to back off after a failure")
+	}
+
+	if err := q.Retry(ctx, j.ID); err != nil {
+		t.Fatal(err)
+	}
+	processAll(ctx, q)
+	if len(c.added) != 1 || !c.added[0].Equals(k) {
+		t.Fatalf("expected %s to be pinned, got %v", k, c.added)
+	}
+	if jobs, err := q.Jobs(ctx); err != nil || len(jobs) != 0 {
+		t.Fatalf("expected the job to leave the queue, got %v (err: %v)", jobs, err)
+	}
+}
+
+func TestQueueKeepsDoneJobs(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestQueue()
+
+	kept, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: testCid("a"), KeepDone: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpAdd, Cid: testCid("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	processAll(ctx, q)
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != kept.ID || jobs[0].State != StateDone {
+		t.Fatalf("expected only the job kept once done to be left, got %+v", jobs)
+	}
+
+	if err := q.Cancel(ctx, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, err := q.Jobs(ctx); err != nil || len(jobs) != 0 {
+		t.Fatalf("expected the done job to be canceled, got %v (err: %v)", jobs, err)
+	}
+}
+
+func TestQueueMarksJobsFailed(t *testing.T) {
+	ctx := context.Background()
+	q, c := newTestQueue()
+	c.err = errors.New("service unavailable")
+
+	j, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpRm, Cid: testCid("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxAttempts; i++ {
+		delete(q.backoffs, "svc")
+		processAll(ctx, q)
+	}
+
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].State != StateFailed {
+		t.Fatalf("expected the job to be marked as failed, got %+v", jobs)
+	}
+
+	if err := q.Cancel(ctx, j.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(ctx, j.ID); err != ErrJobNotFound {
+		t.Fatalf("expected canceling twice to fail, got %v", err)
+	}
+}
+
+func TestQueueRmNeedsForceForManyPins(t *testing.T) {
+	ctx := context.Background()
+	q, c := newTestQueue()
+	c.pins = []pinclient.PinStatusGetter{testPinStatus{id: "1"}, testPinStatus{id: "2"}}
+
+	if _, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpRm, Cid: testCid("a")}); err != nil {
+		t.Fatal(err)
+	}
+	forced, err := q.Enqueue(ctx, Job{Service: "svc", Op: OpRm, Cid: testCid("b"), Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processAll(ctx, q)
+
+	// The job at fault fails right away, without holding up the service.
+	jobs, err := q.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID == forced.ID || jobs[0].State != StateFailed || jobs[0].Attempts != 1 {
+		t.Fatalf("expected only the unforced removal to fail, got %+v", jobs)
+	}
+	if len(c.deleted) != 2 {
+		t.Fatalf("expected the forced removal to remove both pins, got %v", c.deleted)
+	}
+	if _, ok := q.backoffs["svc"]; ok {
+		t.Fatal("expected the service not to back off")
+	}
+}
+
+func TestQueueProcessesServicesIndependently(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.Pinning.RemoteServices = map[string]config.RemotePinningService{
+		"slow": {API: config.RemotePinningServiceAPI{Endpoint: "http://slow"}},
+		"fast": {API: config.RemotePinningServiceAPI{Endpoint: "http://fast"}},
+	}
+	q := NewQueue(dssync.MutexWrap(ds.NewMapDatastore()), func() (*config.Config, error) {
+		return cfg, nil
+	})
+	slow, fast := &testClient{block: make(chan struct{})}, &testClient{}
+	q.newClient = func(endpoint, key string) client {
+		if endpoint == "http://slow" {
+			return slow
+		}
+		return fast
+	}
+
+	for _, svc := range []string{"slow", "fast"} {
+		if _, err := q.Enqueue(ctx, Job{Service: svc, Op: OpAdd, Cid: testCid("a")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.process(ctx)
+	waitAdded(t, fast, 1)
+
+	// The fast service keeps going while the slow one is busy.
+	if _, err := q.Enqueue(ctx, Job{Service: "fast", Op: OpAdd, Cid: testCid("b")}); err != nil {
+		t.Fatal(err)
+	}
+	q.process(ctx)
+	waitAdded(t, fast, 2)
+
+	close(slow.block)
+	q.workers.Wait()
+	if slow.addedCount() != 1 {
+		t.Fatal("expected the slow service to finish its job")
+	}
+}
+
+func waitAdded(t *testing.T, c *testClient, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.addedCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d pins to be added, got %d", n, c.addedCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,455 @@
+// Package reprovider reannounces the keys of the node to the content routing
+// system. Unlike the reprovider of go-ipfs-provider, it spreads the
+// announcements evenly over the reprovide interval, and it keeps its
+// progress in the repo, so that a cycle interrupted by a restart resumes
+// where it stopped instead of starting over.
+package reprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/go-verifcid"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+var log = logging.Logger("reprovider")
+
+// StateKey is the datastore key under which the progress of the current
+// cycle is kept.
+var StateKey = ds.NewKey("/local/reprovider/cycle")
+
+// ErrClosed is returned by Trigger when operating on a closed reprovider.
+var ErrClosed = errors.New("reprovider service stopped")
+
+// errKeyChan is returned by cycles which could not list the keys.
+var errKeyChan = errors.New("failed to get key chan")
+
+const (
+	// initialDelay is the time waited after starting before the first
+	// announcement, as the node might be just about to stop.
+	initialDelay = time.Minute
+
+	// spreadRatio is the part of the interval over which the announcements
+	// of a cycle are spread, leaving some slack for slow announcements.
+	spreadRatio = 0.9
+
+	// provideRetries is the number of times a key is tried again before it
+	// is counted as failed.
+	provideRetries = 3
+
+	// saveEvery is the number of keys processed between two saves of the
+	// progress of the cycle.
+	saveEvery = 1000
+
+	// maxCycleRetryDelay is the longest time waited before retrying a cycle
+	// which could not list the keys.
+	maxCycleRetryDelay = time.Hour
+)
+
+// state is the progress of the reprovider, persisted in the datastore.
+type state struct {
+	// CycleStart is the start of the cycle in progress, zero between cycles.
+	CycleStart time.Time
+	// Position is the number of keys processed in the cycle in progress,
+	// in the order of the key provider.
+	Position int64
+	Provided int64
+	Failed   int64
+
+	LastCycleStart  time.Time
+	LastCycleEnd    time.Time
+	LastCycleKeys   int64
+	LastCycleFailed int64
+}
+
+// Stats are the statistics of the reprovider.
+type Stats struct {
+	CycleStart    time.Time     // start of the cycle in progress, zero between cycles
+	CycleProvided int64         // keys announced in the cycle in progress
+	CycleFailed   int64         // keys that could not be announced in it
+	CycleKeys     int64         // keys expected in it, as many as in the last cycle
+	ETA           time.Duration // estimated time left in it, zero when unknown
+	NextCycle     time.Time     // start of the next cycle, between cycles
+
+	LastCycleStart    time.Time
+	LastCycleDuration time.Duration
+	LastCycleKeys     int64
+	LastCycleFailed   int64
+
+	TotalProvided int64 // keys announced since the node started
+	TotalFailed   int64 // keys that could not be announced since the node started
+}
+
+// Reprovider reannounces the keys of a key provider every interval.
+type Reprovider struct {
+	// Reprovider context. Cancel to stop, then wait on closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed chan struct{}
+
+	// trigger triggers a reprovide.
+	trigger chan chan<- error
+
+	rsys        routing.ContentRouting
+	keyProvider simple.KeyChanFunc
+	interval    time.Duration
+	dstore      ds.Datastore
+	newBackOff  func() backoff.BackOff
+	// initialDelay and cycleBackOff delay the first cycle, and the cycles
+	// retried after failing to list the keys.
+	initialDelay time.Duration
+	cycleBackOff backoff.BackOff
+
+	mu    sync.Mutex
+	st    state
+	next  time.Time
+	total struct{ provided, failed int64 }
+	// resumedAt and resumedPos are when, and from which position, the
+	// cycle in progress was last (re)started, to estimate its rate.
+	resumedAt  time.Time
+	resumedPos int64
+}
+
+// New returns a reprovider announcing the keys of keyProvider to rsys every
+// interval, keeping its progress in dstore. Reproviding is only triggered
+// manually when the interval is zero.
+func New(ctx context.Context, interval time.Duration, rsys routing.ContentRouting, keyProvider simple.KeyChanFunc, dstore ds.Datastore) *Reprovider {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Reprovider{
+		ctx:     ctx,
+		cancel:  cancel,
+		closed:  make(chan struct{}),
+		trigger: make(chan chan<- error),
+
+		rsys:        rsys,
+		keyProvider: keyProvider,
+		interval:    interval,
+		dstore:      dstore,
+		newBackOff: func() backoff.BackOff {
+			return backoff.WithMaxRetries(backoff.NewExponentialBackOff(), provideRetries)
+		},
+		initialDelay: initialDelay,
+		cycleBackOff: newCycleBackOff(),
+	}
+}
+
+func newCycleBackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = initialDelay
+	b.MaxInterval = maxCycleRetryDelay
+	b.MaxElapsedTime = 0 // retry forever
+	b.Reset()
+	return b
+}
+
+// Close stops the reprovider. The progress of the cycle in progress is kept.
+func (r *Reprovider) Close() error {
+	r.cancel()
+	<-r.closed
+	return nil
+}
+
+// Run reprovides keys every interval, or when triggered, until closed.
+func (r *Reprovider) Run() {
+	defer close(r.closed)
+
+	if err := r.load(); err != nil {
+		log.Errorf("failed to load the reprovider state: %s", err)
+	}
+
+	earliest := time.Now().Add(r.initialDelay)
+	for r.ctx.Err() == nil {
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if r.interval > 0 {
+			timer = time.NewTimer(time.Until(r.schedule(earliest)))
+			timerCh = timer.C
+		}
+
+		var done chan<- error
+		select {
+		case <-timerCh:
+		case done = <-r.trigger:
+		case <-r.ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		// Triggered cycles announce everything as fast as possible, and so
+		// do paced cycles once triggered.
+		triggered, err := r.reprovide(done == nil)
+		if done == nil {
+			done = triggered
+		}
+
+		// A cycle which could not list the keys is retried with a backoff,
+		// instead of right away.
+		earliest = time.Now()
+		if errors.Is(err, errKeyChan) {
+			earliest = earliest.Add(r.cycleBackOff.NextBackOff())
+		} else {
+			r.cycleBackOff.Reset()
+		}
+
+		// only log if we've hit an actual error, otherwise just tell the client we're shutting down
+		if r.ctx.Err() != nil {
+			err = ErrClosed
+		} else if err != nil {
+			log.Errorf("failed to reprovide: %s", err)
+		}
+
+		if done != nil {
+			if err != nil {
+				done <- err
+			}
+			close(done)
+		}
+	}
+}
+
+// Trigger starts a cycle in Run and waits for it to finish. A paced cycle in
+// progress announces its remaining keys right away instead.
+//
+// Returns an error if a triggered cycle is already in progress.
+func (r *Reprovider) Trigger(ctx context.Context) error {
+	resultCh := make(chan error, 1)
+	select {
+	case r.trigger <- resultCh:
+	default:
+		return fmt.Errorf("reprovider is already running")
+	}
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-r.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// schedule returns the start of the next cycle, which is not before
+// earliest: right away when a cycle was interrupted, one interval after the
+// start of the last one otherwise.
+func (r *Reprovider) schedule(earliest time.Time) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := earliest
+	if r.st.CycleStart.IsZero() && !r.st.LastCycleStart.IsZero() {
+		if t := r.st.LastCycleStart.Add(r.interval); t.After(next) {
+			next = t
+		}
+	}
+	r.next = next
+	return next
+}
+
+// reprovide announces the keys of the key provider. A paced cycle resumes
+// the interrupted one, if any, and spreads the announcements over the
+// interval.
+//
+// The keys already processed by an interrupted cycle are skipped by
+// position, relying on the key provider listing keys in a stable order. The
+// keys shifted by changes made meanwhile are announced in the next cycle.
+//
+// A paced cycle stops pacing when triggered, and returns the channel of the
+// trigger to report its outcome to.
+func (r *Reprovider) reprovide(paced bool) (chan<- error, error) {
+	now := time.Now()
+	r.mu.Lock()
+	resume := paced && !r.st.CycleStart.IsZero() && now.Before(r.st.CycleStart.Add(r.interval))
+	if !resume {
+		r.st.CycleStart = now
+		r.st.Position, r.st.Provided, r.st.Failed = 0, 0, 0
+	}
+	skip := r.st.Position
+	cycleStart := r.st.CycleStart
+	expected := r.st.LastCycleKeys
+	r.resumedAt, r.resumedPos = now, skip
+	r.next = time.Time{}
+	r.mu.Unlock()
+	if resume {
+		log.Infof("resuming the reprovide cycle started at %s after %d keys", cycleStart.Format(time.RFC3339), skip)
+	}
+	r.save()
+
+	keys, err := r.keyProvider(r.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errKeyChan, err)
+	}
+
+	var triggered chan<- error
+	var pos int64
+	for c := range keys {
+		if pos < skip {
+			pos++
+			continue
+		}
+		if r.ctx.Err() != nil {
+			break
+		}
+		if paced {
+			var err error
+			if triggered, err = r.waitFor(keyTime(cycleStart, r.interval, expected, pos)); err != nil {
+				break
+			}
+			paced = triggered == nil
+		}
+
+		err := r.provide(c)
+		if err != nil && r.ctx.Err() != nil {
+			break
+		}
+		pos++
+
+		r.mu.Lock()
+		r.st.Position = pos
+		if err != nil {
+			log.Debugf("failed to provide key %s: %s", c, err)
+			r.st.Failed++
+			r.total.failed++
+		} else {
+			r.st.Provided++
+			r.total.provided++
+		}
+		r.mu.Unlock()
+		if pos%saveEvery == 0 {
+			r.save()
+		}
+	}
+	if err := r.ctx.Err(); err != nil {
+		// The key provider stops on cancellation: keep the progress so the
+		// cycle can be resumed.
+		r.save()
+		return triggered, err
+	}
+
+	r.mu.Lock()
+	r.st.LastCycleStart = r.st.CycleStart
+	r.st.LastCycleEnd = time.Now()
+	r.st.LastCycleKeys = r.st.Position
+	r.st.LastCycleFailed = r.st.Failed
+	r.st.CycleStart = time.Time{}
+	r.st.Position, r.st.Provided, r.st.Failed = 0, 0, 0
+	failed := r.st.LastCycleFailed
+	r.mu.Unlock()
+	r.save()
+
+	if failed > 0 {
+		return triggered, fmt.Errorf("failed to provide %d keys", failed)
+	}
+	return triggered, nil
+}
+
+// keyTime returns the time at which the key at pos is announced, spreading
+// the expected keys over the interval. Keys over the expected number are
+// announced right away.
+func keyTime(cycleStart time.Time, interval time.Duration, expected, pos int64) time.Time {
+	if expected <= 0 || pos >= expected {
+		return cycleStart
+	}
+	span := int64(float64(interval) * spreadRatio)
+	return cycleStart.Add(time.Duration(span / expected * pos))
+}
+
+// waitFor waits until t, or until triggered, in which case it returns the
+// channel of the trigger.
+func (r *Reprovider) waitFor(t time.Time) (chan<- error, error) {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil, nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, nil
+	case done := <-r.trigger:
+		return done, nil
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+}
+
+func (r *Reprovider) provide(c cid.Cid) error {
+	// hash security
+	if err := verifcid.ValidateCid(c); err != nil {
+		return fmt.Errorf("insecure hash: %w", err)
+	}
+	return backoff.Retry(func() error {
+		return r.rsys.Provide(r.ctx, c, true)
+	}, backoff.WithContext(r.newBackOff(), r.ctx))
+}
+
+// Stat returns the statistics of the reprovider.
+func (r *Reprovider) Stat() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Stats{
+		CycleStart:      r.st.CycleStart,
+		CycleProvided:   r.st.Provided,
+		CycleFailed:     r.st.Failed,
+		LastCycleStart:  r.st.LastCycleStart,
+		LastCycleKeys:   r.st.LastCycleKeys,
+		LastCycleFailed: r.st.LastCycleFailed,
+		TotalProvided:   r.total.provided,
+		TotalFailed:     r.total.failed,
+	}
+	if !r.st.LastCycleEnd.IsZero() {
+		s.LastCycleDuration = r.st.LastCycleEnd.Sub(r.st.LastCycleStart)
+	}
+	if r.st.CycleStart.IsZero() {
+		s.NextCycle = r.next
+		return s
+	}
+
+	s.CycleKeys = r.st.LastCycleKeys
+	done := r.st.Position - r.resumedPos
+	left := s.CycleKeys - r.st.Position
+	if done > 0 && left > 0 && !r.resumedAt.IsZero() {
+		s.ETA = time.Since(r.resumedAt) / time.Duration(done) * time.Duration(left)
+	}
+	return s
+}
+
+func (r *Reprovider) load() error {
+	data, err := r.dstore.Get(r.ctx, StateKey)
+	if err != nil {
+		if errors.Is(err, ds.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Unmarshal(data, &r.st)
+}
+
+func (r *Reprovider) save() {
+	r.mu.Lock()
+	data, err := json.Marshal(&r.st)
+	r.mu.Unlock()
+	if err != nil {
+		log.Errorf("failed to encode the reprovider state: %s", err)
+		return
+	}
+	// The context may be canceled when saving on close.
+	if err := r.dstore.Put(context.Background(), StateKey, data); err != nil {
+		log.Errorf("failed to save the reprovider state: %s", err)
+	}
+}
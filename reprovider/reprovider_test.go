@@ -0,0 +1,219 @@
+package reprovider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type testRouter struct {
+	mu       sync.Mutex
+	provided []cid.Cid
+	fail     map[cid.Cid]bool
+	// stopAfter cancels the reprovider after that many provides.
+	stopAfter int
+	stop      func()
+}
+
+func (r *testRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail[c] {
+		return errors.New("provide failed")
+	}
+	r.provided = append(r.provided, c)
+	if r.stopAfter > 0 && len(r.provided) == r.stopAfter {
+		r.stop()
+	}
+	return nil
+}
+
+func (r *testRouter) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	return nil
+}
+
+func testKeys(n int) []cid.Cid {
+	keys := make([]cid.Cid, n)
+	for i := range keys {
+		keys[i] = dag.NewRawNode([]byte{byte(i)}).Cid()
+	}
+	return keys
+}
+
+func keyProvider(keys []cid.Cid) func(context.Context) (<-chan cid.Cid, error) {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for _, k := range keys {
+				select {
+				case ch <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+}
+
+func newTestReprovider(rt *testRouter, keys []cid.Cid, dstore ds.Datastore) *Reprovider {
+	r := New(context.Background(), time.Hour, rt, keyProvider(keys), dstore)
+	r.newBackOff = func() backoff.BackOff {
+		return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 1)
+	}
+	rt.stop = r.cancel
+	return r
+}
+
+func TestReprovideResume(t *testing.T) {
+	keys := testKeys(10)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// A first cycle completes, so the second one knows how many keys to
+	// expect.
+	rt := &testRouter{}
+	r := newTestReprovider(rt, keys, dstore)
+	if _, err := r.reprovide(false); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Stat(); s.LastCycleKeys != 10 || s.TotalProvided != 10 || !s.CycleStart.IsZero() {
+		t.Fatalf("unexpected stats after the first cycle: %+v", s)
+	}
+
+	// The second one is interrupted after 4 keys.
+	rt = &testRouter{stopAfter: 4}
+	r = newTestReprovider(rt, keys, dstore)
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	r.interval = time.Nanosecond // announce right away
+	if _, err := r.reprovide(true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cycle to be canceled, got %v", err)
+	}
+
+	// After a restart, it resumes after them.
+	rt = &testRouter{fail: map[cid.Cid]bool{keys[7]: true}}
+	r = newTestReprovider(rt, keys, dstore)
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	if r.st.Position != 4 || r.st.CycleStart.IsZero() {
+		t.Fatalf("unexpected persisted state %+v", r.st)
+	}
+	if s := r.Stat(); s.CycleProvided != 4 || s.CycleKeys != 10 {
+		t.Fatalf("unexpected stats of the interrupted cycle: %+v", s)
+	}
+	// Behind schedule, the remaining keys are announced right away.
+	r.st.CycleStart = r.st.CycleStart.Add(-50 * time.Minute)
+	if _, err := r.reprovide(true); err == nil {
+		t.Fatal("expected an error for the failed key")
+	}
+	if len(rt.provided) != 5 || !rt.provided[0].Equals(keys[4]) {
+		t.Fatalf("expected the keys after the 4th but the failed one to be provided, got %v", rt.provided)
+	}
+	s := r.Stat()
+	if s.LastCycleKeys != 10 || s.LastCycleFailed != 1 || s.TotalProvided != 5 || s.TotalFailed != 1 {
+		t.Fatalf("unexpected stats after the resumed cycle: %+v", s)
+	}
+}
+
+func TestReprovideTriggerRestarts(t *testing.T) {
+	keys := testKeys(5)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	rt := &testRouter{stopAfter: 2}
+	r := newTestReprovider(rt, keys, dstore)
+	r.interval = time.Nanosecond
+	_, _ = r.reprovide(true)
+
+	// Triggered cycles start over.
+	rt = &testRouter{}
+	r = newTestReprovider(rt, keys, dstore)
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reprovide(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.provided) != 5 {
+		t.Fatalf("expected all the keys to be provided, got %d", len(rt.provided))
+	}
+}
+
+func TestReprovideTriggerHurriesPacedCycle(t *testing.T) {
+	keys := testKeys(10)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	rt := &testRouter{}
+	r := newTestReprovider(rt, keys, dstore)
+	if _, err := r.reprovide(false); err != nil {
+		t.Fatal(err)
+	}
+	// A paced cycle is in progress, with a key to announce every few minutes.
+	r.st.CycleStart = time.Now()
+	r.st.Position = 1
+	r.save()
+
+	r.initialDelay = 0
+	go r.Run()
+	defer r.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Trigger(ctx); err != nil {
+		t.Fatalf("expected the trigger to finish the paced cycle, got %v", err)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if len(rt.provided) != 19 || !rt.provided[10].Equals(keys[1]) {
+		t.Fatalf("expected the remaining keys of the paced cycle to be provided, got %d keys", len(rt.provided))
+	}
+}
+
+func TestReprovideKeyChanFailureBacksOff(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	var mu sync.Mutex
+	var calls int
+	r := New(context.Background(), time.Hour, &testRouter{}, func(context.Context) (<-chan cid.Cid, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return nil, errors.New("no keys")
+	}, dstore)
+	r.initialDelay = 0
+	r.cycleBackOff = backoff.NewConstantBackOff(100 * time.Millisecond)
+	go r.Run()
+
+	time.Sleep(250 * time.Millisecond)
+	r.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if calls < 1 || calls > 3 {
+		t.Fatalf("expected the failed cycle to be retried after a delay, got %d tries", calls)
+	}
+}
+
+func TestKeyTime(t *testing.T) {
+	start := time.Unix(0, 0)
+	if kt := keyTime(start, 10*time.Hour, 0, 5); !kt.Equal(start) {
+		t.Errorf("keys are not spread when none are expected, got %s", kt)
+	}
+	if kt := keyTime(start, 10*time.Hour, 9, 3); kt.Sub(start) != 3*time.Hour {
+		t.Errorf("expected the 4th of 9 keys after 3h, got %s", kt.Sub(start))
+	}
+	if kt := keyTime(start, 10*time.Hour, 9, 12); !kt.Equal(start) {
+		t.Errorf("unexpected keys are announced right away, got %s", kt)
+	}
+}
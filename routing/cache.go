@@ -0,0 +1,389 @@
+package routing
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+// Datastore namespaces of the lookups persisted by cache routers, by kind.
+var (
+	cacheKindProviders = datastore.NewKey("providers")
+	cacheKindPeers     = datastore.NewKey("peers")
+)
+
+// CacheStats are the statistics of a cache router.
+type CacheStats struct {
+	Name           string
+	ProviderHits   int64
+	ProviderMisses int64
+	PeerHits       int64
+	PeerMisses     int64
+	NegativeHits   int64 // hits of cached lookups which found nothing
+	Providers      int   // cached provider lookups
+	Peers          int   // cached peer lookups
+}
+
+var (
+	cacheRoutersLk sync.Mutex
+	cacheRouters   = make(map[string]*cachingRouter)
+)
+
+// CacheStatsSnapshot returns the statistics of the cache routers, sorted by
+// name.
+func CacheStatsSnapshot() []CacheStats {
+	cacheRoutersLk.Lock()
+	defer cacheRoutersLk.Unlock()
+	out := make([]CacheStats, 0, len(cacheRouters))
+	for name, r := range cacheRouters {
+		out = append(out, CacheStats{
+			Name:           name,
+			ProviderHits:   atomic.LoadInt64(&r.providerHits),
+			ProviderMisses: atomic.LoadInt64(&r.providerMisses),
+			PeerHits:       atomic.LoadInt64(&r.peerHits),
+			PeerMisses:     atomic.LoadInt64(&r.peerMisses),
+			NegativeHits:   atomic.LoadInt64(&r.negativeHits),
+			Providers:      r.providers.len(),
+			Peers:          r.peers.len(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+var _ routing.Routing = &cachingRouter{}
+
+// cachingRouter caches the provider and peer lookups of a router, including
+// the lookups which found nothing.
+type cachingRouter struct {
+	// Accessed atomically, first for the alignment on 32-bit platforms.
+	providerHits, providerMisses int64
+	peerHits, peerMisses         int64
+	negativeHits                 int64
+
+	routing.Routing
+
+	providersTTL time.Duration
+	peersTTL     time.Duration
+	negativeTTL  time.Duration
+	records      datastore.Datastore // persisted lookups, if any
+
+	providers *lookupCache
+	peers     *lookupCache
+}
+
+// cachedLookup is the result of a lookup, as cached and persisted.
+type cachedLookup struct {
+	key     string
+	Expires time.Time
+
+	// Providers found by a provider lookup for up to Limit providers, zero
+	// meaning all of them.
+	Providers []peer.AddrInfo `json:",omitempty"`
+	Limit     int             `json:",omitempty"`
+
+	// Peer found by a peer lookup, nil when it was not found.
+	Peer *peer.AddrInfo `json:",omitempty"`
+}
+
+// covers tells whether a provider lookup for up to count providers can be
+// answered by l.
+func (l *cachedLookup) covers(count int) bool {
+	return l.Limit == 0 || len(l.Providers) < l.Limit || (count > 0 && count <= len(l.Providers))
+}
+
+func cacheRoutingFromConfig(name string, conf config.Router, router routing.Routing, extra *ExtraDHTParams) (routing.Routing, error) {
+	params := conf.Parameters.(*config.CacheRouterParams)
+	maxEntries := int(params.MaxEntries.WithDefault(config.DefaultCacheRouterMaxEntries))
+	if maxEntries <= 0 {
+		return nil, errors.New("MaxEntries of cache routers must be positive")
+	}
+
+	r := &cachingRouter{
+		Routing:      router,
+		providersTTL: params.ProvidersTTL.WithDefault(config.DefaultCacheRouterProvidersTTL),
+		peersTTL:     params.PeersTTL.WithDefault(config.DefaultCacheRouterPeersTTL),
+		negativeTTL:  params.NegativeTTL.WithDefault(config.DefaultCacheRouterNegativeTTL),
+		providers:    newLookupCache(maxEntries),
+		peers:        newLookupCache(maxEntries),
+	}
+	if params.Namespace != "" {
+		if extra == nil || extra.Datastore == nil {
+			return nil, errors.New("cache router needs a datastore to persist lookups in a namespace")
+		}
+		r.records = namespace.Wrap(extra.Datastore, datastore.NewKey(params.Namespace))
+		if err := r.removeExpired(); err != nil {
+			return nil, err
+		}
+	}
+
+	cacheRoutersLk.Lock()
+	cacheRouters[name] = r
+	cacheRoutersLk.Unlock()
+
+	if _, ok := router.(routinghelpers.ProvideManyRouter); ok {
+		return &cachingProvideManyRouter{r}, nil
+	}
+	return r, nil
+}
+
+func (r *cachingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	key := mhString(c.Hash())
+	if l, ok := r.lookup(ctx, r.providers, cacheKindProviders, key); ok && l.covers(count) {
+		atomic.AddInt64(&r.providerHits, 1)
+		if len(l.Providers) == 0 {
+			atomic.AddInt64(&r.negativeHits, 1)
+		}
+		provs := l.Providers
+		if count > 0 && len(provs) > count {
+			provs = provs[:count]
+		}
+		out := make(chan peer.AddrInfo, len(provs))
+		for _, ai := range provs {
+			out <- ai
+		}
+		close(out)
+		return out
+	}
+	atomic.AddInt64(&r.providerMisses, 1)
+
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		var found []peer.AddrInfo
+		for ai := range in {
+			found = append(found, ai)
+			select {
+			case out <- ai:
+			case <-ctx.Done():
+				return
+			}
+		}
+		// Lookups cut short are not cached.
+		if ctx.Err() != nil {
+			return
+		}
+		ttl := r.providersTTL
+		if len(found) == 0 {
+			ttl = r.negativeTTL
+		}
+		r.add(r.providers, cacheKindProviders, &cachedLookup{
+			key:       key,
+			Expires:   time.Now().Add(ttl),
+			Providers: found,
+			Limit:     count,
+		})
+	}()
+	return out
+}
+
+func (r *cachingRouter) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	key := id.String()
+	if l, ok := r.lookup(ctx, r.peers, cacheKindPeers, key); ok {
+		atomic.AddInt64(&r.peerHits, 1)
+		if l.Peer == nil {
+			atomic.AddInt64(&r.negativeHits, 1)
+			return peer.AddrInfo{}, routing.ErrNotFound
+		}
+		return *l.Peer, nil
+	}
+	atomic.AddInt64(&r.peerMisses, 1)
+
+	ai, err := r.Routing.FindPeer(ctx, id)
+	switch {
+	case err == nil:
+		r.add(r.peers, cacheKindPeers, &cachedLookup{key: key, Expires: time.Now().Add(r.peersTTL), Peer: &ai})
+	case errors.Is(err, routing.ErrNotFound):
+		r.add(r.peers, cacheKindPeers, &cachedLookup{key: key, Expires: time.Now().Add(r.negativeTTL)})
+	}
+	return ai, err
+}
+
+// lookup returns the cached lookup of key, looking for it in the datastore
+// when it is not in memory.
+func (r *cachingRouter) lookup(ctx context.Context, c *lookupCache, kind datastore.Key, key string) (*cachedLookup, bool) {
+	now := time.Now()
+	if l, ok := c.get(key); ok {
+		if now.Before(l.Expires) {
+			return l, true
+		}
+		r.remove(c, kind, key)
+		return nil, false
+	}
+	if r.records == nil {
+		return nil, false
+	}
+
+	data, err := r.records.Get(ctx, kind.ChildString(key))
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			log.Errorf("failed to read a cached lookup: %s", err)
+		}
+		return nil, false
+	}
+	l := &cachedLookup{key: key}
+	if err := json.Unmarshal(data, l); err != nil || !now.Before(l.Expires) {
+		r.remove(c, kind, key)
+		return nil, false
+	}
+	for _, evicted := range c.add(l) {
+		r.forget(kind, evicted)
+	}
+	return l, true
+}
+
+func (r *cachingRouter) add(c *lookupCache, kind datastore.Key, l *cachedLookup) {
+	for _, evicted := range c.add(l) {
+		r.forget(kind, evicted)
+	}
+	if r.records == nil {
+		return
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		log.Errorf("failed to encode a cached lookup: %s", err)
+		return
+	}
+	if err := r.records.Put(context.Background(), kind.ChildString(l.key), data); err != nil {
+		log.Errorf("failed to persist a cached lookup: %s", err)
+	}
+}
+
+func (r *cachingRouter) remove(c *lookupCache, kind datastore.Key, key string) {
+	c.remove(key)
+	r.forget(kind, key)
+}
+
+// forget removes a persisted lookup.
+func (r *cachingRouter) forget(kind datastore.Key, key string) {
+	if r.records == nil {
+		return
+	}
+	if err := r.records.Delete(context.Background(), kind.ChildString(key)); err != nil {
+		log.Errorf("failed to remove a cached lookup: %s", err)
+	}
+}
+
+// removeExpired removes the persisted lookups which expired while the node
+// was stopped.
+func (r *cachingRouter) removeExpired() error {
+	ctx := context.Background()
+	res, err := r.records.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var expired []datastore.Key
+	for e := range res.Next() {
+		if e.Error != nil {
+			res.Close()
+			return e.Error
+		}
+		var l cachedLookup
+		if err := json.Unmarshal(e.Value, &l); err != nil || !now.Before(l.Expires) {
+			expired = append(expired, datastore.RawKey(e.Key))
+		}
+	}
+	res.Close()
+	for _, k := range expired {
+		if err := r.records.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ routinghelpers.ProvideManyRouter = &cachingProvideManyRouter{}
+
+// cachingProvideManyRouter is a cachingRouter of a router supporting
+// ProvideMany.
+type cachingProvideManyRouter struct {
+	*cachingRouter
+}
+
+func (r *cachingProvideManyRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	return r.Routing.(routinghelpers.ProvideManyRouter).ProvideMany(ctx, keys)
+}
+
+func (r *cachingProvideManyRouter) Ready() bool {
+	return r.Routing.(routinghelpers.ProvideManyRouter).Ready()
+}
+
+// lookupCache is an LRU cache of lookups, bounded by their number.
+type lookupCache struct {
+	max int
+
+	mu    sync.Mutex
+	lru   *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+func newLookupCache(max int) *lookupCache {
+	return &lookupCache{
+		max:   max,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lookupCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *lookupCache) get(key string) (*cachedLookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedLookup), true
+}
+
+// add caches l, and returns the keys of the lookups evicted to make room for
+// it.
+func (c *lookupCache) add(l *cachedLookup) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[l.key]; ok {
+		e.Value = l
+		c.lru.MoveToFront(e)
+		return nil
+	}
+	var evicted []string
+	for c.lru.Len() >= c.max {
+		old := c.lru.Remove(c.lru.Back()).(*cachedLookup)
+		delete(c.items, old.key)
+		evicted = append(evicted, old.key)
+	}
+	c.items[l.key] = c.lru.PushFront(l)
+	return evicted
+}
+
+func (c *lookupCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.lru.Remove(e)
+		delete(c.items, key)
+	}
+}
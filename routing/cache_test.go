@@ -0,0 +1,142 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRouter finds testStaticPeer1 as the provider of "a" and counts
+// its lookups.
+type countingRouter struct {
+	routinghelpers.Null
+	providerLookups, peerLookups int
+}
+
+func (r *countingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	r.providerLookups++
+	out := make(chan peer.AddrInfo, 1)
+	if c.Equals(merkledag.NewRawNode([]byte("a")).Cid()) {
+		pid, _ := peer.Decode(testStaticPeer1)
+		out <- peer.AddrInfo{ID: pid}
+	}
+	close(out)
+	return out
+}
+
+func (r *countingRouter) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	r.peerLookups++
+	return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+func newTestCacheRouter(t *testing.T, inner routing.Routing, params string, dstore datastore.Batching) routing.Routing {
+	t.Helper()
+	var rp config.RouterParser
+	require.NoError(t, json.Unmarshal([]byte(`{"Type": "cache", "Parameters": `+params+`}`), &rp))
+	r, err := cacheRoutingFromConfig("cache", rp.Router, inner, &ExtraDHTParams{Datastore: dstore})
+	require.NoError(t, err)
+	return r
+}
+
+func TestCacheRouter(t *testing.T) {
+	require := require.New(t)
+
+	inner := &countingRouter{}
+	r := newTestCacheRouter(t, inner, `{"NegativeTTL": "50ms", "MaxEntries": 2}`, nil)
+
+	for i := 0; i < 3; i++ {
+		require.Len(findProviders(t, r, "a"), 1)
+		require.Empty(findProviders(t, r, "b"))
+	}
+	require.Equal(2, inner.providerLookups)
+
+	pid, err := peer.Decode(testStaticPeer2)
+	require.NoError(err)
+	for i := 0; i < 2; i++ {
+		_, err := r.FindPeer(context.Background(), pid)
+		require.ErrorIs(err, routing.ErrNotFound)
+	}
+	require.Equal(1, inner.peerLookups)
+
+	// Misses are cached for NegativeTTL.
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(findProviders(t, r, "b"))
+	require.Len(findProviders(t, r, "a"), 1)
+	require.Equal(3, inner.providerLookups)
+
+	// The least recently used lookup is evicted.
+	require.Empty(findProviders(t, r, "c"))
+	require.Len(findProviders(t, r, "a"), 1)
+	require.Empty(findProviders(t, r, "b"))
+	require.Equal(5, inner.providerLookups)
+
+	stats := CacheStatsSnapshot()
+	require.Len(stats, 1)
+	require.Equal(CacheStats{
+		Name:           "cache",
+		ProviderHits:   6,
+		ProviderMisses: 5,
+		PeerHits:       1,
+		PeerMisses:     1,
+		NegativeHits:   3,
+		Providers:      2,
+		Peers:          1,
+	}, stats[0])
+}
+
+func TestCacheRouterPersistence(t *testing.T) {
+	require := require.New(t)
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	params := `{"Namespace": "/routing-cache"}`
+
+	inner := &countingRouter{}
+	r := newTestCacheRouter(t, inner, params, dstore)
+	require.Len(findProviders(t, r, "a"), 1)
+
+	// The lookups survive restarts.
+	inner = &countingRouter{}
+	r = newTestCacheRouter(t, inner, params, dstore)
+	provs := findProviders(t, r, "a")
+	require.Len(provs, 1)
+	require.Equal(testStaticPeer1, provs[0].ID.String())
+	require.Equal(0, inner.providerLookups)
+}
+
+func TestParserCacheError(t *testing.T) {
+	require := require.New(t)
+
+	methods := config.Methods{}
+	for _, m := range config.MethodNameList {
+		methods[m] = config.Method{RouterName: "cache"}
+	}
+	_, err := Parse(config.Routers{
+		"reframe": config.RouterParser{
+			Router: config.Router{
+				Type:       config.RouterTypeReframe,
+				Parameters: &config.ReframeRouterParams{Endpoint: "testEndpoint"},
+			},
+		},
+		"cache": config.RouterParser{
+			Router: config.Router{
+				Type: config.RouterTypeCache,
+				Parameters: &config.CacheRouterParams{
+					RouterName: "reframe",
+					Namespace:  "/routing-cache",
+				},
+			},
+		},
+	}, methods, &ExtraDHTParams{}, nil)
+	require.ErrorContains(err, "cache router needs a datastore")
+}
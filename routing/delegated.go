@@ -93,8 +93,25 @@ func parse(visited map[string]bool,
 	switch cfg.Type {
 	case config.RouterTypeReframe:
 		router, err = reframeRoutingFromConfig(cfg.Router, extraReframe)
+		if err == nil {
+			router = InstrumentProvides(routerName, router)
+		}
 	case config.RouterTypeDHT:
 		router, err = dhtRoutingFromConfig(cfg.Router, extraDHT)
+		if err == nil {
+			router = InstrumentProvides(routerName, router)
+		}
+	case config.RouterTypeStatic:
+		router, err = staticRoutingFromConfig(cfg.Router, extraDHT)
+	case config.RouterTypeCache:
+		params := cfg.Parameters.(*config.CacheRouterParams)
+		var ri routing.Routing
+		ri, err = parse(visited, createdRouters, params.RouterName, routersCfg, extraDHT, extraReframe)
+		if err != nil {
+			return nil, err
+		}
+
+		router, err = cacheRoutingFromConfig(routerName, cfg.Router, ri, extraDHT)
 	case config.RouterTypeParallel:
 		crp := cfg.Parameters.(*config.ComposableRouterParams)
 		var pr []*routinghelpers.ParallelRouter
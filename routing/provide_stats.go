@@ -0,0 +1,106 @@
+package routing
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+// ProvideStats are the statistics of the provide calls of a router.
+type ProvideStats struct {
+	Name        string
+	Calls       int64         // Provide and ProvideMany calls
+	Keys        int64         // keys announced, including failed calls
+	Failures    int64         // calls that returned an error
+	AvgLatency  time.Duration // average duration of a call
+	LastLatency time.Duration // duration of the last call
+	LastError   string        `json:",omitempty"`
+}
+
+var (
+	provideStatsLk sync.Mutex
+	provideStats   = make(map[string]*ProvideStats)
+)
+
+// ProvideStatsSnapshot returns the statistics of the routers instrumented
+// with InstrumentProvides, sorted by name.
+func ProvideStatsSnapshot() []ProvideStats {
+	provideStatsLk.Lock()
+	defer provideStatsLk.Unlock()
+	out := make([]ProvideStats, 0, len(provideStats))
+	for _, s := range provideStats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func recordProvide(name string, keys int, start time.Time, err error) {
+	d := time.Since(start)
+
+	provideStatsLk.Lock()
+	defer provideStatsLk.Unlock()
+	s, ok := provideStats[name]
+	if !ok {
+		s = &ProvideStats{Name: name}
+		provideStats[name] = s
+	}
+	s.AvgLatency = (s.AvgLatency*time.Duration(s.Calls) + d) / time.Duration(s.Calls+1)
+	s.Calls++
+	s.Keys += int64(keys)
+	s.LastLatency = d
+	if err != nil {
+		s.Failures++
+		s.LastError = err.Error()
+	}
+}
+
+// InstrumentProvides wraps r to record the latency and failures of its
+// provide calls under name, for 'ipfs stats provide'. The wrapper supports
+// ProvideMany when r does.
+func InstrumentProvides(name string, r routing.Routing) routing.Routing {
+	ir := &instrumentedRouter{Routing: r, name: name}
+	if pmr, ok := r.(routinghelpers.ProvideManyRouter); ok {
+		return &instrumentedProvideManyRouter{instrumentedRouter: ir, pmr: pmr}
+	}
+	return ir
+}
+
+type instrumentedRouter struct {
+	routing.Routing
+	name string
+}
+
+func (r *instrumentedRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce {
+		return r.Routing.Provide(ctx, c, announce)
+	}
+	start := time.Now()
+	err := r.Routing.Provide(ctx, c, announce)
+	recordProvide(r.name, 1, start, err)
+	return err
+}
+
+var _ routinghelpers.ProvideManyRouter = &instrumentedProvideManyRouter{}
+
+type instrumentedProvideManyRouter struct {
+	*instrumentedRouter
+	pmr routinghelpers.ProvideManyRouter
+}
+
+func (r *instrumentedProvideManyRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	start := time.Now()
+	err := r.pmr.ProvideMany(ctx, keys)
+	recordProvide(r.name, len(keys), start, err)
+	return err
+}
+
+func (r *instrumentedProvideManyRouter) Ready() bool {
+	return r.pmr.Ready()
+}
@@ -0,0 +1,526 @@
+package routing
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	host "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ routing.Routing = &staticRouter{}
+var _ routinghelpers.ProvideManyRouter = &staticRouter{}
+
+// staticRecordsCleanupInterval is how often the expired provide records of
+// static routers are removed.
+const staticRecordsCleanupInterval = time.Hour
+
+// staticRouter answers provider and peer lookups from a local table, loaded
+// from a file and filled by Provide calls. It is meant for networks where the
+// location of content is known in advance, composed with other routers in
+// parallel or sequential routers.
+type staticRouter struct {
+	host       host.Host           // records provides for this host, if any
+	path       string              // file of the table, if any
+	records    datastore.Datastore // provided records, if kept in the datastore
+	provideTTL time.Duration
+
+	mu        sync.RWMutex
+	table     *staticTable
+	modTime   time.Time
+	size      int64
+	providers map[string]map[peer.ID]time.Time // expiration of provided records, if kept in memory
+}
+
+// staticRecord is a provided record, as stored in the datastore.
+type staticRecord struct {
+	Expires time.Time
+}
+
+// staticTable is the content of the file of a static router.
+type staticTable struct {
+	providers map[string][]peer.ID // by multihash
+	peers     map[peer.ID][]ma.Multiaddr
+}
+
+// staticTableJSON is the format of JSON tables:
+//
+//	{
+//	  "Providers": { "<cid>": ["<peer id>", ...] },
+//	  "Peers": { "<peer id>": ["<multiaddr>", ...] }
+//	}
+type staticTableJSON struct {
+	Providers map[string][]string
+	Peers     map[string][]string
+}
+
+func staticRoutingFromConfig(conf config.Router, extra *ExtraDHTParams) (routing.Routing, error) {
+	params, ok := conf.Parameters.(*config.StaticRouterParams)
+	if !ok {
+		return nil, errors.New("incorrect params for static router")
+	}
+	if params.Path == "" && params.Namespace == "" {
+		return nil, NewParamNeededErr("Path or Namespace", conf.Type)
+	}
+	if extra == nil {
+		extra = &ExtraDHTParams{}
+	}
+
+	r := &staticRouter{
+		host:       extra.Host,
+		provideTTL: params.ProvideTTL.WithDefault(config.DefaultStaticRouterProvideTTL),
+		providers:  make(map[string]map[peer.ID]time.Time),
+		table:      &staticTable{},
+	}
+	if r.provideTTL <= 0 {
+		return nil, errors.New("ProvideTTL of static routers must be positive")
+	}
+	ctx := extra.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if params.Namespace != "" {
+		if extra.Datastore == nil {
+			return nil, errors.New("static router needs a datastore to store records in a namespace")
+		}
+		r.records = namespace.Wrap(extra.Datastore, datastore.NewKey(params.Namespace))
+		if err := r.removeExpired(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if params.Namespace != "" || r.host != nil {
+		go r.cleanup(ctx, staticRecordsCleanupInterval)
+	}
+	if params.Path != "" {
+		r.path = params.Path
+		if !filepath.IsAbs(r.path) {
+			path, err := config.Path("", r.path)
+			if err != nil {
+				return nil, err
+			}
+			r.path = path
+		}
+		if err := r.reload(); err != nil {
+			return nil, err
+		}
+		go r.watch(ctx, params.ReloadInterval.WithDefault(config.DefaultStaticRouterReloadInterval))
+	}
+	return r, nil
+}
+
+// watch reloads the file of the table when it changes, until ctx is done.
+func (r *staticRouter) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.Errorf("failed to reload the static routing table %s: %s", r.path, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload loads the file of the table, unless it did not change since it was
+// last loaded. The previous table is kept when it is invalid.
+func (r *staticRouter) reload() error {
+	st, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := st.ModTime().Equal(r.modTime) && st.Size() == r.size
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var table *staticTable
+	if strings.EqualFold(filepath.Ext(r.path), ".csv") {
+		table, err = parseStaticTableCSV(f)
+	} else {
+		table, err = parseStaticTableJSON(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.table, r.modTime, r.size = table, st.ModTime(), st.Size()
+	r.mu.Unlock()
+	log.Infof("loaded the static routing table %s: %d keys, %d peers", r.path, len(table.providers), len(table.peers))
+	return nil
+}
+
+func parseStaticTableJSON(in io.Reader) (*staticTable, error) {
+	var tj staticTableJSON
+	if err := json.NewDecoder(in).Decode(&tj); err != nil {
+		return nil, err
+	}
+	t := &staticTable{
+		providers: make(map[string][]peer.ID, len(tj.Providers)),
+		peers:     make(map[peer.ID][]ma.Multiaddr, len(tj.Peers)),
+	}
+	for c, pids := range tj.Providers {
+		for _, pid := range pids {
+			if err := t.addProvider(c, pid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for pid, addrs := range tj.Peers {
+		if err := t.addPeer(pid, addrs); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// parseStaticTableCSV parses tables with a "<cid>,<peer id>[,<multiaddr>...]"
+// line per provider record. The CID may be empty to only list addresses,
+// and lines starting with # are ignored.
+func parseStaticTableCSV(in io.Reader) (*staticTable, error) {
+	t := &staticTable{
+		providers: make(map[string][]peer.ID),
+		peers:     make(map[peer.ID][]ma.Multiaddr),
+	}
+	cr := csv.NewReader(in)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: expected a CID and a peer ID", line)
+		}
+		if rec[0] != "" {
+			if err := t.addProvider(rec[0], rec[1]); err != nil {
+				return nil, err
+			}
+		}
+		if err := t.addPeer(rec[1], rec[2:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *staticTable) addProvider(c, pid string) error {
+	k, err := cid.Decode(c)
+	if err != nil {
+		return fmt.Errorf("invalid CID %q: %w", c, err)
+	}
+	id, err := peer.Decode(pid)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %q: %w", pid, err)
+	}
+	mh := string(k.Hash())
+	for _, p := range t.providers[mh] {
+		if p == id {
+			return nil
+		}
+	}
+	t.providers[mh] = append(t.providers[mh], id)
+	return nil
+}
+
+func (t *staticTable) addPeer(pid string, addrs []string) error {
+	id, err := peer.Decode(pid)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %q: %w", pid, err)
+	}
+	for _, a := range addrs {
+		m, err := ma.NewMultiaddr(a)
+		if err != nil {
+			return fmt.Errorf("invalid address %q of peer %s: %w", a, pid, err)
+		}
+		t.peers[id] = append(t.peers[id], m)
+	}
+	return nil
+}
+
+func (r *staticRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce {
+		return nil
+	}
+	return r.ProvideMany(ctx, []multihash.Multihash{c.Hash()})
+}
+
+// ProvideMany records this node as a provider of keys in the table, until
+// the provide TTL elapses.
+func (r *staticRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	if r.host == nil {
+		return routing.ErrNotSupported
+	}
+	self := r.host.ID()
+	expires := time.Now().Add(r.provideTTL)
+	if r.records != nil {
+		data, err := json.Marshal(&staticRecord{Expires: expires})
+		if err != nil {
+			return err
+		}
+		for _, mh := range keys {
+			if err := r.records.Put(ctx, recordKey(mh, self), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, mh := range keys {
+		pids, ok := r.providers[string(mh)]
+		if !ok {
+			pids = make(map[peer.ID]time.Time)
+			r.providers[string(mh)] = pids
+		}
+		pids[self] = expires
+	}
+	return nil
+}
+
+// cleanup removes the expired provide records every interval, until ctx is
+// done. Expired records are skipped by lookups, and removed here when their
+// keys are not looked up anymore.
+func (r *staticRouter) cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.removeExpiredProviders()
+			if r.records != nil {
+				if err := r.removeExpired(ctx); err != nil {
+					log.Errorf("failed to remove the expired static routing records: %s", err)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// removeExpiredProviders removes the expired records kept in memory.
+func (r *staticRouter) removeExpiredProviders() {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for mh := range r.providers {
+		r.removeExpiredLocked(mh, now)
+	}
+}
+
+// removeExpired removes the expired records kept in the datastore, including
+// the records which expired while the node was stopped.
+func (r *staticRouter) removeExpired(ctx context.Context) error {
+	res, err := r.records.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var expired []datastore.Key
+	for e := range res.Next() {
+		if e.Error != nil {
+			res.Close()
+			return e.Error
+		}
+		if !recordLive(e.Value, now) {
+			expired = append(expired, datastore.RawKey(e.Key))
+		}
+	}
+	res.Close()
+	for _, k := range expired {
+		if err := r.records.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLive tells whether the stored record data has not expired at now.
+// Records which cannot be decoded are expired.
+func recordLive(data []byte, now time.Time) bool {
+	var rec staticRecord
+	return json.Unmarshal(data, &rec) == nil && now.Before(rec.Expires)
+}
+
+func (r *staticRouter) Ready() bool {
+	return true
+}
+
+// recordKey is the datastore key of the record of pid providing mh.
+func recordKey(mh multihash.Multihash, pid peer.ID) datastore.Key {
+	return mhKey(mh).ChildString(pid.String())
+}
+
+func mhKey(mh multihash.Multihash) datastore.Key {
+	return datastore.NewKey(mhString(mh))
+}
+
+// mhString encodes mh in base32, for keys.
+func mhString(mh multihash.Multihash) string {
+	s, _ := multibase.Encode(multibase.Base32, mh)
+	return s
+}
+
+func (r *staticRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	mh := c.Hash()
+
+	var pids []peer.ID
+	seen := make(map[peer.ID]bool)
+	add := func(pid peer.ID) {
+		if !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+
+	now := time.Now()
+	var expired bool
+	r.mu.RLock()
+	table := r.table
+	for _, pid := range table.providers[string(mh)] {
+		add(pid)
+	}
+	for pid, expires := range r.providers[string(mh)] {
+		if now.Before(expires) {
+			add(pid)
+		} else {
+			expired = true
+		}
+	}
+	r.mu.RUnlock()
+	if expired {
+		r.removeExpiredRecords(mh, now)
+	}
+
+	if r.records != nil {
+		res, err := r.records.Query(ctx, query.Query{Prefix: mhKey(mh).String() + "/"})
+		if err == nil {
+			var expiredKeys []datastore.Key
+			for e := range res.Next() {
+				if e.Error != nil {
+					log.Errorf("failed to query the static routing records: %s", e.Error)
+					break
+				}
+				k := datastore.RawKey(e.Key)
+				if !recordLive(e.Value, now) {
+					expiredKeys = append(expiredKeys, k)
+					continue
+				}
+				if pid, err := peer.Decode(k.BaseNamespace()); err == nil {
+					add(pid)
+				}
+			}
+			res.Close()
+			for _, k := range expiredKeys {
+				if err := r.records.Delete(ctx, k); err != nil {
+					log.Errorf("failed to remove an expired static routing record: %s", err)
+				}
+			}
+		} else {
+			log.Errorf("failed to query the static routing records: %s", err)
+		}
+	}
+
+	if count > 0 && len(pids) > count {
+		pids = pids[:count]
+	}
+	out := make(chan peer.AddrInfo, len(pids))
+	for _, pid := range pids {
+		out <- r.addrInfo(table, pid)
+	}
+	close(out)
+	return out
+}
+
+// removeExpiredRecords removes the expired records of mh kept in memory.
+func (r *staticRouter) removeExpiredRecords(mh multihash.Multihash, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeExpiredLocked(string(mh), now)
+}
+
+// removeExpiredLocked removes the expired records of the multihash mh kept
+// in memory. r.mu must be held.
+func (r *staticRouter) removeExpiredLocked(mh string, now time.Time) {
+	pids := r.providers[mh]
+	for pid, expires := range pids {
+		if !now.Before(expires) {
+			delete(pids, pid)
+		}
+	}
+	if len(pids) == 0 {
+		delete(r.providers, mh)
+	}
+}
+
+func (r *staticRouter) addrInfo(table *staticTable, pid peer.ID) peer.AddrInfo {
+	if r.host != nil && pid == r.host.ID() {
+		return peer.AddrInfo{ID: pid, Addrs: r.host.Addrs()}
+	}
+	return peer.AddrInfo{ID: pid, Addrs: table.peers[pid]}
+}
+
+func (r *staticRouter) FindPeer(ctx context.Context, pid peer.ID) (peer.AddrInfo, error) {
+	r.mu.RLock()
+	table := r.table
+	r.mu.RUnlock()
+	if _, ok := table.peers[pid]; !ok {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	return r.addrInfo(table, pid), nil
+}
+
+func (r *staticRouter) PutValue(context.Context, string, []byte, ...routing.Option) error {
+	return routing.ErrNotSupported
+}
+
+func (r *staticRouter) GetValue(context.Context, string, ...routing.Option) ([]byte, error) {
+	return nil, routing.ErrNotSupported
+}
+
+func (r *staticRouter) SearchValue(context.Context, string, ...routing.Option) (<-chan []byte, error) {
+	return nil, routing.ErrNotSupported
+}
+
+func (r *staticRouter) Bootstrap(context.Context) error {
+	return nil
+}
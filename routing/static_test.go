@@ -0,0 +1,166 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStaticPeer1 = "12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5"
+	testStaticPeer2 = "12D3KooWQF6Q3i1QkziJQ9mkNNcyFD8GPQz6R6oEvT75wgsVXm4v"
+)
+
+func findProviders(t *testing.T, r routing.Routing, content string) []peer.AddrInfo {
+	t.Helper()
+	var out []peer.AddrInfo
+	for ai := range r.FindProvidersAsync(context.Background(), merkledag.NewRawNode([]byte(content)).Cid(), 0) {
+		out = append(out, ai)
+	}
+	return out
+}
+
+func TestStaticRouterFile(t *testing.T) {
+	require := require.New(t)
+
+	c := merkledag.NewRawNode([]byte("a")).Cid()
+	path := filepath.Join(t.TempDir(), "table.json")
+	require.NoError(os.WriteFile(path, []byte(`{
+		"Providers": {"`+c.String()+`": ["`+testStaticPeer1+`"]},
+		"Peers": {"`+testStaticPeer1+`": ["/ip4/10.0.0.1/tcp/4001"]}
+	}`), 0o600))
+
+	r, err := staticRoutingFromConfig(config.Router{
+		Type:       config.RouterTypeStatic,
+		Parameters: &config.StaticRouterParams{Path: path},
+	}, nil)
+	require.NoError(err)
+
+	provs := findProviders(t, r, "a")
+	require.Len(provs, 1)
+	require.Equal(testStaticPeer1, provs[0].ID.String())
+	require.Equal("/ip4/10.0.0.1/tcp/4001", provs[0].Addrs[0].String())
+	require.Empty(findProviders(t, r, "b"))
+
+	pid, err := peer.Decode(testStaticPeer2)
+	require.NoError(err)
+	_, err = r.FindPeer(context.Background(), pid)
+	require.ErrorIs(err, routing.ErrNotFound)
+
+	// The file is reloaded when it changes, and kept when it is invalid.
+	csvPath := filepath.Join(t.TempDir(), "table.csv")
+	require.NoError(os.WriteFile(csvPath, []byte(strings.Join([]string{
+		"# cid,peer,addresses",
+		c.String() + "," + testStaticPeer1,
+		merkledag.NewRawNode([]byte("b")).Cid().String() + "," + testStaticPeer2 + ",/ip4/10.0.0.2/tcp/4001,/ip4/10.0.0.2/udp/4001/quic",
+		"," + testStaticPeer1 + ",/ip4/10.0.0.1/tcp/4001",
+	}, "\n")), 0o600))
+	sr := r.(*staticRouter)
+	sr.path = csvPath
+	require.NoError(sr.reload())
+	require.Len(findProviders(t, r, "a"), 1)
+	ai, err := r.FindPeer(context.Background(), pid)
+	require.NoError(err)
+	require.Len(ai.Addrs, 2)
+
+	require.NoError(os.WriteFile(csvPath, []byte("not,a valid table\n"), 0o600))
+	require.Error(sr.reload())
+	require.Len(findProviders(t, r, "b"), 1)
+}
+
+func TestStaticRouterProvide(t *testing.T) {
+	require := require.New(t)
+
+	h, err := mocknet.New().GenPeer()
+	require.NoError(err)
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	router, err := Parse(config.Routers{
+		"static": config.RouterParser{
+			Router: config.Router{
+				Type:       config.RouterTypeStatic,
+				Parameters: &config.StaticRouterParams{Namespace: "/static-routing"},
+			},
+		},
+	}, config.Methods{
+		config.MethodNameFindPeers:     config.Method{RouterName: "static"},
+		config.MethodNameFindProviders: config.Method{RouterName: "static"},
+		config.MethodNameGetIPNS:       config.Method{RouterName: "static"},
+		config.MethodNamePutIPNS:       config.Method{RouterName: "static"},
+		config.MethodNameProvide:       config.Method{RouterName: "static"},
+	}, &ExtraDHTParams{Host: h, Datastore: dstore}, nil)
+	require.NoError(err)
+
+	c := merkledag.NewRawNode([]byte("a")).Cid()
+	require.NoError(router.Provide(context.Background(), c, true))
+	provs := findProviders(t, router, "a")
+	require.Len(provs, 1)
+	require.Equal(h.ID(), provs[0].ID)
+
+	// The records are kept in the datastore.
+	router, err = staticRoutingFromConfig(config.Router{
+		Type:       config.RouterTypeStatic,
+		Parameters: &config.StaticRouterParams{Namespace: "/static-routing"},
+	}, &ExtraDHTParams{Datastore: dstore})
+	require.NoError(err)
+	require.Len(findProviders(t, router, "a"), 1)
+	require.Empty(findProviders(t, router, "b"))
+}
+
+func TestStaticRouterProvideExpires(t *testing.T) {
+	for _, namespace := range []string{"", "/static-routing"} {
+		t.Run("namespace="+namespace, func(t *testing.T) {
+			require := require.New(t)
+
+			h, err := mocknet.New().GenPeer()
+			require.NoError(err)
+			dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+			// Without a namespace, records are kept in memory next to a table.
+			params := &config.StaticRouterParams{Namespace: namespace}
+			if namespace == "" {
+				params.Path = filepath.Join(t.TempDir(), "table.json")
+				require.NoError(os.WriteFile(params.Path, []byte("{}"), 0o600))
+			}
+			require.NoError(json.Unmarshal([]byte(`{"ProvideTTL": "100ms"}`), params))
+			router, err := staticRoutingFromConfig(config.Router{
+				Type:       config.RouterTypeStatic,
+				Parameters: params,
+			}, &ExtraDHTParams{Host: h, Datastore: dstore})
+			require.NoError(err)
+
+			c := merkledag.NewRawNode([]byte("a")).Cid()
+			require.NoError(router.Provide(context.Background(), c, true))
+			require.Len(findProviders(t, router, "a"), 1)
+
+			time.Sleep(150 * time.Millisecond)
+			require.Empty(findProviders(t, router, "a"))
+
+			// Expired records are removed by lookups.
+			sr := router.(*staticRouter)
+			require.Empty(sr.providers)
+			keys, err := dstore.Query(context.Background(), query.Query{KeysOnly: true})
+			require.NoError(err)
+			all, err := keys.Rest()
+			require.NoError(err)
+			require.Empty(all)
+
+			// Providing again renews the records.
+			require.NoError(router.Provide(context.Background(), c, true))
+			require.Len(findProviders(t, router, "a"), 1)
+		})
+	}
+}
@@ -7,9 +7,9 @@ test_description="Test HTTP Gateway CAR (application/vnd.ipld.car) Support"
 test_init_ipfs
 test_launch_ipfs_daemon_without_network
 
-# CAR stream is not deterministic, as blocks can arrive in random order,
-# but if we have a small file that fits into a single block, and export its CID
-# we will get a CAR that is a deterministic array of bytes.
+# CAR responses for a path start with the blocks needed to verify the path, so
+# requesting the CID of a small file that fits into a single block, and
+# exporting that CID, gives the same deterministic array of bytes.
 
     test_expect_success "Create a deterministic CAR for testing" '
     mkdir -p subdir &&
@@ -38,27 +38,27 @@ test_launch_ipfs_daemon_without_network
 
     test_expect_success "GET with format=car param returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car" -o gateway-param.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID?format=car" -o gateway-param.car &&
     test_cmp deterministic.car gateway-param.car
     '
 
     test_expect_success "GET for application/vnd.ipld.car returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header.car &&
     test_cmp deterministic.car gateway-header.car
     '
 
     # explicit version=1
     test_expect_success "GET for application/vnd.ipld.raw version=1 returns a CARv1 stream" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car;version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header-v1.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car;version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header-v1.car &&
     test_cmp deterministic.car gateway-header-v1.car
     '
 
     # explicit version=1 with whitepace
     test_expect_success "GET for application/vnd.ipld.raw version=1 returns a CARv1 stream (with whitespace)" '
     ipfs dag import test-dag.car &&
-    curl -sX GET -H "Accept: application/vnd.ipld.car; version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" -o gateway-header-v1.car &&
+    curl -sX GET -H "Accept: application/vnd.ipld.car; version=1" "http://127.0.0.1:$GWAY_PORT/ipfs/$FILE_CID" -o gateway-header-v1.car &&
     test_cmp deterministic.car gateway-header-v1.car
     '
 
@@ -81,6 +81,20 @@ test_launch_ipfs_daemon_without_network
     ipfs dag stat --offline $ROOT_DIR_CID
     '
 
+# GET a path as CAR with dag-scope and entity-bytes
+
+    test_expect_success "GET with dag-scope=block returns the blocks of the path" '
+    ipfs dag import test-dag.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car&dag-scope=block" -o gateway-scope-block.car &&
+    ipfs dag import --stats --pin-roots=false gateway-scope-block.car > import_output &&
+    grep "Imported 3 blocks" import_output
+    '
+
+    test_expect_success "GET with an invalid dag-scope returns HTTP 400 Bad Request error" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID?format=car&dag-scope=foo" > curl_output 2>&1 &&
+    grep "400 Bad Request" curl_output
+    '
+
 # Make sure expected HTTP headers are returned with the CAR bytes
 
     test_expect_success "GET response for application/vnd.ipld.car has expected Content-Type" '